@@ -0,0 +1,281 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	configExportFormat      string
+	configExportShowSecrets bool
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "View and change the application configuration",
+	Long: `View and change the application configuration.
+Configuration values are addressed with dotted paths that match the keys
+in app.yaml, for example "mqtt.port" or "logging.level".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration with secrets redacted",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := config.ExportAppConfig(config.RedactAppConfig(cfg.App), "yaml")
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to show configuration: %s", err)))
+			os.Exit(1)
+		}
+
+		fmt.Print(string(data))
+	},
+}
+
+// configGetCmd represents the config get command
+var configGetCmd = &cobra.Command{
+	Use:   "get <path>",
+	Short: "Print a single configuration value",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		value, err := config.GetAppConfigValue(cfg.App, args[0])
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+			os.Exit(1)
+		}
+
+		fmt.Println(config.RedactValue(value, config.IsSecretPath(cfg.App, args[0])))
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set <path> <value>",
+	Short: "Change a single configuration value",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		loadConfigForChange("configuration")
+
+		changed, err := setConfigValue(args[0], args[1])
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+			os.Exit(1)
+		}
+
+		if !changed {
+			fmt.Println(text_style.ColorText(text_style.Yellow, fmt.Sprintf("%s is already set to this value", args[0])))
+			return
+		}
+
+		saveConfigChanges("configuration")
+	},
+}
+
+// configDiffCmd represents the config diff command
+var configDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the configuration values that differ from the defaults",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		changes := config.DiffAppConfig(config.DefaultAppConfig(), cfg.App)
+		if len(changes) == 0 {
+			fmt.Println(text_style.ColorText(text_style.Green, "The configuration matches the defaults"))
+			return
+		}
+
+		for _, change := range changes {
+			fmt.Printf("%s: %s -> %s\n",
+				text_style.BoldText(change.Path),
+				text_style.ColorText(text_style.Red, fmt.Sprint(config.RedactValue(change.OldValue, change.Secret))),
+				text_style.ColorText(text_style.Green, fmt.Sprint(config.RedactValue(change.NewValue, change.Secret))),
+			)
+		}
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:         "validate",
+	Annotations: map[string]string{appConfigOptional: "true"},
+	Short:       "Validate the configuration file",
+	Args:        cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := config.LoadAppConfig(); err != nil {
+			exitOnInvalidConfig("Invalid application configuration", err)
+		}
+
+		fmt.Println(text_style.ColorText(text_style.Green, "The configuration is valid"))
+	},
+}
+
+// configExportCmd represents the config export command
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the configuration as yaml, json or toml",
+	Long: `Export the configuration as yaml, json or toml.
+Secrets are redacted unless --show-secrets is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exported := config.RedactAppConfig(cfg.App)
+		if configExportShowSecrets {
+			exported = cfg.App
+		}
+
+		data, err := config.ExportAppConfig(exported, configExportFormat)
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to export configuration: %s", err)))
+			os.Exit(1)
+		}
+
+		fmt.Print(strings.TrimRight(string(data), "\n") + "\n")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configExportCmd)
+
+	configExportCmd.Flags().StringVar(&configExportFormat, "format", "yaml", "Export format (yaml, json or toml)")
+	configExportCmd.Flags().BoolVar(&configExportShowSecrets, "show-secrets", false, "Export the secrets as stored instead of redacted")
+}
+
+// setConfigValue sets a configuration value and reports whether it changed.
+// Clear text secrets are sealed with the local key file before they are stored.
+func setConfigValue(path, value string) (bool, error) {
+	oldValue, err := config.GetAppConfigValue(cfg.App, path)
+	if err != nil {
+		return false, err
+	}
+
+	if config.IsSecretPath(cfg.App, path) && value != "" && !config.Secret(value).IsReference() {
+		secret, ok := oldValue.(config.Secret)
+		if !ok {
+			return false, fmt.Errorf("%s holds a %T, not a secret", path, oldValue)
+		}
+
+		// Keep the existing value when the clear text did not change
+		if existing, err := secret.Resolve(); err == nil && existing == value {
+			return false, nil
+		}
+
+		encrypted, err := config.EncryptSecret(value)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+
+		value = string(encrypted)
+	}
+
+	if err := config.SetAppConfigValue(cfg.App, path, value); err != nil {
+		return false, err
+	}
+
+	newValue, err := config.GetAppConfigValue(cfg.App, path)
+	if err != nil {
+		return false, err
+	}
+
+	return !reflect.DeepEqual(oldValue, newValue), nil
+}
+
+// bindConfigFlags applies every flag that was set on the command line to the
+// configuration section with the same name. Flag names map to configuration
+// paths by replacing dashes with underscores, so --client-id on the mqtt
+// command sets mqtt.client_id.
+func bindConfigFlags(cmd *cobra.Command, section, name string) {
+	loadConfigForChange(name)
+
+	changed := false
+
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		// Skip the global flags inherited from the root command
+		if cmd.PersistentFlags().Lookup(flag.Name) == nil {
+			return
+		}
+
+		path := fmt.Sprintf("%s.%s", section, strings.ReplaceAll(flag.Name, "-", "_"))
+
+		flagChanged, err := setConfigValue(path, flag.Value.String())
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Cannot update %s: %s", name, err)))
+			os.Exit(1)
+		}
+
+		changed = changed || flagChanged
+	})
+
+	if changed {
+		saveConfigChanges(name)
+	}
+}
+
+// loadConfigForChange reads and validates the configuration files before they
+// are changed, and exits without writing anything when they cannot be read or
+// are invalid, so a change is never saved over a file that was not read
+func loadConfigForChange(name string) {
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		exitOnInvalidConfig(fmt.Sprintf("Cannot update %s", name), err)
+	}
+
+	cfg.App = appCfg
+}
+
+// saveConfigChanges validates and saves the application configuration
+func saveConfigChanges(name string) {
+	if err := config.ValidateAppConfig(cfg.App); err != nil {
+		exitOnInvalidConfig(fmt.Sprintf("Cannot update %s", name), err)
+	}
+
+	fmt.Printf("Updating %s -> ", name)
+
+	err := config.SaveConfig()
+	if err != nil {
+		var pathErr *fs.PathError
+		// Check if the error is of type *fs.PathError
+		if !errors.As(err, &pathErr) {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Cannot update %s: %s. Restart the program with the --init flag to initialize the config files to enable changes at runtime.", name, err.Error())))
+			os.Exit(1)
+		}
+	}
+
+	time.Sleep(time.Duration(utils.GetRandomNumber(100, 500)) * time.Millisecond)
+
+	fmt.Println(text_style.ColorText(text_style.Green, fmt.Sprintf("%s updated successfully", capitalize(name))))
+
+	time.Sleep(time.Duration(utils.GetRandomNumber(100, 500)) * time.Millisecond)
+}
+
+func capitalize(text string) string {
+	if text == "" {
+		return text
+	}
+
+	return strings.ToUpper(text[:1]) + text[1:]
+}
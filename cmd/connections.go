@@ -0,0 +1,228 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/spf13/cobra"
+)
+
+var (
+	connectionsFile    string
+	connectionsSince   string
+	connectionsUntil   string
+	connectionsSummary bool
+	connectionsName    string
+)
+
+// connectionsCmd represents the connections command
+var connectionsCmd = &cobra.Command{
+	Use:   "connections",
+	Short: "Report the MQTT connection history and uptime",
+	Long: `Report the MQTT connection history over a window: a timeline of events,
+the uptime percentage, the mean time between failures (MTBF), the longest outage
+and the number of disconnects per day.
+
+Disconnects caused by stopping the application or changing the configuration are
+not counted as failures. The report covers one connection, the mqtt section by
+default; use --connection to report on a named connection.
+
+Examples:
+  connections
+  connections --since 30d
+  connections --connection plant2
+  connections --since 2025-01-01T00:00:00Z --until 2025-02-01T00:00:00Z --summary`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseLogTime(connectionsSince)
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("invalid --since: %s", err)))
+			os.Exit(1)
+		}
+
+		to := time.Now()
+		if connectionsUntil != "" {
+			if to, err = parseLogTime(connectionsUntil); err != nil {
+				fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("invalid --until: %s", err)))
+				os.Exit(1)
+			}
+		}
+
+		if !from.Before(to) {
+			fmt.Println(text_style.ColorText(text_style.Red, "--since must be before --until"))
+			os.Exit(1)
+		}
+
+		filePath := connectionsFile
+		if filePath == "" {
+			filePath = cfg.ConnectionsFilePath
+		}
+
+		events, err := connections.ReadEvents(filePath)
+		if os.IsNotExist(err) {
+			fmt.Println(text_style.ColorText(text_style.Yellow, fmt.Sprintf("No connection history found at %s", filePath)))
+			return
+		}
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to read the connection history: %s", err)))
+			os.Exit(1)
+		}
+
+		events = connections.ForConnection(events, connectionsName, config.DefaultConnection)
+
+		report := connections.NewReport(events, from, to)
+
+		if !connectionsSummary {
+			printConnectionTimeline(report)
+		}
+
+		printConnectionSummary(report)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(connectionsCmd)
+
+	connectionsCmd.Flags().StringVar(&connectionsFile, "file", "", "Connection history file to read (defaults to connections/connections.log in the home directory)")
+	connectionsCmd.Flags().StringVar(&connectionsSince, "since", "7d", "Start of the window as an RFC 3339 time or duration ago, for example 24h or 30d")
+	connectionsCmd.Flags().StringVar(&connectionsUntil, "until", "", "End of the window as an RFC 3339 time or duration ago (defaults to now)")
+	connectionsCmd.Flags().StringVarP(&connectionsName, "connection", "c", config.DefaultConnection, "Name of the connection to report on")
+	connectionsCmd.Flags().BoolVar(&connectionsSummary, "summary", false, "Only print the summary, without the timeline")
+}
+
+// printConnectionTimeline prints the events in the window
+func printConnectionTimeline(report *connections.Report) {
+	fmt.Println(text_style.BoldText("Timeline"))
+
+	if len(report.Events) == 0 {
+		fmt.Println("  No events in this window")
+		fmt.Println()
+		return
+	}
+
+	for _, event := range report.Events {
+		var details []string
+
+		switch event.Type {
+		case connections.EventConnect:
+			details = append(details, "connected")
+			if event.Broker != "" {
+				details[0] += fmt.Sprintf(" to %s", event.Broker)
+			}
+			if event.ClientID != "" {
+				details = append(details, fmt.Sprintf("client_id=%s", event.ClientID))
+			}
+		case connections.EventDisconnect:
+			details = append(details, "disconnected")
+			if event.Broker != "" {
+				details[0] += fmt.Sprintf(" from %s", event.Broker)
+			}
+		case connections.EventReconnectAttempt:
+			details = append(details, fmt.Sprintf("connection attempt %d to %s failed", event.Attempt, event.Broker))
+		case connections.EventBrokerSwitch:
+			details = append(details, fmt.Sprintf("broker changed from %s to %s", event.PreviousBroker, event.Broker))
+		case connections.EventAppStart:
+			details = append(details, "application started")
+		case connections.EventAppStop:
+			details = append(details, "application stopped")
+		}
+
+		if event.Reason != "" {
+			details = append(details, fmt.Sprintf("reason: %s", event.Reason))
+		}
+
+		fmt.Printf("  %s  %s  %s\n",
+			event.Time.Local().Format("2006-01-02 15:04:05"),
+			text_style.ColorText(connectionEventColor(event), fmt.Sprintf("%-17s", event.Type)),
+			strings.Join(details, ", "),
+		)
+	}
+
+	fmt.Println()
+}
+
+// printConnectionSummary prints the uptime statistics of the window
+func printConnectionSummary(report *connections.Report) {
+	fmt.Println(text_style.BoldText("Summary"))
+	fmt.Printf("  Window:          %s - %s\n", report.From.Local().Format("2006-01-02 15:04:05"), report.To.Local().Format("2006-01-02 15:04:05"))
+
+	uptimeColor := text_style.Green
+	if report.UptimePercent() < 99 {
+		uptimeColor = text_style.Yellow
+	}
+	if report.UptimePercent() < 90 {
+		uptimeColor = text_style.Red
+	}
+
+	fmt.Printf("  Uptime:          %s (%s connected, %s disconnected)\n",
+		text_style.ColorText(uptimeColor, fmt.Sprintf("%.2f%%", report.UptimePercent())),
+		formatReportDuration(report.Uptime),
+		formatReportDuration(report.Downtime),
+	)
+
+	fmt.Printf("  Failures:        %d\n", report.Failures)
+
+	if mtbf := report.MTBF(); mtbf > 0 {
+		fmt.Printf("  MTBF:            %s\n", formatReportDuration(mtbf))
+	} else {
+		fmt.Printf("  MTBF:            n/a (no failures)\n")
+	}
+
+	if outage, ok := report.LongestOutage(); ok {
+		line := fmt.Sprintf("%s (%s - %s)", formatReportDuration(outage.Duration()), outage.Start.Local().Format("2006-01-02 15:04:05"), outage.End.Local().Format("2006-01-02 15:04:05"))
+		if outage.Reason != "" {
+			line += fmt.Sprintf(", reason: %s", outage.Reason)
+		}
+		fmt.Printf("  Longest outage:  %s\n", line)
+	} else {
+		fmt.Printf("  Longest outage:  n/a\n")
+	}
+
+	fmt.Println()
+	fmt.Println(text_style.BoldText("Disconnects per day"))
+
+	if len(report.DisconnectsPerDay) == 0 {
+		fmt.Println("  None")
+		return
+	}
+
+	for _, day := range report.DisconnectsPerDay {
+		fmt.Printf("  %s  %d\n", day.Day.Format("2006-01-02"), day.Disconnects)
+	}
+}
+
+func connectionEventColor(event connections.Event) string {
+	switch event.Type {
+	case connections.EventConnect:
+		return text_style.Green
+	case connections.EventDisconnect:
+		if connections.PlannedDisconnect(event.Reason) {
+			return text_style.Yellow
+		}
+		return text_style.Red
+	case connections.EventReconnectAttempt:
+		return text_style.Yellow
+	default:
+		return text_style.Blue
+	}
+}
+
+// formatReportDuration formats a duration with days, rounded to seconds
+func formatReportDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+
+	days := d / (24 * time.Hour)
+	if days == 0 {
+		return d.String()
+	}
+
+	return fmt.Sprintf("%dd%s", days, (d - days*24*time.Hour).String())
+}
@@ -6,47 +6,333 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
 	"github.com/spf13/cobra"
 )
 
+var (
+	healthJSON         bool
+	healthHistory      bool
+	healthHistoryLimit int
+)
+
+// healthTimeFormat is the layout of the times in the health output
+const healthTimeFormat = "2006-01-02 15:04:05"
+
 // healthCmd represents the health command
 var healthCmd = &cobra.Command{
 	Use:   "health",
 	Short: "View the health of the system",
 	Long: `The health command is used to view the health of the system.
-It will display the raw JSON content of the persist.json file.`,
+It shows the saved state of the application, each MQTT connection with its
+subscriptions, and the message pipeline, followed by the most recent
+transitions of the keys listed in persist.history.keys.
+
+State saved by an older version is migrated before it is shown. The
+state file itself is not changed.
+
+Use --json for the typed state as JSON, or --history for all recorded
+transitions.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Read the content of the JSON file
-		filePath := "./persist/persist.json"
-		data, err := os.ReadFile(filePath)
+		snapshot, err := persist.Snapshot(cfg.App.Persist.Backend, cfg.PersistFilePath)
 		if err != nil {
-			log.Fatalf("Error reading file: %v", err)
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to read the state: %s", err)))
+			os.Exit(1)
 		}
 
-		// Declare a variable to store the raw JSON
-		var raw json.RawMessage
-		if err := json.Unmarshal(data, &raw); err != nil {
-			log.Fatalf("Error unmarshalling JSON: %v", err)
+		if _, err := state.Migrate(snapshot); err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to read the state: %s", err)))
+			os.Exit(1)
 		}
 
-		// Print the raw JSON (as bytes)
-		fmt.Println("Raw JSON:", string(raw))
+		if healthHistory {
+			printStateHistory(snapshot, healthHistoryLimit)
+			return
+		}
+
+		current, err := state.Load(snapshot)
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Yellow, fmt.Sprintf("Part of the state could not be read: %s", err)))
+		}
+
+		if healthJSON {
+			data, err := json.MarshalIndent(current, "", "  ")
+			if err != nil {
+				fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to encode the state: %s", err)))
+				os.Exit(1)
+			}
+
+			fmt.Println(string(data))
+			return
+		}
+
+		printHealth(current)
+		printRecentTransitions(snapshot, 5)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(healthCmd)
 
-	// Here you will define your flags and configuration settings.
+	healthCmd.Flags().BoolVar(&healthJSON, "json", false, "Print the state as JSON")
+	healthCmd.Flags().BoolVar(&healthHistory, "history", false, "Show the recent transitions of the tracked state keys")
+	healthCmd.Flags().IntVarP(&healthHistoryLimit, "limit", "n", 10, "Number of transitions to show per key")
+}
+
+// printHealth prints the state in a human-friendly layout
+func printHealth(current *state.State) {
+	app := current.App
+	fmt.Println(text_style.BoldText("Application"))
+	printHealthField("Status", colorStatus(app.Status))
+	printHealthField("Name", app.Name)
+	printHealthField("Version", app.Version)
+	printHealthField("Environment", app.Environment)
+	printHealthField("Config files", strings.Join(app.ConfigFiles, ", "))
+	printHealthField("Started", formatHealthTime(app.StartTime))
+	if app.Status == state.StatusRunning && app.StartTime != nil {
+		printHealthField("Uptime", time.Since(*app.StartTime).Round(time.Second).String())
+	} else if app.EndTime != nil {
+		printHealthField("Stopped", formatHealthTime(app.EndTime))
+		printHealthField("Ran for", time.Duration(app.Duration).Round(time.Second).String())
+	}
+
+	for _, name := range connectionNames(current.Connections) {
+		connection := current.Connections[name]
+		fmt.Println()
+		fmt.Println(text_style.BoldText(fmt.Sprintf("MQTT connection %s", name)))
+		printHealthField("Status", colorStatus(connection.Status))
+		printHealthField("Broker", connection.Broker)
+		printHealthField("Client ID", connection.ClientID)
+		printHealthField("Topic", connection.Topic)
+		printHealthField("Connected", formatHealthTime(connection.StartTime))
+		if connection.Status == state.StatusConnected && connection.StartTime != nil {
+			printHealthField("Connected for", time.Since(*connection.StartTime).Round(time.Second).String())
+		} else if connection.EndTime != nil {
+			printHealthField("Disconnected", formatHealthTime(connection.EndTime))
+			if connection.Duration > 0 {
+				printHealthField("Was connected for", time.Duration(connection.Duration).Round(time.Second).String())
+			}
+		}
+		if connection.LastDisconnectReason != "" {
+			printHealthField("Last disconnect", connection.LastDisconnectReason)
+		}
+
+		fmt.Println("  Subscriptions:")
+		if len(connection.Subscriptions) == 0 {
+			fmt.Println("    None")
+		}
+		for _, subscription := range connection.Subscriptions {
+			line := fmt.Sprintf("%s  qos %d  %s  %d messages", subscription.Topic, subscription.Qos, colorStatus(subscription.Status), subscription.MessagesReceived)
+			if subscription.LastMessageAt != nil {
+				line += fmt.Sprintf(", last at %s", formatHealthTime(subscription.LastMessageAt))
+			}
+			fmt.Printf("    %s\n", line)
+		}
+	}
+
+	pipeline := current.Pipeline
+	fmt.Println()
+	fmt.Println(text_style.BoldText("Pipeline"))
+	printHealthField("Received", fmt.Sprint(pipeline.Received))
+	printHealthField("Published", fmt.Sprint(pipeline.Published))
+	printHealthField("Dropped", fmt.Sprint(pipeline.Dropped))
+	printHealthField("  Duplicates", fmt.Sprint(pipeline.Filtered.Duplicates))
+	printHealthField("  Deadband", fmt.Sprint(pipeline.Filtered.Deadband))
+	printHealthField("  Rate limited", fmt.Sprint(pipeline.Filtered.RateLimited))
+	printHealthField("Last message", formatHealthTime(pipeline.LastMessageAt))
+
+	if bridge := current.Bridge; bridge.Status != "" {
+		fmt.Println()
+		fmt.Println(text_style.BoldText("Bridge"))
+		printHealthField("Status", colorStatus(bridge.Status))
+		printHealthField("Destination", bridge.Broker)
+		printHealthField("Forwarded", fmt.Sprint(bridge.Forwarded))
+		printHealthField("Failed", fmt.Sprint(bridge.Failed))
+		printHealthField("Loops prevented", fmt.Sprint(bridge.Looped))
+		if bridge.Batches > 0 {
+			printHealthField("Batches", fmt.Sprint(bridge.Batches))
+		}
+		printHealthField("Last forward", formatHealthTime(bridge.LastForwardAt))
+	}
+
+	if aggregate := current.Aggregate; aggregate.Summaries > 0 || aggregate.Late > 0 {
+		fmt.Println()
+		fmt.Println(text_style.BoldText("Aggregation"))
+		printHealthField("Summaries", fmt.Sprint(aggregate.Summaries))
+		printHealthField("Late values", fmt.Sprint(aggregate.Late))
+		printHealthField("Last summary", formatHealthTime(aggregate.LastSummaryAt))
+	}
+
+	if len(current.Devices) > 0 {
+		online, silent := countDevices(current.Devices)
+
+		fmt.Println()
+		fmt.Println(text_style.BoldText(fmt.Sprintf("Devices (%d online, %d silent)", online, silent)))
+		for _, device := range current.Devices {
+			line := fmt.Sprintf("%-24s %s  last seen %s, expected every %s", device.ID, colorStatus(device.Status)+strings.Repeat(" ", max(0, 6-len(device.Status))), formatHealthTime(device.LastSeen), device.Interval)
+			if device.Status == state.StatusSilent && device.LastSeen != nil {
+				line += fmt.Sprintf(", silent for %s", time.Since(*device.LastSeen).Round(time.Second))
+			}
+			fmt.Printf("  %s\n", line)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println(text_style.BoldText("Active alarms"))
+	if len(current.Alarms) == 0 {
+		fmt.Println("  None")
+	}
+	for _, alarm := range current.Alarms {
+		fmt.Printf("  %s  %s  %s  %s\n", alarm.RaisedAt.Local().Format(healthTimeFormat), colorSeverity(alarm.Severity), alarm.Rule, alarm.Message)
+	}
+}
+
+// printRecentTransitions prints the latest transitions across all tracked keys
+func printRecentTransitions(snapshot persist.Persister, limit int) {
+	type transition struct {
+		key   string
+		entry persist.HistoryEntry
+	}
+
+	var transitions []transition
+	for _, key := range persist.HistoryKeys(snapshot) {
+		for _, entry := range persist.History(snapshot, key) {
+			transitions = append(transitions, transition{key: key, entry: entry})
+		}
+	}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// healthCmd.PersistentFlags().String("foo", "", "A help for foo")
+	if len(transitions) == 0 {
+		return
+	}
+
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].entry.Time.Before(transitions[j].entry.Time)
+	})
+
+	if len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+
+	fmt.Println()
+	fmt.Println(text_style.BoldText("Recent transitions"))
+	for _, t := range transitions {
+		fmt.Printf("  %s  %-20s  %s\n", t.entry.Time.Local().Format(healthTimeFormat), t.key, colorStatus(fmt.Sprint(t.entry.Value)))
+	}
+}
+
+// printStateHistory prints the most recent transitions of every tracked key
+func printStateHistory(snapshot persist.Persister, limit int) {
+	keys := persist.HistoryKeys(snapshot)
+	if len(keys) == 0 {
+		fmt.Println(text_style.ColorText(text_style.Yellow, "No state history recorded. Add keys to persist.history.keys to record their transitions."))
+		return
+	}
+
+	for i, key := range keys {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Println(text_style.BoldText(key))
+
+		entries := persist.History(snapshot, key)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+
+		for j, entry := range entries {
+			since := "now"
+			if j+1 < len(entries) {
+				since = entries[j+1].Time.Local().Format(healthTimeFormat)
+			}
+
+			fmt.Printf("  %s - %-19s  %v\n", entry.Time.Local().Format(healthTimeFormat), since, entry.Value)
+		}
+	}
+}
+
+func printHealthField(name, value string) {
+	if value == "" {
+		value = "-"
+	}
+
+	fmt.Printf("  %-18s %s\n", name+":", value)
+}
+
+func formatHealthTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Local().Format(healthTimeFormat)
+}
+
+// connectionNames returns the names of the connections, the default
+// connection first and the others sorted
+func connectionNames(connections map[string]state.ConnectionState) []string {
+	names := make([]string, 0, len(connections))
+	for name := range connections {
+		if name != config.DefaultConnection {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if _, ok := connections[config.DefaultConnection]; ok {
+		names = append([]string{config.DefaultConnection}, names...)
+	}
+
+	return names
+}
+
+// countDevices counts the online and silent devices
+func countDevices(devices []state.DeviceState) (online, silent int) {
+	for _, device := range devices {
+		if device.Status == state.StatusSilent {
+			silent++
+		} else {
+			online++
+		}
+	}
+
+	return online, silent
+}
+
+// colorSeverity colours an alarm severity, padded to line up the columns
+func colorSeverity(severity string) string {
+	padded := fmt.Sprintf("%-8s", severity)
+
+	switch severity {
+	case "critical", "major":
+		return text_style.ColorText(text_style.Red, padded)
+	case "minor", "warning":
+		return text_style.ColorText(text_style.Yellow, padded)
+	default:
+		return text_style.ColorText(text_style.Blue, padded)
+	}
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// healthCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+// colorStatus colours a status by whether it is healthy
+func colorStatus(status string) string {
+	switch status {
+	case state.StatusRunning, state.StatusConnected, state.StatusSubscribed, state.StatusOnline:
+		return text_style.ColorText(text_style.Green, status)
+	case state.StatusStopped, state.StatusInactive:
+		return text_style.ColorText(text_style.Yellow, status)
+	case state.StatusDisconnected, state.StatusSilent:
+		return text_style.ColorText(text_style.Red, status)
+	case "":
+		return ""
+	default:
+		return status
+	}
 }
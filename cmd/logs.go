@@ -0,0 +1,285 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/engine"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	logsFile   string
+	logsLevel  string
+	logsLogger string
+	logsSince  string
+	logsUntil  string
+	logsFields []string
+	logsFollow bool
+	logsTail   int
+	logsRaw    bool
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Query and follow the application log",
+	Long: `Query and follow the JSON application log, including rotated and compressed backups.
+
+Examples:
+  logs --level warn --since 2h
+  logs --logger mqtt --field topic=bms/x
+  logs --since 2025-01-01T08:00:00Z --until 2025-01-01T09:00:00Z
+  logs --tail 50 --follow`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, err := parseLogFilter()
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+			os.Exit(1)
+		}
+
+		paths := logFilePaths()
+		if len(paths) == 0 {
+			fmt.Println(text_style.ColorText(text_style.Yellow, "No log files are configured"))
+			return
+		}
+
+		if err := printLogHistory(paths, filter); err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to read logs: %s", err)))
+			os.Exit(1)
+		}
+
+		if logsFollow {
+			followLogs(paths, filter)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringVar(&logsFile, "file", "", "Log file to read (defaults to the file outputs in the logging configuration)")
+	logsCmd.Flags().StringVarP(&logsLevel, "level", "l", "", "Minimum level to show")
+	logsCmd.Flags().StringVar(&logsLogger, "logger", "", "Only show entries of this logger, for example mqtt")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Only show entries after this time (RFC 3339) or duration ago, for example 2h or 7d")
+	logsCmd.Flags().StringVar(&logsUntil, "until", "", "Only show entries before this time (RFC 3339) or duration ago")
+	logsCmd.Flags().StringArrayVar(&logsFields, "field", nil, "Only show entries where a field has a value, for example topic=bms/x (repeatable)")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep printing new entries as they are written")
+	logsCmd.Flags().IntVarP(&logsTail, "tail", "n", 0, "Only show the last n matching entries")
+	logsCmd.Flags().BoolVar(&logsRaw, "raw", false, "Print the raw JSON lines")
+}
+
+// parseLogFilter builds the log filter from the flags
+func parseLogFilter() (logging.LogFilter, error) {
+	filter := logging.LogFilter{Logger: logsLogger}
+
+	if logsLevel != "" {
+		level, err := zapcore.ParseLevel(logsLevel)
+		if err != nil {
+			return filter, fmt.Errorf("invalid level %q", logsLevel)
+		}
+		filter.MinLevel = &level
+	}
+
+	var err error
+	if filter.Since, err = parseLogTime(logsSince); err != nil {
+		return filter, fmt.Errorf("invalid --since: %w", err)
+	}
+
+	if filter.Until, err = parseLogTime(logsUntil); err != nil {
+		return filter, fmt.Errorf("invalid --until: %w", err)
+	}
+
+	if len(logsFields) > 0 {
+		filter.Fields = map[string]string{}
+		for _, field := range logsFields {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || key == "" {
+				return filter, fmt.Errorf("invalid --field %q, expected key=value", field)
+			}
+			filter.Fields[key] = value
+		}
+	}
+
+	return filter, nil
+}
+
+// parseLogTime parses an RFC 3339 time or a duration before now
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := parseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseDuration parses a Go duration, also accepting whole days such as 7d
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// logFilePaths returns the log files to read
+func logFilePaths() []string {
+	if logsFile != "" {
+		return []string{logsFile}
+	}
+
+	var paths []string
+	seen := map[string]bool{}
+	for _, path := range engine.NewLoggingConfig(cfg).FilePaths() {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// printLogHistory prints the matching entries of the log files and their backups
+func printLogHistory(paths []string, filter logging.LogFilter) error {
+	var entries []logging.LogEntry
+
+	for _, path := range paths {
+		files, err := logging.LogFiles(path)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			err := logging.ReadLogFile(file, filter, func(entry logging.LogEntry) {
+				entries = append(entries, entry)
+				if logsTail > 0 && len(paths) == 1 && len(entries) > logsTail*2 {
+					entries = append(entries[:0], entries[len(entries)-logsTail:]...)
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Interleave the entries of several log files by time
+	if len(paths) > 1 {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Time.Before(entries[j].Time)
+		})
+	}
+
+	if logsTail > 0 && len(entries) > logsTail {
+		entries = entries[len(entries)-logsTail:]
+	}
+
+	for _, entry := range entries {
+		printLogEntry(entry)
+	}
+
+	return nil
+}
+
+// followLogs prints new entries until the command is interrupted
+func followLogs(paths []string, filter logging.LogFilter) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+
+			err := logging.FollowLogFile(ctx, path, filter, 500*time.Millisecond, func(entry logging.LogEntry) {
+				mu.Lock()
+				defer mu.Unlock()
+				printLogEntry(entry)
+			})
+			if err != nil {
+				fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Stopped following %s: %s", path, err)))
+			}
+		}(path)
+	}
+
+	wg.Wait()
+}
+
+// printLogEntry prints an entry in the colour scheme of the console logger
+func printLogEntry(entry logging.LogEntry) {
+	if logsRaw {
+		fmt.Println(entry.Raw)
+		return
+	}
+
+	var parts []string
+
+	if !entry.Time.IsZero() {
+		parts = append(parts, entry.Time.Local().Format("2006-01-02T15:04:05.000Z0700"))
+	}
+
+	parts = append(parts, text_style.ColorText(logLevelColor(entry.Level), entry.Level.CapitalString()))
+
+	if entry.Logger != "" {
+		parts = append(parts, entry.Logger)
+	}
+
+	if entry.Caller != "" {
+		parts = append(parts, entry.Caller)
+	}
+
+	parts = append(parts, entry.Msg)
+
+	// The build information is added to every JSON line, so leave it out
+	delete(entry.Fields, "git_revision")
+	delete(entry.Fields, "go_version")
+
+	if len(entry.Fields) > 0 {
+		if data, err := json.Marshal(entry.Fields); err == nil {
+			parts = append(parts, string(data))
+		}
+	}
+
+	fmt.Println(strings.Join(parts, "\t"))
+}
+
+// logLevelColor matches the colours of zapcore.CapitalColorLevelEncoder
+func logLevelColor(level zapcore.Level) string {
+	switch level {
+	case zapcore.DebugLevel:
+		return text_style.Magenta
+	case zapcore.InfoLevel:
+		return text_style.Blue
+	case zapcore.WarnLevel:
+		return text_style.Yellow
+	default:
+		return text_style.Red
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/spf13/cobra"
+)
+
+var restartTimeout time.Duration
+
+// restartCmd represents the restart command
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the Rubicon BMS MQTT Client in the background",
+	Long: `This command stops the running instance, if any, and starts the
+Rubicon BMS MQTT Client again in the background.
+
+The configuration is checked before the running instance is stopped. When
+the client runs as a systemd service, use systemctl restart instead.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := config.LoadAppConfig(); err != nil {
+			exitOnInvalidConfig("Invalid application configuration", err)
+		}
+
+		loadConfig()
+
+		if err := stopRunning(restartTimeout); err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+			os.Exit(1)
+		}
+
+		startDetached()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restartCmd)
+
+	restartCmd.Flags().DurationVarP(&restartTimeout, "timeout", "t", 30*time.Second, "How long to wait for the application to stop")
+}
@@ -4,6 +4,7 @@ Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -20,6 +21,8 @@ var (
 	rootInitConfig  bool
 	rootEnvironment string
 	rootDebugMode   bool
+	rootHome        string
+	rootInstance    string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -32,7 +35,31 @@ It can be used to start, stop, and configure the client.
 For more information, visit the project page at
 github.com/JohandrevanDeventer/bms-mqtt-client-cli`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		cfg = config.GetConfig()
+		// Select the directories first, every configuration file is read from them
+		if rootHome != "" {
+			config.SetHome(rootHome)
+		}
+
+		if instance := instanceFlagOrEnv(); instance != "" {
+			if err := config.SetInstance(instance); err != nil {
+				fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		// Select the environment first so the matching config overlay is loaded
+		if rootEnvironment != "" {
+			config.SetEnvironment(rootEnvironment)
+		}
+
+		// A command that does not need the application configuration still
+		// runs when app.yaml is broken, so the file can be checked and a
+		// running instance stopped
+		loaded, err := config.GetConfig()
+		if err != nil && cmd.Annotations[appConfigOptional] != "true" {
+			exitOnInvalidConfig("Invalid application configuration", err)
+		}
+		cfg = loaded
 
 		time.Sleep(time.Duration(utils.GetRandomNumber(500, 2000)) * time.Millisecond)
 
@@ -77,8 +104,10 @@ func init() {
 	// Define persistent flags for the root command
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.bms-mqtt-client-cli.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&rootInitConfig, "init", "i", false, "Initialize the configuration file")
-	rootCmd.PersistentFlags().StringVarP(&rootEnvironment, "environment", "e", "", "Environment to run the application in")
+	rootCmd.PersistentFlags().StringVarP(&rootEnvironment, "environment", "e", "", "Environment to run the application in. Selects the config/app.<environment>.yaml overlay if it exists")
 	rootCmd.PersistentFlags().BoolVarP(&rootDebugMode, "debug", "x", false, "Enable debug mode")
+	rootCmd.PersistentFlags().StringVar(&rootHome, "home", "", "Base directory of the configuration, state and logs (defaults to $BMS_HOME, then ./ if it has a config directory, then the XDG directories)")
+	rootCmd.PersistentFlags().StringVarP(&rootInstance, "instance", "I", "", "Name of the instance to use, to run several side by side (defaults to $BMS_INSTANCE)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -97,6 +126,46 @@ func bindRootFlags() {
 
 }
 
+// instanceFlagOrEnv returns the instance selected with --instance or BMS_INSTANCE
+func instanceFlagOrEnv() string {
+	if rootInstance != "" {
+		return rootInstance
+	}
+
+	return os.Getenv(config.InstanceEnv)
+}
+
+// appConfigOptional is the annotation of the commands that run without a
+// readable application configuration, leaving cfg.App nil
+const appConfigOptional = "app_config_optional"
+
+// loadConfig reads the configuration into cfg and exits when the application
+// configuration cannot be read
+func loadConfig() {
+	loaded, err := config.GetConfig()
+	if err != nil {
+		exitOnInvalidConfig("Invalid application configuration", err)
+	}
+
+	cfg = loaded
+}
+
+// exitOnInvalidConfig prints every configuration error and exits the program
+func exitOnInvalidConfig(message string, err error) {
+	fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("%s:", message)))
+
+	var validationErrs config.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		for _, validationErr := range validationErrs {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("-> %s", validationErr)))
+		}
+	} else {
+		fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("-> %s", err)))
+	}
+
+	os.Exit(1)
+}
+
 // initConfig initializes the configuration file
 func initConfig() {
 	fmt.Println(text_style.BoldText("Initializing configuration file(s)..."))
@@ -0,0 +1,109 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/daemon"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serviceOutput  string
+	serviceUser    string
+	serviceGroup   string
+	serviceTimeout time.Duration
+)
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Generate a systemd unit file",
+	Long: `This command generates a systemd unit file that runs the Rubicon BMS
+MQTT Client as a service with the current user, environment, home
+directory and instance, so that it starts at boot and restarts after a
+failure.
+
+Example:
+  service --output /etc/systemd/system/bms-mqtt-client.service
+  systemctl daemon-reload
+  systemctl enable --now bms-mqtt-client`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		unit, err := systemdUnit()
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to generate the unit file: %s", err)))
+			os.Exit(1)
+		}
+
+		if serviceOutput == "" {
+			fmt.Print(unit)
+			return
+		}
+
+		if err := os.WriteFile(serviceOutput, []byte(unit), 0644); err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to write the unit file: %s", err)))
+			os.Exit(1)
+		}
+
+		fmt.Println(text_style.ColorText(text_style.Green, fmt.Sprintf("Unit file written to %s", serviceOutput)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+
+	serviceCmd.Flags().StringVarP(&serviceOutput, "output", "o", "", "File to write the unit to (defaults to standard output)")
+	serviceCmd.Flags().StringVar(&serviceUser, "user", "", "User to run the service as (defaults to the current user)")
+	serviceCmd.Flags().StringVar(&serviceGroup, "group", "", "Group to run the service as")
+	serviceCmd.Flags().DurationVar(&serviceTimeout, "timeout", 30*time.Second, "How long systemd waits for the service to stop")
+}
+
+// systemdUnit builds the unit file from the current executable, directory and flags
+func systemdUnit() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return "", err
+	}
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	serviceUserName := serviceUser
+	if serviceUserName == "" {
+		if current, err := user.Current(); err == nil {
+			serviceUserName = current.Username
+		}
+	}
+
+	// The service runs in the foreground, systemd supervises it
+	execStart := append([]string{executable}, detachedStartArgs()...)
+
+	description := cfg.System.AppName
+	if instance := config.GetDirectories().Instance; instance != "" {
+		description = fmt.Sprintf("%s (%s)", description, instance)
+	}
+
+	return daemon.SystemdUnit(daemon.UnitOptions{
+		Description:      description,
+		ExecStart:        execStart,
+		WorkingDirectory: workingDirectory,
+		User:             serviceUserName,
+		Group:            serviceGroup,
+		StopTimeout:      int(serviceTimeout.Seconds()),
+	})
+}
@@ -5,30 +5,62 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/engine"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/daemon"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
 var logger *zap.Logger
 
+var startDetach bool
+
+// detachReadyTimeout is how long start --detach waits for the background process
+const detachReadyTimeout = 30 * time.Second
+
 // startCmd represents the start command
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the Rubicon BMS MQTT Client",
-	Long:  `This command starts the Rubicon BMS MQTT Client.`,
+	Long: `This command starts the Rubicon BMS MQTT Client.
+
+The client runs in the foreground until it is interrupted or stopped with
+the stop command. Use --detach to run it in the background instead; its
+output is then appended to logs/daemon.out in the home directory.
+
+Only one instance can use a home directory at a time. The running instance
+holds a lock on run/bms-mqtt-client-cli.pid in the home directory, which
+contains its PID. Use --instance to run several instances side by side.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg = config.GetConfig()
+		if _, err := config.LoadAppConfig(); err != nil {
+			exitOnInvalidConfig("Invalid application configuration", err)
+		}
+
+		loadConfig()
+
+		if startDetach && !daemon.IsDetached() {
+			startDetached()
+			return
+		}
 
 		initLogger(cfg)
 
+		pidFile, err := daemon.Acquire(cfg.PidFilePath)
+		if err != nil {
+			logger.Fatal("failed to start the application", zap.Error(err))
+		}
+
 		statePersister, err := initPersist(cfg)
 		if err != nil {
 			logger.Fatal("failed to initialize the state persister", zap.Error(err))
@@ -38,10 +70,13 @@ var startCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 		defer stop()
 
-		svc := engine.NewEngine(cfg, logger, statePersister)
+		svc := engine.NewEngine(cfg, logger, engine.WithPersister(statePersister))
 
 		// Goroutine to handle stop signals or stop file detection
+		stopped := make(chan struct{})
 		go func() {
+			defer close(stopped)
+
 			select {
 			case <-ctx.Done(): // Handle system interrupt (e.g., Ctrl+C)
 				logger.Warn("Received signal to stop the application")
@@ -67,12 +102,29 @@ var startCmd = &cobra.Command{
 		}()
 
 		svc.Run(ctx)
+
+		// Wait for the stopped state before closing the persister
+		<-stopped
+
+		// Write the final state before exiting
+		if err := statePersister.Close(); err != nil {
+			logger.Error("failed to save the state", zap.String("file", cfg.PersistFilePath), zap.Error(err))
+		}
+
+		if err := pidFile.Release(); err != nil {
+			logger.Error("failed to remove the PID file", zap.String("file", cfg.PidFilePath), zap.Error(err))
+		}
+
+		// Flush the buffered log outputs before exiting
+		logging.Sync()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(startCmd)
 
+	startCmd.Flags().BoolVarP(&startDetach, "detach", "d", false, "Run in the background")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -87,30 +139,85 @@ func init() {
 // initLogger configures the logger based on the app config
 func initLogger(cfg *config.Config) {
 	// Create a new logging config with values from the app config
-	loggingConfig := logging.NewLoggingConfig(
-		cfg.App.Logging.Level,
-		cfg.App.Logging.FilePath,
-		cfg.App.Logging.MaxSize,
-		cfg.App.Logging.MaxBackups,
-		cfg.App.Logging.MaxAge,
-		cfg.App.Logging.Compress,
-		cfg.Flags.DebugMode,
-		cfg.App.Logging.AddTime,
-	)
+	loggingConfig := engine.NewLoggingConfig(cfg)
 
 	// Get a new logger based on the config
 	logger = logging.NewLogger(loggingConfig)
 	logger = logging.GetLogger("main")
 }
 
-// initPersist initializes the file persister.
-func initPersist(cfg *config.Config) (*persist.FilePersister, error) {
+// initPersist initializes the state persister with the configured backend.
+func initPersist(cfg *config.Config) (persist.Persister, error) {
 	var err error
 
-	statePersister, err := persist.NewFilePersister(cfg.PersistFilePath)
+	statePersister, err := persist.New(cfg.App.Persist.Backend, cfg.PersistFilePath)
 	if err != nil {
 		return nil, err
 	}
 
-	return statePersister, nil
+	if filePersister, ok := statePersister.(*persist.FilePersister); ok {
+		if quarantined := filePersister.QuarantinedFile(); quarantined != "" {
+			logger.Warn("State file was corrupt and has been quarantined, starting with empty state", zap.String("file", cfg.PersistFilePath), zap.String("quarantined_file", quarantined))
+		}
+	}
+
+	// Record the transitions of the configured keys, such as mqtt.default.status
+	return persist.WithHistory(statePersister, cfg.App.Persist.History.Keys, cfg.App.Persist.History.Limit), nil
+}
+
+// startDetached starts the application in the background with the same
+// arguments and waits until it has taken the PID file
+func startDetached() {
+	if pid, err := daemon.Running(cfg.PidFilePath); err == nil {
+		fmt.Println(text_style.ColorText(text_style.Yellow, fmt.Sprintf("Already running with PID %d", pid)))
+		os.Exit(1)
+	}
+
+	process, err := daemon.Start(detachedStartArgs(), cfg.DaemonOutputPath)
+	if err != nil {
+		fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to start in the background: %s", err)))
+		os.Exit(1)
+	}
+
+	if err := process.WaitReady(cfg.PidFilePath, detachReadyTimeout); err != nil {
+		fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to start in the background: %s", err)))
+		fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("-> See %s for details", cfg.DaemonOutputPath)))
+		os.Exit(1)
+	}
+
+	fmt.Println(text_style.ColorText(text_style.Green, fmt.Sprintf("Started in the background with PID %d", process.PID())))
+}
+
+// detachedStartArgs returns the arguments of the background start command,
+// keeping the global flags and the selected directories of the current command
+func detachedStartArgs() []string {
+	args := []string{"start"}
+
+	// A service does not inherit the environment, so BMS_HOME and
+	// BMS_INSTANCE are passed on as flags
+	home := rootHome
+	if home == "" {
+		home = os.Getenv(config.HomeEnv)
+	}
+
+	if home != "" {
+		if absHome, err := filepath.Abs(home); err == nil {
+			home = absHome
+		}
+		args = append(args, "--home", home)
+	}
+
+	if instance := instanceFlagOrEnv(); instance != "" {
+		args = append(args, "--instance", instance)
+	}
+
+	if rootEnvironment != "" {
+		args = append(args, "--environment", rootEnvironment)
+	}
+
+	if rootDebugMode {
+		args = append(args, "--debug")
+	}
+
+	return args
 }
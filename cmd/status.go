@@ -0,0 +1,124 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/daemon"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
+	"github.com/spf13/cobra"
+)
+
+var statusAll bool
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check whether the Rubicon BMS MQTT Client is running",
+	Long: `This command checks whether the Rubicon BMS MQTT Client is running,
+using the PID file of the running instance.
+
+It exits with status 0 when the client is running and 1 when it is not,
+so it can be used in scripts. Use --all to list every named instance.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if statusAll {
+			printInstancesStatus()
+			return
+		}
+
+		pid, err := daemon.Running(cfg.PidFilePath)
+		if errors.Is(err, daemon.ErrNotRunning) {
+			if pid != 0 {
+				fmt.Println(text_style.ColorText(text_style.Yellow, fmt.Sprintf("Not running (PID %d in %s has exited)", pid, cfg.PidFilePath)))
+			} else {
+				fmt.Println(text_style.ColorText(text_style.Yellow, "Not running"))
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to read the PID file: %s", err)))
+			os.Exit(1)
+		}
+
+		fmt.Println(text_style.ColorText(text_style.Green, fmt.Sprintf("Running with PID %d", pid)))
+
+		// The state is best effort, the bolt backend is locked while running
+		snapshot, err := persist.Snapshot(cfg.App.Persist.Backend, cfg.PersistFilePath)
+		if err != nil {
+			return
+		}
+
+		if _, err := state.Migrate(snapshot); err != nil {
+			return
+		}
+
+		current, _ := state.Load(snapshot)
+		if current.App.StartTime != nil {
+			printHealthField("Uptime", time.Since(*current.App.StartTime).Round(time.Second).String())
+		}
+
+		for _, name := range connectionNames(current.Connections) {
+			connection := current.Connections[name]
+
+			status := colorStatus(connection.Status)
+			if connection.Broker != "" {
+				status = fmt.Sprintf("%s (%s)", status, connection.Broker)
+			}
+
+			label := "MQTT"
+			if len(current.Connections) > 1 {
+				label = "MQTT " + name
+			}
+			printHealthField(label, status)
+		}
+
+		if len(current.Devices) > 0 {
+			online, silent := countDevices(current.Devices)
+			devices := fmt.Sprintf("%d online", online)
+			if silent > 0 {
+				devices += ", " + text_style.ColorText(text_style.Red, fmt.Sprintf("%d silent", silent))
+			}
+			printHealthField("Devices", devices)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVarP(&statusAll, "all", "a", false, "Show the status of the default and every named instance")
+}
+
+// printInstancesStatus prints whether the default and each named instance is running
+func printInstancesStatus() {
+	instances, err := config.Instances()
+	if err != nil {
+		fmt.Println(text_style.ColorText(text_style.Red, fmt.Sprintf("Failed to list the instances: %s", err)))
+		os.Exit(1)
+	}
+
+	for _, instance := range append([]string{""}, instances...) {
+		dirs := config.DirectoriesFor(instance)
+
+		name := instance
+		if name == "" {
+			name = "(default)"
+		}
+
+		status := text_style.ColorText(text_style.Yellow, "not running")
+		if pid, err := daemon.Running(dirs.PidFilePath()); err == nil {
+			status = text_style.ColorText(text_style.Green, fmt.Sprintf("running with PID %d", pid))
+		}
+
+		fmt.Printf("  %-20s %s  %s\n", name, status, dirs.Home)
+	}
+}
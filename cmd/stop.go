@@ -4,37 +4,77 @@ Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/daemon"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/text_style"
 	"github.com/spf13/cobra"
 )
 
+var stopTimeout time.Duration
+
 // stopCmd represents the stop command
 var stopCmd = &cobra.Command{
-	Use:   "stop",
-	Short: "Stop the Rubicon BMS MQTT Client",
-	Long:  `This command stops the Rubicon BMS MQTT Client.`,
+	Use:         "stop",
+	Annotations: map[string]string{appConfigOptional: "true"},
+	Short:       "Stop the Rubicon BMS MQTT Client",
+	Long: `This command stops the Rubicon BMS MQTT Client.
+
+The running instance is found through its PID file and asked to shut down
+gracefully. The command waits until it has exited, or fails after --timeout.
+Where signals are not supported, a stop file is created instead.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		stopFilePath := "./tmp/stop_signal"
-		if _, err := os.Create(stopFilePath); err != nil {
-			fmt.Println("Failed to create stop file:", err)
-			return
+		if err := stopRunning(stopTimeout); err != nil {
+			fmt.Println(text_style.ColorText(text_style.Red, err.Error()))
+			os.Exit(1)
 		}
-		fmt.Println("Stop file created at", stopFilePath)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
 
-	// Here you will define your flags and configuration settings.
+	stopCmd.Flags().DurationVarP(&stopTimeout, "timeout", "t", 30*time.Second, "How long to wait for the application to stop")
+}
+
+// stopRunning stops the running instance and waits until it has exited
+func stopRunning(timeout time.Duration) error {
+	pid, err := daemon.Running(cfg.PidFilePath)
+	if errors.Is(err, daemon.ErrNotRunning) {
+		fmt.Println(text_style.ColorText(text_style.Yellow, "Not running"))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the PID file: %w", err)
+	}
+
+	// Without signals, as on Windows, ask through the stop file instead
+	if err := daemon.Terminate(pid); err != nil {
+		if err := createStopFile(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Stopping PID %d...\n", pid)
+
+	if !daemon.WaitStopped(pid, timeout) {
+		return fmt.Errorf("PID %d did not stop within %s", pid, timeout)
+	}
+
+	fmt.Println(text_style.ColorText(text_style.Green, "Stopped"))
+	return nil
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// stopCmd.PersistentFlags().String("foo", "", "A help for foo")
+// createStopFile asks the running instance to stop through the stop file it watches
+func createStopFile() error {
+	if _, err := os.Create(cfg.StopFilePath); err != nil {
+		return fmt.Errorf("failed to create stop file: %w", err)
+	}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// stopCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	fmt.Println("Stop file created at", cfg.StopFilePath)
+	return nil
 }
@@ -9,9 +9,10 @@ import (
 
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
-	Use:   "version",
-	Short: "Print the version number of bms-mqtt-client-cli",
-	Long:  `All software has versions. This is bms-mqtt-client-cli's`,
+	Use:         "version",
+	Annotations: map[string]string{appConfigOptional: "true"},
+	Short:       "Print the version number of bms-mqtt-client-cli",
+	Long:        `All software has versions. This is bms-mqtt-client-cli's`,
 	Run: func(cmd *cobra.Command, args []string) {
 	},
 }
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/aggregate"
+)
+
+// Engine returns the configuration of the aggregator
+func (c AggregateConfig) Engine() aggregate.Config {
+	config := aggregate.Config{
+		Watermark: time.Duration(c.Watermark) * time.Second,
+	}
+
+	for _, window := range c.Windows {
+		config.Windows = append(config.Windows, time.Duration(window)*time.Second)
+	}
+
+	for _, point := range c.Points {
+		config.Points = append(config.Points, aggregate.Point{
+			Name:      point.Name,
+			Topic:     point.Topic,
+			Field:     point.Field,
+			TimeField: point.TimeField,
+		})
+	}
+
+	return config
+}
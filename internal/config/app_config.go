@@ -3,18 +3,34 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/batch"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/utils"
+	"gopkg.in/yaml.v3"
 )
 
-var appConfigFilePath = fmt.Sprintf("%s/%s", configRoot, appConfigFile)
+// appConfigFilePath returns the application configuration file
+func appConfigFilePath() string {
+	return joinPath(ConfigDir(), appConfigFile)
+}
 
 var appConfig *AppConfig
 
 var defaultAppConfig = AppConfig{
-	Logging: defaultLoggingConfig,
-	Mqtt:    defaultMQTTConfig,
+	Logging:     defaultLoggingConfig,
+	Mqtt:        defaultMQTTConfig,
+	Connections: []ConnectionConfig{},
+	Persist:     defaultPersistConfig,
+	Rules:       defaultRulesConfig,
+	Watchdog:    defaultWatchdogConfig,
+	Filter:      defaultFilterConfig,
+	Transform:   defaultTransformConfig,
+	Aggregate:   defaultAggregateConfig,
+	Bridge:      defaultBridgeConfig,
 }
 
 var defaultLoggingConfig = LoggingConfig{
@@ -25,6 +41,17 @@ var defaultLoggingConfig = LoggingConfig{
 	MaxAge:     28,
 	Compress:   true,
 	AddTime:    true,
+	ForceLevel: false,
+	Loggers:    map[string]string{},
+	Sampling: SamplingConfig{
+		Initial:    0,
+		Thereafter: 0,
+		Tick:       1,
+	},
+	Outputs: []LogOutputConfig{
+		{Type: "stdout", Format: "console"},
+		{Type: "file", Format: "json"},
+	},
 }
 
 var defaultMQTTConfig = MqttConfig{
@@ -38,20 +65,100 @@ var defaultMQTTConfig = MqttConfig{
 	ReconnectOnFailure: true,
 	Username:           "",
 	Password:           "",
+	TLS:                MqttTLSConfig{},
+}
+
+var defaultPersistConfig = PersistConfig{
+	Backend:  "file",
+	FilePath: "",
+	History: PersistHistoryConfig{
+		Keys:  []string{"app.status", "mqtt.default.status"},
+		Limit: 50,
+	},
+}
+
+var defaultRulesConfig = RulesConfig{
+	AlarmTopic: "bms/alarms",
+	AlarmQos:   1,
+	Retain:     true,
+	Points:     []RulePointConfig{},
+	Rules:      []RuleConfig{},
+}
+
+var defaultWatchdogConfig = WatchdogConfig{
+	Pattern:    "",
+	Interval:   defaultWatchdogInterval,
+	EventTopic: "bms/events/devices",
+	Devices:    []WatchdogDeviceConfig{},
+}
+
+var defaultFilterConfig = FilterConfig{
+	DedupWindow: 0,
+	Deadbands:   []FilterDeadbandConfig{},
+	RateLimits:  []FilterRateLimitConfig{},
+}
+
+var defaultTransformConfig = TransformConfig{
+	DropUnmatched: false,
+	Metadata:      map[string]interface{}{},
+	Transforms:    []TransformRuleConfig{},
+}
+
+var defaultAggregateConfig = AggregateConfig{
+	SummaryTopic: "bms/summary",
+	Qos:          1,
+	Retain:       false,
+	Sink:         AggregateSinkMQTT,
+	Windows:      []int{60, 300, 900},
+	Watermark:    10,
+	Points:       []AggregatePointConfig{},
+}
+
+var defaultBridgeConfig = BridgeConfig{
+	Enabled: false,
+	Destination: BridgeDestinationConfig{
+		Broker:       "",
+		Port:         8883,
+		ClientId:     "bms-mqtt-client-cli-bridge",
+		CleanSession: true,
+		KeepAlive:    60,
+		TLS:          MqttTLSConfig{Enabled: true},
+	},
+	Routes:     []BridgeRouteConfig{},
+	LoopWindow: 30,
+	Batch: BridgeBatchConfig{
+		Enabled:     false,
+		Topic:       "",
+		Qos:         1,
+		Format:      batch.FormatNDJSON,
+		Compression: batch.CompressionGzip,
+		MaxMessages: 500,
+		MaxBytes:    256 * 1024,
+		Interval:    10,
+	},
 }
 
 // InitAppConfig initializes the application configuration
 func InitAppConfig() (fileExists bool, err error) {
 	// Check if the configuration file exists
-	if utils.FileExists(appConfigFilePath) {
+	if utils.FileExists(appConfigFilePath()) {
 		return true, nil
 	}
 
 	// Create the configuration directory
-	os.Mkdir(configRoot, 0o770)
+	os.MkdirAll(ConfigDir(), 0o770)
 
 	// This is just to set the default values
-	GetAppConfig()
+	if _, err := GetAppConfig(); err != nil {
+		return false, err
+	}
+
+	// Instances connected to the same broker need their own client ID
+	if instance := GetDirectories().Instance; instance != "" {
+		instanceConfig := *appConfig
+		instanceConfig.Mqtt.ClientId = fmt.Sprintf("%s-%s", defaultMQTTConfig.ClientId, instance)
+		appConfig = &instanceConfig
+	}
 
 	// Save the application configuration
 	err = SaveAppConfig(true)
@@ -62,18 +169,40 @@ func InitAppConfig() (fileExists bool, err error) {
 	return false, nil
 }
 
-// GetAppConfig returns the application configuration
-func GetAppConfig() *AppConfig {
-	err := loadConfig(appConfigFilePath, &appConfig)
+// GetAppConfig returns the application configuration with the overlay of the
+// active environment merged over it, and the defaults for the values neither
+// file sets. Without app.yaml the defaults are returned; a file that cannot be
+// read or decoded is an error.
+func GetAppConfig() (*AppConfig, error) {
+	if !utils.FileExists(appConfigFilePath()) {
+		appConfig = DefaultAppConfig()
+		return appConfig, nil
+	}
+
+	sources, err := readAppConfigSources()
 	if err != nil {
-		appConfig = &defaultAppConfig
+		return nil, err
 	}
-	return appConfig
+
+	newCfg, err := mergeAppConfigSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig = newCfg
+
+	return appConfig, nil
 }
 
-// SaveAppConfig saves the application configuration
+// SaveAppConfig saves the application configuration. When the active environment
+// has an overlay file, the changes are saved to the overlay and app.yaml is left as is.
 func SaveAppConfig(createFile bool) error {
-	err := saveConfig(appConfigFilePath, appConfig, createFile)
+	overlays := appConfigOverlayFiles()
+	if len(overlays) > 0 {
+		return saveAppConfigOverlay(overlays[0])
+	}
+
+	err := saveConfig(appConfigFilePath(), appConfig, createFile)
 	if err != nil {
 		return err
 	}
@@ -81,7 +210,74 @@ func SaveAppConfig(createFile bool) error {
 	return nil
 }
 
-// WatchAppConfigFileWithPolling watches the application configuration file for changes using polling
+// AppConfigFiles returns the application configuration files in effect, in the
+// order they are merged
+func AppConfigFiles() []string {
+	return append([]string{appConfigFilePath()}, appConfigOverlayFiles()...)
+}
+
+// AppConfigOverlayFilePath returns the overlay file of an environment, for
+// example ./config/app.production.yaml
+func AppConfigOverlayFilePath(environment string) string {
+	ext := filepath.Ext(appConfigFile)
+	name := strings.TrimSuffix(appConfigFile, ext)
+
+	return joinPath(ConfigDir(), fmt.Sprintf("%s.%s%s", name, strings.ToLower(environment), ext))
+}
+
+// appConfigOverlayFiles returns the overlay file of the active environment if it exists
+func appConfigOverlayFiles() []string {
+	environment := GetEnvironment()
+	if environment == "" {
+		return nil
+	}
+
+	overlayPath := AppConfigOverlayFilePath(environment)
+	if !utils.FileExists(overlayPath) {
+		return nil
+	}
+
+	return []string{overlayPath}
+}
+
+// saveAppConfigOverlay writes every value that differs from app.yaml, together
+// with the values already in the overlay, to the overlay file
+func saveAppConfigOverlay(overlayPath string) error {
+	sources, err := readAppConfigSources()
+	if err != nil {
+		return err
+	}
+
+	// The base is decoded like the configuration, so the defaults it fills in
+	// are not taken for changes
+	baseCfg, err := mergeAppConfigSources(sources[:1])
+	if err != nil {
+		return err
+	}
+
+	overlay := map[string]interface{}{}
+	if data, err := os.ReadFile(overlayPath); err == nil {
+		if err := yaml.Unmarshal(data, &overlay); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", overlayPath, err)
+		}
+	}
+
+	baseValues := map[string]interface{}{}
+	for _, field := range AppConfigFields(baseCfg) {
+		baseValues[field.Path] = field.Value
+	}
+
+	for _, field := range AppConfigFields(appConfig) {
+		_, inOverlay := lookupNestedValue(overlay, field.Path)
+		if inOverlay || !reflect.DeepEqual(baseValues[field.Path], field.Value) {
+			setNestedValue(overlay, field.Path, field.Value)
+		}
+	}
+
+	return saveConfig(overlayPath, overlay, false)
+}
+
+// WatchAppConfigFileWithPolling watches the application configuration files for changes using polling
 func WatchAppConfigFileWithPolling(callback func(), interval, debounceDuration time.Duration) {
-	watchConfigFileWithPolling(appConfigFilePath, callback, interval, debounceDuration)
+	watchConfigFileWithPolling(AppConfigFiles, callback, interval, debounceDuration)
 }
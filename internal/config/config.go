@@ -32,9 +32,9 @@ func InitConfig() (newFiles []string, existingFiles []string, err error) {
 	systemCfgExists := false
 	systemCfgExists, err = InitSystemConfig()
 	if systemCfgExists {
-		existingFiles = append(existingFiles, systemConfigFilePath)
+		existingFiles = append(existingFiles, systemConfigFilePath())
 	} else {
-		newFiles = append(newFiles, systemConfigFilePath)
+		newFiles = append(newFiles, systemConfigFilePath())
 	}
 
 	if err != nil {
@@ -45,9 +45,9 @@ func InitConfig() (newFiles []string, existingFiles []string, err error) {
 	appCfgExists := false
 	appCfgExists, err = InitAppConfig()
 	if appCfgExists {
-		existingFiles = append(existingFiles, appConfigFilePath)
+		existingFiles = append(existingFiles, appConfigFilePath())
 	} else {
-		newFiles = append(newFiles, appConfigFilePath)
+		newFiles = append(newFiles, appConfigFilePath())
 	}
 
 	if err != nil {
@@ -57,14 +57,43 @@ func InitConfig() (newFiles []string, existingFiles []string, err error) {
 	return newFiles, existingFiles, nil
 }
 
-// GetConfig returns the application configuration
-func GetConfig() *Config {
-	return &Config{
-		PersistFilePath: persistFilePath,
-		Flags:           GetFlagsConfig(),
-		System:          GetSystemConfig(),
-		App:             GetAppConfig(),
+// GetConfig returns the configuration. When the application configuration
+// cannot be read, the error is returned with the other settings and a nil App.
+func GetConfig() (*Config, error) {
+	dirs := GetDirectories()
+
+	cfg := &Config{
+		ConnectionsFilePath: ResolvePath(connectionsFilePath),
+		PidFilePath:         dirs.PidFilePath(),
+		DaemonOutputPath:    ResolvePath(daemonOutputFilePath),
+		TmpDirPath:          dirs.Tmp,
+		StopFilePath:        joinPath(dirs.Tmp, stopFile),
+		Flags:               GetFlagsConfig(),
+		System:              GetSystemConfig(),
 	}
+
+	appCfg, err := GetAppConfig()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.PersistFilePath = persistFilePathFor(appCfg.Persist)
+	cfg.App = appCfg
+
+	return cfg, nil
+}
+
+// persistFilePathFor returns the state file of the configured persister backend
+func persistFilePathFor(cfg PersistConfig) string {
+	if cfg.FilePath != "" {
+		return ResolvePath(cfg.FilePath)
+	}
+
+	if cfg.Backend == "bolt" {
+		return ResolvePath(persistBoltFilePath)
+	}
+
+	return ResolvePath(persistFilePath)
 }
 
 // SaveConfig saves the configuration
@@ -77,8 +106,8 @@ func SaveConfig() error {
 	return nil
 }
 
-// loadConfig loads the configuration from a file
-func loadConfig(path string, target interface{}) error {
+// loadConfig loads the configuration from a file and merges any overlay files over it
+func loadConfig(path string, target interface{}, overlays ...string) error {
 	// Create a new viper instance
 	v := viper.New()
 
@@ -96,6 +125,14 @@ func loadConfig(path string, target interface{}) error {
 		return fmt.Errorf("error reading config file: %w", err)
 	}
 
+	// Merge the overlays over the config file in order
+	for _, overlay := range overlays {
+		v.SetConfigFile(overlay)
+		if err := v.MergeInConfig(); err != nil {
+			return fmt.Errorf("error merging config file %s: %w", overlay, err)
+		}
+	}
+
 	// Unmarshal the configuration file into the struct
 	if err := v.Unmarshal(&target); err != nil {
 		return fmt.Errorf("error unmarshalling config file: %w", err)
@@ -131,8 +168,8 @@ func saveConfig(path string, config interface{}, createFile bool) error {
 
 // PrintInfo prints the application information
 func PrintInfo(versionOnly bool) {
-	flagsCfg := GetConfig().Flags
-	systemCfg := GetConfig().System
+	flagsCfg := GetFlagsConfig()
+	systemCfg := GetSystemConfig()
 
 	goVersion := strings.Replace(runtime.Version(), "go", "", 1)
 
@@ -162,6 +199,10 @@ func PrintInfo(versionOnly bool) {
 			fmt.Println(text_style.ColorText(text_style.Blue, (text_style.BoldText("Running in Default mode"))))
 		}
 
+		for _, overlay := range appConfigOverlayFiles() {
+			fmt.Printf("Using configuration overlay %s\n", text_style.ColorText(text_style.Cyan, text_style.BoldText(overlay)))
+		}
+
 		fmt.Println("")
 
 		if flagsCfg.DebugMode {
@@ -202,7 +243,7 @@ func WatchConfig(path string, callback func(), debounce int) error {
 	return nil
 }
 
-func watchConfigFileWithPolling(path string, callback func(), interval, debounceDuration time.Duration) {
+func watchConfigFileWithPolling(paths func() []string, callback func(), interval, debounceDuration time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -211,18 +252,23 @@ func watchConfigFileWithPolling(path string, callback func(), interval, debounce
 			mu.Lock()
 			defer mu.Unlock()
 
-			// Open and hash the file
-			file, err := os.Open(path)
-			if err != nil {
-				// Log or handle file open error as needed
-				return
-			}
-			defer file.Close()
-
+			// Open and hash the files, including the names so adding or
+			// removing an overlay file is detected as a change
 			hash := fnv.New64a()
-			if _, err := io.Copy(hash, file); err != nil {
-				// Log or handle file read error as needed
-				return
+			for _, path := range paths() {
+				file, err := os.Open(path)
+				if err != nil {
+					// Log or handle file open error as needed
+					return
+				}
+
+				io.WriteString(hash, path)
+				_, err = io.Copy(hash, file)
+				file.Close()
+				if err != nil {
+					// Log or handle file read error as needed
+					return
+				}
 			}
 
 			currentHash := hash.Sum64()
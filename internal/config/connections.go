@@ -0,0 +1,8 @@
+package config
+
+// MqttConnections returns every broker connection: the mqtt section named
+// DefaultConnection, followed by the named connections
+func (c *AppConfig) MqttConnections() []ConnectionConfig {
+	connections := []ConnectionConfig{{Name: DefaultConnection, MqttConfig: c.Mqtt}}
+	return append(connections, c.Connections...)
+}
@@ -1,9 +1,26 @@
 package config
 
-const configRoot = "./config"
-
 const flagsConfigFile = "flags.yaml"
 const systemConfigFile = "system.yaml"
 const appConfigFile = "app.yaml"
+const secretKeyFile = "secret.key"
 
 const persistFilePath = "./persist/persist.json"
+const persistBoltFilePath = "./persist/persist.db"
+const connectionsFilePath = "./connections/connections.log"
+const pidFile = "bms-mqtt-client-cli.pid"
+const stopFile = "stop_signal"
+const daemonOutputFilePath = "./logs/daemon.out"
+
+// defaultWatchdogInterval is the interval in seconds a device may be silent
+// when watchdog.interval is not set
+const defaultWatchdogInterval = 300
+
+// DefaultConnection is the name of the connection configured in the mqtt section
+const DefaultConnection = "default"
+
+// Sinks the summaries of the aggregator are sent to
+const (
+	AggregateSinkMQTT   = "mqtt"
+	AggregateSinkBridge = "bridge"
+)
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/filter"
+)
+
+// Engine returns the configuration of the filter stage
+func (c FilterConfig) Engine() filter.Config {
+	config := filter.Config{
+		DedupWindow: time.Duration(c.DedupWindow) * time.Second,
+	}
+
+	for _, deadband := range c.Deadbands {
+		config.Deadbands = append(config.Deadbands, filter.Deadband{
+			Match:    deadband.Match,
+			Field:    deadband.Field,
+			Absolute: deadband.Absolute,
+			Percent:  deadband.Percent,
+		})
+	}
+
+	for _, limit := range c.RateLimits {
+		config.RateLimits = append(config.RateLimits, filter.RateLimit{
+			Match:    limit.Match,
+			Messages: limit.Messages,
+			Interval: time.Duration(limit.Interval) * time.Second,
+		})
+	}
+
+	return config
+}
@@ -1,16 +1,22 @@
 package config
 
 import (
-	"fmt"
 	"os"
+	"strings"
 
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/utils"
 )
 
-var flagsConfigFilePath = fmt.Sprintf("%s/%s", configRoot, flagsConfigFile)
+// flagsConfigFilePath returns the flags configuration file
+func flagsConfigFilePath() string {
+	return joinPath(ConfigDir(), flagsConfigFile)
+}
 
 var flagsConfig *FlagsConfig
 
+// environmentOverride is the environment selected on the command line
+var environmentOverride string
+
 var defaultFlagsConfig = FlagsConfig{
 	Environment: "production",
 	DebugMode:   false,
@@ -19,12 +25,12 @@ var defaultFlagsConfig = FlagsConfig{
 // InitFlagsConfig initializes the flags configuration
 func InitFlagsConfig() (fileExists bool) {
 	// Check if the config directory exists. If not, create it
-	if utils.FileExists(flagsConfigFilePath) {
+	if utils.FileExists(flagsConfigFilePath()) {
 		return true
 	}
 
 	// Create the config directory
-	os.Mkdir(configRoot, 0o770)
+	os.MkdirAll(ConfigDir(), 0o770)
 
 	// This is just to set the default values
 	GetFlagsConfig()
@@ -36,16 +42,36 @@ func InitFlagsConfig() (fileExists bool) {
 
 // GetFlagsConfig returns the flags configuration
 func GetFlagsConfig() *FlagsConfig {
-	err := loadConfig(flagsConfigFilePath, &flagsConfig)
+	err := loadConfig(flagsConfigFilePath(), &flagsConfig)
 	if err != nil {
 		flagsConfig = &defaultFlagsConfig
 	}
+
+	if environmentOverride != "" {
+		flagsConfig.Environment = environmentOverride
+	}
+
 	return flagsConfig
 }
 
+// SetEnvironment selects the environment, and with it the configuration overlay,
+// overriding the environment in the flags configuration
+func SetEnvironment(environment string) {
+	environmentOverride = strings.ToLower(environment)
+}
+
+// GetEnvironment returns the active environment
+func GetEnvironment() string {
+	if environmentOverride != "" {
+		return environmentOverride
+	}
+
+	return strings.ToLower(GetFlagsConfig().Environment)
+}
+
 // SaveFlagsConfig saves the flags configuration
 func SaveFlagsConfig(createFile bool) error {
-	err := saveConfig(flagsConfigFilePath, flagsConfig, createFile)
+	err := saveConfig(flagsConfigFilePath(), flagsConfig, createFile)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// HomeEnv and InstanceEnv select the base directory and the named instance
+// when the --home and --instance flags are not given
+const (
+	HomeEnv     = "BMS_HOME"
+	InstanceEnv = "BMS_INSTANCE"
+)
+
+// appDirName is the name of the application directory in the XDG base directories
+const appDirName = "bms-mqtt-client-cli"
+
+// instancesDir holds one directory per named instance
+const instancesDir = "instances"
+
+// instanceName matches valid instance names, which are used as directory names
+var instanceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Directories are where an instance keeps its files
+type Directories struct {
+	// Home holds the state, the connection history and the logs. Relative
+	// paths in the configuration are relative to it.
+	Home string
+	// Config holds the configuration files
+	Config string
+	// Run holds the PID file of the running instance
+	Run string
+	// Tmp holds temporary files and is deleted when the application stops
+	Tmp string
+	// Instance is the name of the instance, empty for the default instance
+	Instance string
+}
+
+var (
+	homeMu           sync.Mutex
+	homeOverride     string
+	instanceOverride string
+	directories      *Directories
+)
+
+// SetHome selects the base directory, overriding BMS_HOME
+func SetHome(home string) {
+	homeMu.Lock()
+	defer homeMu.Unlock()
+
+	homeOverride = home
+	directories = nil
+}
+
+// SetInstance selects a named instance, overriding BMS_INSTANCE
+func SetInstance(instance string) error {
+	if instance != "" && !instanceName.MatchString(instance) {
+		return fmt.Errorf("invalid instance name %q: use letters, digits, '.', '_' and '-'", instance)
+	}
+
+	homeMu.Lock()
+	defer homeMu.Unlock()
+
+	instanceOverride = instance
+	directories = nil
+	return nil
+}
+
+// GetDirectories returns the directories of the selected instance.
+//
+// With --home or BMS_HOME every file lives below that directory, in the same
+// layout as the working directory used to have. Without it the working
+// directory is used when it has a ./config directory, so existing
+// installations keep working. Otherwise the XDG base directories are used:
+// the configuration in $XDG_CONFIG_HOME/bms-mqtt-client-cli and everything
+// else in $XDG_STATE_HOME/bms-mqtt-client-cli. A named instance uses an
+// instances/<name> directory below each of these.
+func GetDirectories() Directories {
+	homeMu.Lock()
+	defer homeMu.Unlock()
+
+	if directories == nil {
+		resolved := DirectoriesFor(instance())
+		directories = &resolved
+	}
+
+	return *directories
+}
+
+// DirectoriesFor returns the directories of a named instance, or of the
+// default instance when the name is empty
+func DirectoriesFor(instance string) Directories {
+	if home := home(); home != "" {
+		base := home
+		if instance != "" {
+			base = joinPath(home, instancesDir, instance)
+		}
+
+		return homeDirectories(base, joinPath(base, "config"), instance)
+	}
+
+	configBase, stateBase := xdgDirectories()
+	if instance != "" {
+		configBase = filepath.Join(configBase, instancesDir, instance)
+		stateBase = filepath.Join(stateBase, instancesDir, instance)
+	}
+
+	return homeDirectories(stateBase, configBase, instance)
+}
+
+// Instances returns the names of the named instances that have a directory
+func Instances() ([]string, error) {
+	root := joinPath(home(), instancesDir)
+	if home() == "" {
+		configBase, _ := xdgDirectories()
+		root = filepath.Join(configBase, instancesDir)
+	}
+
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && instanceName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// PidFilePath returns the PID file of the instance
+func (d Directories) PidFilePath() string {
+	return joinPath(d.Run, pidFile)
+}
+
+// ResolvePath returns a path from the configuration relative to the home directory
+func ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return joinPath(GetDirectories().Home, path)
+}
+
+// ConfigDir returns the directory of the configuration files
+func ConfigDir() string {
+	return GetDirectories().Config
+}
+
+func homeDirectories(home, config, instance string) Directories {
+	return Directories{
+		Home:     home,
+		Config:   config,
+		Run:      joinPath(home, "run"),
+		Tmp:      joinPath(home, "tmp"),
+		Instance: instance,
+	}
+}
+
+// home returns the selected base directory. The working directory is the
+// base directory when it has a config directory.
+func home() string {
+	if homeOverride != "" {
+		return homeOverride
+	}
+
+	if home := os.Getenv(HomeEnv); home != "" {
+		return home
+	}
+
+	if info, err := os.Stat("./config"); err == nil && info.IsDir() {
+		return "."
+	}
+
+	return ""
+}
+
+func instance() string {
+	if instanceOverride != "" {
+		return instanceOverride
+	}
+
+	return os.Getenv(InstanceEnv)
+}
+
+// xdgDirectories returns the configuration and state directories of the
+// application below the XDG base directories
+func xdgDirectories() (string, string) {
+	userHome, _ := os.UserHomeDir()
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(userHome, ".config")
+	}
+
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = filepath.Join(userHome, ".local", "state")
+	}
+
+	return filepath.Join(configHome, appDirName), filepath.Join(stateHome, appDirName)
+}
+
+// joinPath joins path elements, keeping the ./ prefix of the working directory
+// so paths read like ./config/app.yaml
+func joinPath(base string, elem ...string) string {
+	path := filepath.Join(append([]string{base}, elem...)...)
+	if base == "." || (len(base) > 1 && base[:2] == "./") {
+		return "./" + path
+	}
+
+	return path
+}
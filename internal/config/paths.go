@@ -0,0 +1,387 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secret values in any output shown to the user
+const redactedValue = "********"
+
+// ConfigField is a single leaf value of the configuration addressed by a dotted path
+type ConfigField struct {
+	Path   string
+	Value  interface{}
+	Secret bool
+}
+
+// ConfigChange describes a field that differs between two configurations
+type ConfigChange struct {
+	Path     string
+	OldValue interface{}
+	NewValue interface{}
+	Secret   bool
+}
+
+// GetAppConfigValue returns the value at a dotted path like "mqtt.port". Entries
+// of map fields are addressed by their key, like "logging.loggers.mqtt", and
+// entries of lists by their index, like "connections.0.password".
+func GetAppConfigValue(cfg *AppConfig, path string) (interface{}, error) {
+	if mapField, key, ok := lookupConfigMapEntry(reflect.ValueOf(cfg).Elem(), path); ok {
+		value := mapField.MapIndex(reflect.ValueOf(key))
+		if !value.IsValid() {
+			return reflect.Zero(mapField.Type().Elem()).Interface(), nil
+		}
+		return value.Interface(), nil
+	}
+
+	field, _, err := lookupConfigField(reflect.ValueOf(cfg).Elem(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return field.Interface(), nil
+}
+
+// SetAppConfigValue sets the value at a dotted path like "mqtt.port". The value
+// is parsed as YAML into the type of the field, so "1883", "true" and "[a, b]"
+// are all accepted where the field type allows it.
+func SetAppConfigValue(cfg *AppConfig, path string, value string) error {
+	if mapField, key, ok := lookupConfigMapEntry(reflect.ValueOf(cfg).Elem(), path); ok {
+		return setConfigMapEntry(mapField, key, path, value)
+	}
+
+	field, _, err := lookupConfigField(reflect.ValueOf(cfg).Elem(), path)
+	if err != nil {
+		return err
+	}
+
+	if !field.CanSet() {
+		return fmt.Errorf("config path %q cannot be set", path)
+	}
+
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+
+	newValue := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), newValue.Interface()); err != nil {
+		return fmt.Errorf("invalid value %q for %s: expected %s", value, path, field.Type())
+	}
+
+	field.Set(newValue.Elem())
+
+	return nil
+}
+
+// setConfigMapEntry sets a map entry, removing it when the value is empty
+func setConfigMapEntry(mapField reflect.Value, key, path, value string) error {
+	if mapField.IsNil() {
+		mapField.Set(reflect.MakeMap(mapField.Type()))
+	}
+
+	if value == "" {
+		mapField.SetMapIndex(reflect.ValueOf(key), reflect.Value{})
+		return nil
+	}
+
+	newValue := reflect.New(mapField.Type().Elem())
+	if newValue.Elem().Kind() == reflect.String {
+		newValue.Elem().SetString(value)
+	} else if err := yaml.Unmarshal([]byte(value), newValue.Interface()); err != nil {
+		return fmt.Errorf("invalid value %q for %s: expected %s", value, path, mapField.Type().Elem())
+	}
+
+	mapField.SetMapIndex(reflect.ValueOf(key), newValue.Elem())
+
+	return nil
+}
+
+// lookupConfigMapEntry returns the map field and key when the path addresses an
+// entry of a map with string keys
+func lookupConfigMapEntry(v reflect.Value, path string) (reflect.Value, string, bool) {
+	index := strings.LastIndex(path, ".")
+	if index < 0 {
+		return reflect.Value{}, "", false
+	}
+
+	parent, _, err := lookupConfigField(v, path[:index])
+	if err != nil || parent.Kind() != reflect.Map || parent.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, "", false
+	}
+
+	return parent, path[index+1:], true
+}
+
+// IsSecretPath reports whether the value at a dotted path of a configuration
+// is a secret
+func IsSecretPath(cfg *AppConfig, path string) bool {
+	_, secret, err := lookupConfigField(reflect.ValueOf(cfg).Elem(), path)
+	return err == nil && secret
+}
+
+// AppConfigFields returns every leaf of the application configuration, sorted by path
+func AppConfigFields(cfg *AppConfig) []ConfigField {
+	var fields []ConfigField
+	collectConfigFields(reflect.ValueOf(cfg).Elem(), "", false, &fields)
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Path < fields[j].Path
+	})
+
+	return fields
+}
+
+// DefaultAppConfig returns a deep copy of the default application
+// configuration, so decoding over it leaves the defaults untouched
+func DefaultAppConfig() *AppConfig {
+	data, err := yaml.Marshal(&defaultAppConfig)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode the default application configuration: %v", err))
+	}
+
+	defaults := &AppConfig{}
+	if err := yaml.Unmarshal(data, defaults); err != nil {
+		panic(fmt.Sprintf("failed to decode the default application configuration: %v", err))
+	}
+
+	return defaults
+}
+
+// DiffAppConfig returns every field that differs between two configurations
+func DiffAppConfig(oldCfg, newCfg *AppConfig) []ConfigChange {
+	oldFields := AppConfigFields(oldCfg)
+	newFields := AppConfigFields(newCfg)
+
+	newValues := make(map[string]ConfigField, len(newFields))
+	for _, field := range newFields {
+		newValues[field.Path] = field
+	}
+
+	var changes []ConfigChange
+	for _, oldField := range oldFields {
+		newField, ok := newValues[oldField.Path]
+		if ok && reflect.DeepEqual(oldField.Value, newField.Value) {
+			continue
+		}
+
+		changes = append(changes, ConfigChange{
+			Path:     oldField.Path,
+			OldValue: oldField.Value,
+			NewValue: newField.Value,
+			Secret:   oldField.Secret,
+		})
+	}
+
+	return changes
+}
+
+// RedactAppConfig returns a copy of the configuration with all secrets redacted
+func RedactAppConfig(cfg *AppConfig) *AppConfig {
+	redacted := *cfg
+	redactSecrets(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+// ExportAppConfig encodes the configuration as yaml, json or toml
+func ExportAppConfig(cfg *AppConfig, format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yaml.Marshal(cfg)
+	case "json", "toml":
+		// Round trip through YAML so the keys match the YAML configuration file
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(format, "json") {
+			return json.MarshalIndent(values, "", "  ")
+		}
+
+		return toml.Marshal(values)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q, supported formats: yaml, json, toml", format)
+	}
+}
+
+// lookupConfigField walks a struct by yaml tag names and returns the field at
+// path. List entries are addressed by their index, like connections.0.password.
+func lookupConfigField(v reflect.Value, path string) (reflect.Value, bool, error) {
+	if path == "" {
+		return reflect.Value{}, false, fmt.Errorf("config path cannot be empty")
+	}
+
+	current := v
+	secret := false
+	for _, key := range strings.Split(path, ".") {
+		if current.Kind() == reflect.Ptr {
+			if current.IsNil() {
+				return reflect.Value{}, false, fmt.Errorf("config path %q is not set", path)
+			}
+			current = current.Elem()
+		}
+
+		switch current.Kind() {
+		case reflect.Slice:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= current.Len() {
+				return reflect.Value{}, false, fmt.Errorf("unknown config path %q", path)
+			}
+
+			current = current.Index(index)
+
+		case reflect.Struct:
+			field, ok := fieldByTag(current.Type(), key)
+			if !ok {
+				return reflect.Value{}, false, fmt.Errorf("unknown config path %q", path)
+			}
+
+			secret = secret || field.Tag.Get("secret") == "true"
+			current = current.FieldByIndex(field.Index)
+
+		default:
+			return reflect.Value{}, false, fmt.Errorf("unknown config path %q", path)
+		}
+	}
+
+	return current, secret, nil
+}
+
+// fieldByTag returns the struct field with the given yaml tag name, looking
+// into the structs inlined with the inline yaml flag
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if yamlTagName(field) == name {
+			return field, true
+		}
+
+		if field.Type.Kind() == reflect.Struct && strings.Contains(field.Tag.Get("yaml"), ",inline") {
+			if inlined, ok := fieldByTag(field.Type, name); ok {
+				inlined.Index = append([]int{i}, inlined.Index...)
+				return inlined, true
+			}
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func yamlTagName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	if tag == "" || tag == "-" {
+		return ""
+	}
+
+	return strings.Split(tag, ",")[0]
+}
+
+func collectConfigFields(v reflect.Value, prefix string, secret bool, fields *[]ConfigField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := yamlTagName(t.Field(i))
+		if name == "" {
+			continue
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldSecret := secret || t.Field(i).Tag.Get("secret") == "true"
+
+		if v.Field(i).Kind() == reflect.Struct {
+			collectConfigFields(v.Field(i), path, fieldSecret, fields)
+			continue
+		}
+
+		*fields = append(*fields, ConfigField{
+			Path:   path,
+			Value:  v.Field(i).Interface(),
+			Secret: fieldSecret,
+		})
+	}
+}
+
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+
+		if t.Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redactedValue)
+			continue
+		}
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			redactSecrets(field)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct && field.Len() > 0:
+			// Copy the entries so the redacted configuration does not share them
+			entries := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+			reflect.Copy(entries, field)
+			for j := 0; j < entries.Len(); j++ {
+				redactSecrets(entries.Index(j))
+			}
+			field.Set(entries)
+		}
+	}
+}
+
+// RedactValue returns the redacted placeholder for non-empty secret values
+func RedactValue(value interface{}, secret bool) interface{} {
+	if secret && fmt.Sprint(value) != "" {
+		return redactedValue
+	}
+
+	return value
+}
+
+// lookupNestedValue returns the value at a dotted path in nested maps
+func lookupNestedValue(values map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	current := values
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+
+	value, ok := current[keys[len(keys)-1]]
+	return value, ok
+}
+
+// setNestedValue sets the value at a dotted path in nested maps, creating them as needed
+func setNestedValue(values map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := values
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+
+	current[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+)
+
+// Engine returns the configuration of the rules engine
+func (c RulesConfig) Engine() rules.Config {
+	var config rules.Config
+
+	for _, point := range c.Points {
+		config.Points = append(config.Points, rules.Point{
+			Name:  point.Name,
+			Topic: point.Topic,
+			Field: point.Field,
+		})
+	}
+
+	for _, rule := range c.Rules {
+		config.Rules = append(config.Rules, rules.Rule{
+			Name:        rule.Name,
+			Severity:    rule.Severity,
+			Type:        rule.Type,
+			Point:       rule.Point,
+			Above:       rule.Above,
+			Below:       rule.Below,
+			Hysteresis:  rule.Hysteresis,
+			Timeout:     time.Duration(rule.Timeout) * time.Second,
+			Expression:  rule.Expression,
+			MinDuration: time.Duration(rule.MinDuration) * time.Second,
+			Message:     rule.Message,
+		})
+	}
+
+	return config
+}
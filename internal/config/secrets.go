@@ -0,0 +1,197 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// encryptedSecretPrefix marks a value sealed with the local secret key
+const encryptedSecretPrefix = "enc:"
+
+// secretKeyFilePath returns the local secret key file
+func secretKeyFilePath() string {
+	return joinPath(ConfigDir(), secretKeyFile)
+}
+
+// secretReference matches ${file:/path/to/secret} and ${env:VARIABLE}
+var secretReference = regexp.MustCompile(`^\$\{(file|env):([^}]+)\}$`)
+
+// Secret is a configuration value that is never shown in clear text. It holds
+// either a plain value, a reference like ${file:/run/secrets/mqtt} or
+// ${env:MQTT_PASS}, or an encrypted enc: value sealed with the local key file.
+type Secret string
+
+// String returns a redacted placeholder so secrets never end up in logs or output
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redactedValue
+}
+
+// IsReference reports whether the secret refers to a value stored elsewhere
+func (s Secret) IsReference() bool {
+	return secretReference.MatchString(string(s)) || strings.HasPrefix(string(s), encryptedSecretPrefix)
+}
+
+// Resolve returns the clear text value of the secret
+func (s Secret) Resolve() (string, error) {
+	value := string(s)
+
+	if strings.HasPrefix(value, encryptedSecretPrefix) {
+		return decryptSecret(strings.TrimPrefix(value, encryptedSecretPrefix))
+	}
+
+	match := secretReference.FindStringSubmatch(value)
+	if match == nil {
+		return value, nil
+	}
+
+	switch match[1] {
+	case "file":
+		data, err := os.ReadFile(match[2])
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "env":
+		secret, ok := os.LookupEnv(match[2])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", match[2])
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
+
+// EncryptSecret seals a clear text value with the local secret key. The key file
+// is created on first use.
+func EncryptSecret(value string) (Secret, error) {
+	key, err := loadSecretKey(true)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+
+	return Secret(encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted secret: %w", err)
+	}
+
+	key, err := loadSecretKey(false)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted secret: too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, was it sealed with a different key file?")
+	}
+
+	return string(plain), nil
+}
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// loadSecretKey reads the hex encoded AES-256 key, optionally creating it
+func loadSecretKey(create bool) ([]byte, error) {
+	data, err := os.ReadFile(secretKeyFilePath())
+	if os.IsNotExist(err) && create {
+		return createSecretKey()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret key file: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid secret key file %s: expected 64 hex characters", secretKeyFilePath())
+	}
+
+	return key, nil
+}
+
+func createSecretKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(secretKeyFilePath()), 0o770); err != nil {
+		return nil, fmt.Errorf("failed to create secret key directory: %w", err)
+	}
+
+	if err := os.WriteFile(secretKeyFilePath(), []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write secret key file: %w", err)
+	}
+
+	return key, nil
+}
+
+// validateSecrets checks that every secret in the configuration can be resolved
+func validateSecrets(cfg *AppConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	for _, field := range AppConfigFields(cfg) {
+		secret, ok := field.Value.(Secret)
+		if !ok {
+			continue
+		}
+
+		if _, err := secret.Resolve(); err != nil {
+			errs = append(errs, newValidationError(field.Path, "cannot resolve secret: %s", err))
+		}
+	}
+
+	// The fields of list entries are not walked, so the connections are checked here
+	for i, connection := range cfg.Connections {
+		if _, err := connection.Password.Resolve(); err != nil {
+			errs = append(errs, newValidationError(fmt.Sprintf("connections.%d.password", i), "cannot resolve secret: %s", err))
+		}
+	}
+
+	return errs
+}
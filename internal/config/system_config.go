@@ -1,13 +1,15 @@
 package config
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/utils"
 )
 
-var systemConfigFilePath = fmt.Sprintf("%s/%s", configRoot, systemConfigFile)
+// systemConfigFilePath returns the system configuration file
+func systemConfigFilePath() string {
+	return joinPath(ConfigDir(), systemConfigFile)
+}
 
 var systemConfig *SystemConfig
 
@@ -22,12 +24,12 @@ var defaultSystemConfig = SystemConfig{
 // InitSystemConfig initializes the system configuration
 func InitSystemConfig() (fileExists bool, err error) {
 	// Check if the config directory exists, if not create it
-	if utils.FileExists(systemConfigFilePath) {
+	if utils.FileExists(systemConfigFilePath()) {
 		return true, nil
 	}
 
 	// Create the configuration directory
-	os.Mkdir(configRoot, 0o770)
+	os.MkdirAll(ConfigDir(), 0o770)
 
 	// This is just to set the default values
 	GetSystemConfig()
@@ -43,7 +45,7 @@ func InitSystemConfig() (fileExists bool, err error) {
 
 // GetSystemConfig returns the system configuration
 func GetSystemConfig() *SystemConfig {
-	err := loadConfig(systemConfigFilePath, &systemConfig)
+	err := loadConfig(systemConfigFilePath(), &systemConfig)
 	if err != nil {
 		systemConfig = &defaultSystemConfig
 	}
@@ -52,7 +54,7 @@ func GetSystemConfig() *SystemConfig {
 
 // SaveSystemConfig saves the system configuration
 func SaveSystemConfig(createFile bool) error {
-	err := saveConfig(systemConfigFilePath, systemConfig, createFile)
+	err := saveConfig(systemConfigFilePath(), systemConfig, createFile)
 	if err != nil {
 		return err
 	}
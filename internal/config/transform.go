@@ -0,0 +1,33 @@
+package config
+
+import (
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/transform"
+)
+
+// Engine returns the configuration of the transform stage
+func (c TransformConfig) Engine() transform.Config {
+	config := transform.Config{
+		Passthrough: !c.DropUnmatched,
+		Metadata:    c.Metadata,
+	}
+
+	for _, rule := range c.Transforms {
+		t := transform.Transform{
+			Name:     rule.Name,
+			Match:    rule.Match,
+			Topic:    rule.Topic,
+			Filter:   rule.Filter,
+			Keep:     rule.Keep,
+			Remove:   rule.Remove,
+			Metadata: rule.Metadata,
+		}
+
+		for _, field := range rule.Fields {
+			t.Fields = append(t.Fields, transform.Field{Name: field.Name, Expression: field.Expression})
+		}
+
+		config.Transforms = append(config.Transforms, t)
+	}
+
+	return config
+}
@@ -3,10 +3,15 @@ package config
 // ======================== Config ======================== //
 
 type Config struct {
-	PersistFilePath string        `mapstructure:"persist_file_path" yaml:"persist_file_path"`
-	Flags           *FlagsConfig  `mapstructure:"flags" yaml:"flags"`
-	System          *SystemConfig `mapstructure:"system" yaml:"system"`
-	App             *AppConfig    `mapstructure:"app" yaml:"app"`
+	PersistFilePath     string        `mapstructure:"persist_file_path" yaml:"persist_file_path"`
+	ConnectionsFilePath string        `mapstructure:"connections_file_path" yaml:"connections_file_path"`
+	PidFilePath         string        `mapstructure:"pid_file_path" yaml:"pid_file_path"`
+	DaemonOutputPath    string        `mapstructure:"daemon_output_path" yaml:"daemon_output_path"`
+	TmpDirPath          string        `mapstructure:"tmp_dir_path" yaml:"tmp_dir_path"`
+	StopFilePath        string        `mapstructure:"stop_file_path" yaml:"stop_file_path"`
+	Flags               *FlagsConfig  `mapstructure:"flags" yaml:"flags"`
+	System              *SystemConfig `mapstructure:"system" yaml:"system"`
+	App                 *AppConfig    `mapstructure:"app" yaml:"app"`
 }
 
 // ======================== Flags ======================== //
@@ -31,6 +36,15 @@ type SystemConfig struct {
 type AppConfig struct {
 	Logging LoggingConfig `mapstructure:"logging" yaml:"logging"`
 	Mqtt    MqttConfig    `mapstructure:"mqtt" yaml:"mqtt"`
+	// Connections are additional brokers, each connected on its own next to mqtt
+	Connections []ConnectionConfig `mapstructure:"connections" yaml:"connections"`
+	Persist     PersistConfig      `mapstructure:"persist" yaml:"persist"`
+	Rules       RulesConfig        `mapstructure:"rules" yaml:"rules"`
+	Watchdog    WatchdogConfig     `mapstructure:"watchdog" yaml:"watchdog"`
+	Filter      FilterConfig       `mapstructure:"filter" yaml:"filter"`
+	Transform   TransformConfig    `mapstructure:"transform" yaml:"transform"`
+	Aggregate   AggregateConfig    `mapstructure:"aggregate" yaml:"aggregate"`
+	Bridge      BridgeConfig       `mapstructure:"bridge" yaml:"bridge"`
 }
 
 type LoggingConfig struct {
@@ -41,17 +55,263 @@ type LoggingConfig struct {
 	MaxAge     int    `mapstructure:"max_age" yaml:"max_age"`
 	Compress   bool   `mapstructure:"compress" yaml:"compress"`
 	AddTime    bool   `mapstructure:"add_time" yaml:"add_time"`
+	ForceLevel bool   `mapstructure:"force_level" yaml:"force_level"`
+	// Loggers overrides the level of named loggers, for example mqtt: debug
+	Loggers  map[string]string `mapstructure:"loggers" yaml:"loggers"`
+	Sampling SamplingConfig    `mapstructure:"sampling" yaml:"sampling"`
+	// Outputs lists where the logs are written. When empty the logs go to
+	// stdout in the console format and to FilePath as JSON.
+	Outputs []LogOutputConfig `mapstructure:"outputs" yaml:"outputs"`
+}
+
+type LogOutputConfig struct {
+	Type          string            `mapstructure:"type" yaml:"type"`
+	Format        string            `mapstructure:"format" yaml:"format,omitempty"`
+	Level         string            `mapstructure:"level" yaml:"level,omitempty"`
+	Path          string            `mapstructure:"path" yaml:"path,omitempty"`
+	Network       string            `mapstructure:"network" yaml:"network,omitempty"`
+	Address       string            `mapstructure:"address" yaml:"address,omitempty"`
+	Tag           string            `mapstructure:"tag" yaml:"tag,omitempty"`
+	URL           string            `mapstructure:"url" yaml:"url,omitempty"`
+	Labels        map[string]string `mapstructure:"labels" yaml:"labels,omitempty"`
+	BatchSize     int               `mapstructure:"batch_size" yaml:"batch_size,omitempty"`
+	FlushInterval int               `mapstructure:"flush_interval" yaml:"flush_interval,omitempty"`
+}
+
+type SamplingConfig struct {
+	Initial    int `mapstructure:"initial" yaml:"initial"`
+	Thereafter int `mapstructure:"thereafter" yaml:"thereafter"`
+	Tick       int `mapstructure:"tick" yaml:"tick"`
 }
 
 type MqttConfig struct {
-	Broker             string `mapstructure:"broker" yaml:"broker"`
-	ClientId           string `mapstructure:"client_id" yaml:"client_id"`
-	Port               int    `mapstructure:"port" yaml:"port"`
-	Topic              string `mapstructure:"topic" yaml:"topic"`
-	Qos                byte   `mapstructure:"qos" yaml:"qos"`
-	CleanSession       bool   `mapstructure:"clean_session" yaml:"clean_session"`
-	KeepAlive          int    `mapstructure:"keep_alive" yaml:"keep_alive"`
-	ReconnectOnFailure bool   `mapstructure:"reconnect_on_failure" yaml:"reconnect_on_failure"`
-	Username           string `mapstructure:"username" yaml:"username"`
-	Password           string `mapstructure:"password" yaml:"password"`
+	Broker             string        `mapstructure:"broker" yaml:"broker"`
+	ClientId           string        `mapstructure:"client_id" yaml:"client_id"`
+	Port               int           `mapstructure:"port" yaml:"port"`
+	Topic              string        `mapstructure:"topic" yaml:"topic"`
+	Qos                byte          `mapstructure:"qos" yaml:"qos"`
+	CleanSession       bool          `mapstructure:"clean_session" yaml:"clean_session"`
+	KeepAlive          int           `mapstructure:"keep_alive" yaml:"keep_alive"`
+	ReconnectOnFailure bool          `mapstructure:"reconnect_on_failure" yaml:"reconnect_on_failure"`
+	Username           string        `mapstructure:"username" yaml:"username"`
+	Password           Secret        `mapstructure:"password" yaml:"password" secret:"true"`
+	TLS                MqttTLSConfig `mapstructure:"tls" yaml:"tls"`
+}
+
+type MqttTLSConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// CAFile verifies the broker instead of the system certificates
+	CAFile string `mapstructure:"ca_file" yaml:"ca_file"`
+	// CertFile and KeyFile authenticate the client with a certificate
+	CertFile           string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile            string `mapstructure:"key_file" yaml:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// ConnectionConfig is a named connection with the same settings as mqtt
+type ConnectionConfig struct {
+	Name       string `mapstructure:"name" yaml:"name"`
+	MqttConfig `mapstructure:",squash" yaml:",inline"`
+	// Transform replaces the transform section for the messages of this connection
+	Transform *TransformConfig `mapstructure:"transform" yaml:"transform,omitempty"`
+}
+
+type PersistConfig struct {
+	// Backend is one of file, memory or bolt
+	Backend string `mapstructure:"backend" yaml:"backend"`
+	// FilePath defaults to ./persist/persist.json for the file backend and
+	// ./persist/persist.db for the bolt backend. Relative paths are relative
+	// to the home directory.
+	FilePath string `mapstructure:"file_path" yaml:"file_path"`
+	// History keeps the recent transitions of chosen keys
+	History PersistHistoryConfig `mapstructure:"history" yaml:"history"`
+}
+
+type PersistHistoryConfig struct {
+	Keys []string `mapstructure:"keys" yaml:"keys"`
+	// Limit is the number of transitions kept per key
+	Limit int `mapstructure:"limit" yaml:"limit"`
+}
+
+type RulesConfig struct {
+	// AlarmTopic is the topic prefix the alarms are published to, followed by
+	// the rule name
+	AlarmTopic string `mapstructure:"alarm_topic" yaml:"alarm_topic"`
+	AlarmQos   byte   `mapstructure:"alarm_qos" yaml:"alarm_qos"`
+	// Retain keeps the last alarm of each rule on the broker
+	Retain bool              `mapstructure:"retain" yaml:"retain"`
+	Points []RulePointConfig `mapstructure:"points" yaml:"points"`
+	Rules  []RuleConfig      `mapstructure:"rules" yaml:"rules"`
+}
+
+type RulePointConfig struct {
+	Name  string `mapstructure:"name" yaml:"name"`
+	Topic string `mapstructure:"topic" yaml:"topic"`
+	// Field is the dotted path of the value in a JSON payload
+	Field string `mapstructure:"field" yaml:"field,omitempty"`
+}
+
+type RuleConfig struct {
+	Name     string `mapstructure:"name" yaml:"name"`
+	Severity string `mapstructure:"severity" yaml:"severity"`
+	// Type is one of threshold, rate, stale or expression
+	Type       string   `mapstructure:"type" yaml:"type"`
+	Point      string   `mapstructure:"point" yaml:"point,omitempty"`
+	Above      *float64 `mapstructure:"above" yaml:"above,omitempty"`
+	Below      *float64 `mapstructure:"below" yaml:"below,omitempty"`
+	Hysteresis float64  `mapstructure:"hysteresis" yaml:"hysteresis,omitempty"`
+	// Timeout of a stale rule in seconds
+	Timeout    int    `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	Expression string `mapstructure:"expression" yaml:"expression,omitempty"`
+	// MinDuration in seconds the condition must hold before the alarm is
+	// raised or cleared
+	MinDuration int    `mapstructure:"min_duration" yaml:"min_duration,omitempty"`
+	Message     string `mapstructure:"message" yaml:"message,omitempty"`
+}
+
+type WatchdogConfig struct {
+	// Pattern derives the device ID from the topic, for example
+	// site/{site}/{device}/#. The watchdog is off when it is empty.
+	Pattern string `mapstructure:"pattern" yaml:"pattern"`
+	// Interval in seconds a device may be silent before it is flagged
+	Interval int `mapstructure:"interval" yaml:"interval"`
+	// EventTopic is the topic prefix the device events are published to,
+	// followed by the device ID. The events are only logged when it is empty.
+	EventTopic string                 `mapstructure:"event_topic" yaml:"event_topic"`
+	Devices    []WatchdogDeviceConfig `mapstructure:"devices" yaml:"devices"`
+}
+
+type WatchdogDeviceConfig struct {
+	// Device is a device ID or a filter over device IDs with + and #
+	Device string `mapstructure:"device" yaml:"device"`
+	// Interval in seconds
+	Interval int `mapstructure:"interval" yaml:"interval"`
+}
+
+type FilterConfig struct {
+	// DedupWindow drops a payload identical to one passed on the same topic
+	// within this many seconds, 0 disables it
+	DedupWindow int                     `mapstructure:"dedup_window" yaml:"dedup_window"`
+	Deadbands   []FilterDeadbandConfig  `mapstructure:"deadbands" yaml:"deadbands"`
+	RateLimits  []FilterRateLimitConfig `mapstructure:"rate_limits" yaml:"rate_limits"`
+}
+
+type FilterDeadbandConfig struct {
+	Match string `mapstructure:"match" yaml:"match"`
+	// Field is the dotted path of the value in a JSON payload
+	Field    string  `mapstructure:"field" yaml:"field,omitempty"`
+	Absolute float64 `mapstructure:"absolute" yaml:"absolute,omitempty"`
+	Percent  float64 `mapstructure:"percent" yaml:"percent,omitempty"`
+}
+
+type FilterRateLimitConfig struct {
+	Match string `mapstructure:"match" yaml:"match"`
+	// Messages is the number of messages per topic passed in each interval
+	Messages int `mapstructure:"messages" yaml:"messages"`
+	// Interval is in seconds
+	Interval int `mapstructure:"interval" yaml:"interval"`
+}
+
+type TransformConfig struct {
+	// DropUnmatched drops the messages that no transform matches, instead of
+	// passing them on unchanged
+	DropUnmatched bool `mapstructure:"drop_unmatched" yaml:"drop_unmatched"`
+	// Metadata is added to the output of every transform, for example site_id
+	Metadata   map[string]interface{} `mapstructure:"metadata" yaml:"metadata"`
+	Transforms []TransformRuleConfig  `mapstructure:"transforms" yaml:"transforms"`
+}
+
+type TransformRuleConfig struct {
+	Name string `mapstructure:"name" yaml:"name"`
+	// Match is a topic filter whose + and # wildcards are captured as {1}, {2}
+	Match  string                 `mapstructure:"match" yaml:"match"`
+	Topic  string                 `mapstructure:"topic" yaml:"topic,omitempty"`
+	Filter string                 `mapstructure:"filter" yaml:"filter,omitempty"`
+	Fields []TransformFieldConfig `mapstructure:"fields" yaml:"fields,omitempty"`
+	// Keep keeps the input fields that are not removed
+	Keep     bool                   `mapstructure:"keep" yaml:"keep,omitempty"`
+	Remove   []string               `mapstructure:"remove" yaml:"remove,omitempty"`
+	Metadata map[string]interface{} `mapstructure:"metadata" yaml:"metadata,omitempty"`
+}
+
+type TransformFieldConfig struct {
+	Name       string `mapstructure:"name" yaml:"name"`
+	Expression string `mapstructure:"expression" yaml:"expression"`
+}
+
+type AggregateConfig struct {
+	// SummaryTopic is the topic prefix the summaries are published to,
+	// followed by the point and the window, for example bms/summary/temp/5m
+	SummaryTopic string `mapstructure:"summary_topic" yaml:"summary_topic"`
+	Qos          byte   `mapstructure:"qos" yaml:"qos"`
+	Retain       bool   `mapstructure:"retain" yaml:"retain"`
+	// Sink is mqtt, the default, to publish the summaries through the default
+	// connection or bridge to forward them through the bridge routes only
+	Sink string `mapstructure:"sink" yaml:"sink"`
+	// Windows are the window lengths in seconds
+	Windows []int `mapstructure:"windows" yaml:"windows"`
+	// Watermark in seconds a window stays open after its end for late values
+	Watermark int                    `mapstructure:"watermark" yaml:"watermark"`
+	Points    []AggregatePointConfig `mapstructure:"points" yaml:"points"`
+}
+
+type AggregatePointConfig struct {
+	Name  string `mapstructure:"name" yaml:"name"`
+	Topic string `mapstructure:"topic" yaml:"topic"`
+	// Field is the dotted path of the value in a JSON payload
+	Field string `mapstructure:"field" yaml:"field,omitempty"`
+	// TimeField is the dotted path of the measurement time in a JSON payload
+	TimeField string `mapstructure:"time_field" yaml:"time_field,omitempty"`
+}
+
+type BridgeConfig struct {
+	// Enabled forwards the messages leaving the pipeline to the destination broker
+	Enabled     bool                    `mapstructure:"enabled" yaml:"enabled"`
+	Destination BridgeDestinationConfig `mapstructure:"destination" yaml:"destination"`
+	Routes      []BridgeRouteConfig     `mapstructure:"routes" yaml:"routes"`
+	// LoopWindow in seconds a forwarded message is remembered by its source
+	// topic and payload to drop it when it comes back
+	LoopWindow int               `mapstructure:"loop_window" yaml:"loop_window"`
+	Batch      BridgeBatchConfig `mapstructure:"batch" yaml:"batch"`
+}
+
+type BridgeBatchConfig struct {
+	// Enabled packs the forwarded messages into batches instead of publishing
+	// each on its own
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Topic the batches are published to, followed by a level naming their
+	// encoding such as $batch.ndjson.gz
+	Topic string `mapstructure:"topic" yaml:"topic"`
+	Qos   byte   `mapstructure:"qos" yaml:"qos"`
+	// Format is json for a JSON array or ndjson for one message per line
+	Format string `mapstructure:"format" yaml:"format"`
+	// Compression is none, gzip or zstd
+	Compression string `mapstructure:"compression" yaml:"compression"`
+	// MaxMessages and MaxBytes flush a batch when it is full, 0 for no limit
+	MaxMessages int `mapstructure:"max_messages" yaml:"max_messages"`
+	MaxBytes    int `mapstructure:"max_bytes" yaml:"max_bytes"`
+	// Interval in seconds a batch waits for more messages before it is flushed
+	Interval int `mapstructure:"interval" yaml:"interval"`
+}
+
+type BridgeDestinationConfig struct {
+	Broker       string        `mapstructure:"broker" yaml:"broker"`
+	Port         int           `mapstructure:"port" yaml:"port"`
+	ClientId     string        `mapstructure:"client_id" yaml:"client_id"`
+	CleanSession bool          `mapstructure:"clean_session" yaml:"clean_session"`
+	KeepAlive    int           `mapstructure:"keep_alive" yaml:"keep_alive"`
+	Username     string        `mapstructure:"username" yaml:"username"`
+	Password     Secret        `mapstructure:"password" yaml:"password" secret:"true"`
+	TLS          MqttTLSConfig `mapstructure:"tls" yaml:"tls"`
+}
+
+type BridgeRouteConfig struct {
+	// Filter selects the topics to forward, with + and # wildcards
+	Filter string `mapstructure:"filter" yaml:"filter"`
+	// StripPrefix is removed from the topic before Prefix is added
+	StripPrefix string `mapstructure:"strip_prefix" yaml:"strip_prefix,omitempty"`
+	Prefix      string `mapstructure:"prefix" yaml:"prefix,omitempty"`
+	// Qos of the forwarded messages, the mqtt.qos when not set
+	Qos    *byte `mapstructure:"qos" yaml:"qos,omitempty"`
+	Retain bool  `mapstructure:"retain" yaml:"retain,omitempty"`
 }
@@ -0,0 +1,741 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/batch"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/bridge"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/watchdog"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlErrorLine matches the "line N: message" format used by yaml.v3 errors
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// connectionName matches the names allowed for connections, which are used
+// as a level of the state keys
+var connectionName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidationError describes a single invalid configuration value
+type ValidationError struct {
+	File    string
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	var location string
+
+	switch {
+	case e.File != "" && e.Line > 0:
+		location = fmt.Sprintf("%s:%d: ", e.File, e.Line)
+	case e.File != "":
+		location = fmt.Sprintf("%s: ", e.File)
+	}
+
+	if e.Path == "" {
+		return location + e.Message
+	}
+
+	return fmt.Sprintf("%s%s: %s", location, e.Path, e.Message)
+}
+
+// ValidationErrors is a list of validation errors that is itself an error
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// configSource is a parsed configuration file
+type configSource struct {
+	path string
+	root *yaml.Node
+}
+
+// LoadAppConfig reads, decodes and validates the application configuration file
+// and the overlay of the active environment.
+// On success the shared application configuration is replaced by the new one,
+// which is returned; the previous one is left as it was for its readers.
+// On failure the shared configuration is left untouched and a ValidationErrors is
+// returned that lists every problem found in the files.
+func LoadAppConfig() (*AppConfig, error) {
+	if !utils.FileExists(appConfigFilePath()) {
+		// Mirror GetAppConfig and run with the defaults when there is no file
+		return GetAppConfig()
+	}
+
+	sources, err := readAppConfigSources()
+	if err != nil {
+		return nil, err
+	}
+
+	newCfg, err := decodeAppConfig(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig = newCfg
+
+	return appConfig, nil
+}
+
+// ValidateAppConfig validates the application configuration. Line numbers are
+// taken from the configuration file on disk when it exists.
+func ValidateAppConfig(cfg *AppConfig) error {
+	errs := validateAppConfig(cfg)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var sources []configSource
+	for _, path := range AppConfigFiles() {
+		if data, err := os.ReadFile(path); err == nil {
+			sources = append(sources, configSource{path: path, root: parseYAMLNode(data)})
+		}
+	}
+
+	annotateValidationErrors(errs, sources)
+
+	return errs
+}
+
+// readAppConfigSources parses the application configuration file and the
+// overlay of the active environment
+func readAppConfigSources() ([]configSource, error) {
+	var sources []configSource
+	for _, path := range AppConfigFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+
+		root := &yaml.Node{}
+		if err := yaml.Unmarshal(data, root); err != nil {
+			return nil, yamlValidationErrors(err, configSource{path: path})
+		}
+
+		sources = append(sources, configSource{path: path, root: root})
+	}
+
+	return sources, nil
+}
+
+// mergeAppConfigSources decodes each source over the previous ones, starting
+// from the defaults, into a new AppConfig
+func mergeAppConfigSources(sources []configSource) (*AppConfig, error) {
+	newCfg := DefaultAppConfig()
+	for _, source := range sources {
+		if err := source.root.Decode(newCfg); err != nil {
+			return nil, yamlValidationErrors(err, source)
+		}
+	}
+
+	return newCfg, nil
+}
+
+// decodeAppConfig merges the sources into a new AppConfig and validates the result
+func decodeAppConfig(sources []configSource) (*AppConfig, error) {
+	newCfg, err := mergeAppConfigSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := validateAppConfig(newCfg)
+	errs = append(errs, validateSecrets(newCfg)...)
+	if len(errs) > 0 {
+		annotateValidationErrors(errs, sources)
+		return nil, errs
+	}
+
+	return newCfg, nil
+}
+
+// validateAppConfig checks every field of the application configuration
+func validateAppConfig(cfg *AppConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	errs = append(errs, validateLoggingConfig("logging", cfg.Logging)...)
+	errs = append(errs, validateMQTTConfig("mqtt", cfg.Mqtt)...)
+	errs = append(errs, validateConnectionsConfig("connections", cfg.Connections)...)
+	errs = append(errs, validatePersistConfig("persist", cfg.Persist)...)
+	errs = append(errs, validateRulesConfig("rules", cfg.Rules)...)
+	errs = append(errs, validateWatchdogConfig("watchdog", cfg.Watchdog)...)
+	errs = append(errs, validateFilterConfig("filter", cfg.Filter)...)
+	errs = append(errs, validateTransformConfig("transform", cfg.Transform)...)
+	errs = append(errs, validateAggregateConfig("aggregate", cfg.Aggregate, cfg.Bridge)...)
+	errs = append(errs, validateBridgeConfig("bridge", cfg.Bridge)...)
+
+	return errs
+}
+
+func validateLoggingConfig(prefix string, cfg LoggingConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if !isValidLogLevel(cfg.Level) {
+		errs = append(errs, newValidationError(prefix+".level", "invalid log level %q, valid log levels: 'debug', 'info', 'warn', 'error', 'dpanic', 'panic', 'fatal'", cfg.Level))
+	}
+
+	names := make([]string, 0, len(cfg.Loggers))
+	for name := range cfg.Loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if level := cfg.Loggers[name]; !isValidLogLevel(level) {
+			errs = append(errs, newValidationError(prefix+".loggers."+name, "invalid log level %q, valid log levels: 'debug', 'info', 'warn', 'error', 'dpanic', 'panic', 'fatal'", level))
+		}
+	}
+
+	if cfg.Sampling.Initial < 0 {
+		errs = append(errs, newValidationError(prefix+".sampling.initial", "must not be negative, got %d", cfg.Sampling.Initial))
+	}
+
+	if cfg.Sampling.Thereafter < 0 {
+		errs = append(errs, newValidationError(prefix+".sampling.thereafter", "must not be negative, got %d", cfg.Sampling.Thereafter))
+	}
+
+	if cfg.Sampling.Tick < 0 {
+		errs = append(errs, newValidationError(prefix+".sampling.tick", "must not be negative, got %d", cfg.Sampling.Tick))
+	}
+
+	for i, output := range cfg.Outputs {
+		errs = append(errs, validateLogOutputConfig(fmt.Sprintf("%s.outputs.%d", prefix, i), output)...)
+	}
+
+	if strings.TrimSpace(cfg.FilePath) == "" {
+		errs = append(errs, newValidationError(prefix+".file_path", "must not be empty"))
+	}
+
+	if cfg.MaxSize < 0 {
+		errs = append(errs, newValidationError(prefix+".max_size", "must not be negative, got %d", cfg.MaxSize))
+	}
+
+	if cfg.MaxBackups < 0 {
+		errs = append(errs, newValidationError(prefix+".max_backups", "must not be negative, got %d", cfg.MaxBackups))
+	}
+
+	if cfg.MaxAge < 0 {
+		errs = append(errs, newValidationError(prefix+".max_age", "must not be negative, got %d", cfg.MaxAge))
+	}
+
+	return errs
+}
+
+func validateLogOutputConfig(prefix string, cfg LogOutputConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	formats := map[string][]string{
+		"stdout": {"", "console", "json"},
+		"file":   {"", "console", "json"},
+		"syslog": {"", "console", "json"},
+		"http":   {"", "loki", "json"},
+	}
+
+	validFormats, ok := formats[cfg.Type]
+	if !ok {
+		errs = append(errs, newValidationError(prefix+".type", "invalid output type %q, valid types: 'stdout', 'file', 'syslog', 'http'", cfg.Type))
+		return errs
+	}
+
+	if !slices.Contains(validFormats, strings.ToLower(cfg.Format)) {
+		errs = append(errs, newValidationError(prefix+".format", "invalid format %q for a %s output, valid formats: %s", cfg.Format, cfg.Type, strings.Join(validFormats[1:], ", ")))
+	}
+
+	if cfg.Level != "" && !isValidLogLevel(cfg.Level) {
+		errs = append(errs, newValidationError(prefix+".level", "invalid log level %q, valid log levels: 'debug', 'info', 'warn', 'error', 'dpanic', 'panic', 'fatal'", cfg.Level))
+	}
+
+	switch cfg.Type {
+	case "syslog":
+		switch cfg.Network {
+		case "", "udp", "tcp", "unix", "unixgram":
+		default:
+			errs = append(errs, newValidationError(prefix+".network", "invalid network %q, valid networks: 'udp', 'tcp', 'unix'", cfg.Network))
+		}
+	case "http":
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, newValidationError(prefix+".url", "must be an http or https URL, got %q", cfg.URL))
+		}
+
+		if cfg.BatchSize < 0 {
+			errs = append(errs, newValidationError(prefix+".batch_size", "must not be negative, got %d", cfg.BatchSize))
+		}
+
+		if cfg.FlushInterval < 0 {
+			errs = append(errs, newValidationError(prefix+".flush_interval", "must not be negative, got %d", cfg.FlushInterval))
+		}
+	}
+
+	return errs
+}
+
+func isValidLogLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "error", "dpanic", "panic", "fatal":
+		return true
+	}
+
+	return false
+}
+
+func validateMQTTConfig(prefix string, cfg MqttConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if strings.TrimSpace(cfg.Broker) == "" {
+		errs = append(errs, newValidationError(prefix+".broker", "must not be empty"))
+	} else if strings.Contains(cfg.Broker, "://") || strings.ContainsAny(cfg.Broker, " /") {
+		errs = append(errs, newValidationError(prefix+".broker", "must be a host name without scheme or path, got %q", cfg.Broker))
+	}
+
+	if strings.TrimSpace(cfg.ClientId) == "" {
+		errs = append(errs, newValidationError(prefix+".client_id", "must not be empty"))
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		errs = append(errs, newValidationError(prefix+".port", "must be between 1 and 65535, got %d", cfg.Port))
+	}
+
+	if strings.TrimSpace(cfg.Topic) == "" {
+		errs = append(errs, newValidationError(prefix+".topic", "must not be empty"))
+	}
+
+	if cfg.Qos > 2 {
+		errs = append(errs, newValidationError(prefix+".qos", "must be 0, 1 or 2, got %d", cfg.Qos))
+	}
+
+	if cfg.KeepAlive < 0 {
+		errs = append(errs, newValidationError(prefix+".keep_alive", "must not be negative, got %d", cfg.KeepAlive))
+	}
+
+	errs = append(errs, validateTLSConfig(prefix+".tls", cfg.TLS)...)
+
+	return errs
+}
+
+func validateConnectionsConfig(prefix string, connections []ConnectionConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	names := map[string]bool{DefaultConnection: true}
+	for i, connection := range connections {
+		connectionPrefix := fmt.Sprintf("%s.%d", prefix, i)
+
+		switch {
+		case !connectionName.MatchString(connection.Name):
+			errs = append(errs, newValidationError(connectionPrefix+".name", "must contain only letters, digits, - and _, got %q", connection.Name))
+		case connection.Name == DefaultConnection:
+			errs = append(errs, newValidationError(connectionPrefix+".name", "%q is the name of the mqtt connection", DefaultConnection))
+		case names[connection.Name]:
+			errs = append(errs, newValidationError(connectionPrefix+".name", "duplicate connection %q", connection.Name))
+		}
+		names[connection.Name] = true
+
+		errs = append(errs, validateMQTTConfig(connectionPrefix, connection.MqttConfig)...)
+
+		if connection.Transform != nil {
+			errs = append(errs, validateTransformConfig(connectionPrefix+".transform", *connection.Transform)...)
+		}
+	}
+
+	return errs
+}
+
+func validateTLSConfig(prefix string, cfg MqttTLSConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		errs = append(errs, newValidationError(prefix+".cert_file", "cert_file and key_file must be set together"))
+	}
+
+	for _, file := range []struct{ name, path string }{{"ca_file", cfg.CAFile}, {"cert_file", cfg.CertFile}, {"key_file", cfg.KeyFile}} {
+		if cfg.Enabled && file.path != "" && !utils.FileExists(ResolvePath(file.path)) {
+			errs = append(errs, newValidationError(prefix+"."+file.name, "file %s does not exist", file.path))
+		}
+	}
+
+	return errs
+}
+
+func validatePersistConfig(prefix string, cfg PersistConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	switch cfg.Backend {
+	case "", "file", "memory", "bolt":
+	default:
+		errs = append(errs, newValidationError(prefix+".backend", "invalid persister backend %q, valid backends: 'file', 'memory', 'bolt'", cfg.Backend))
+	}
+
+	for i, key := range cfg.History.Keys {
+		if strings.TrimSpace(key) == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
+			errs = append(errs, newValidationError(fmt.Sprintf("%s.history.keys.%d", prefix, i), "must be a dotted key such as mqtt.default.status, got %q", key))
+		} else if key == "history" || strings.HasPrefix(key, "history.") {
+			errs = append(errs, newValidationError(fmt.Sprintf("%s.history.keys.%d", prefix, i), "must not be below history, which holds the recorded history"))
+		}
+	}
+
+	if cfg.History.Limit < 0 {
+		errs = append(errs, newValidationError(prefix+".history.limit", "must not be negative, got %d", cfg.History.Limit))
+	}
+
+	return errs
+}
+
+func validateRulesConfig(prefix string, cfg RulesConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if strings.ContainsAny(cfg.AlarmTopic, "+#") {
+		errs = append(errs, newValidationError(prefix+".alarm_topic", "must not contain wildcards, got %q", cfg.AlarmTopic))
+	}
+
+	if cfg.AlarmQos > 2 {
+		errs = append(errs, newValidationError(prefix+".alarm_qos", "must be 0, 1 or 2, got %d", cfg.AlarmQos))
+	}
+
+	for _, err := range cfg.Engine().Validate() {
+		errs = append(errs, newValidationError(prefix+"."+err.Path, "%s", err.Message))
+	}
+
+	return errs
+}
+
+func validateWatchdogConfig(prefix string, cfg WatchdogConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if cfg.Pattern != "" {
+		if _, err := watchdog.ParsePattern(cfg.Pattern); err != nil {
+			errs = append(errs, newValidationError(prefix+".pattern", "%s", err))
+		}
+	}
+
+	if cfg.Interval < 0 {
+		errs = append(errs, newValidationError(prefix+".interval", "must not be negative, got %d", cfg.Interval))
+	}
+
+	if strings.ContainsAny(cfg.EventTopic, "+#") {
+		errs = append(errs, newValidationError(prefix+".event_topic", "must not contain wildcards, got %q", cfg.EventTopic))
+	}
+
+	for i, device := range cfg.Devices {
+		if device.Device == "" {
+			errs = append(errs, newValidationError(fmt.Sprintf("%s.devices.%d.device", prefix, i), "must not be empty"))
+		}
+
+		if device.Interval <= 0 {
+			errs = append(errs, newValidationError(fmt.Sprintf("%s.devices.%d.interval", prefix, i), "must be positive, got %d", device.Interval))
+		}
+	}
+
+	return errs
+}
+
+func validateFilterConfig(prefix string, cfg FilterConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	for _, err := range cfg.Engine().Validate() {
+		errs = append(errs, newValidationError(prefix+"."+err.Path, "%s", err.Message))
+	}
+
+	return errs
+}
+
+func validateTransformConfig(prefix string, cfg TransformConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	for _, err := range cfg.Engine().Validate() {
+		errs = append(errs, newValidationError(prefix+"."+err.Path, "%s", err.Message))
+	}
+
+	return errs
+}
+
+func validateAggregateConfig(prefix string, cfg AggregateConfig, bridgeCfg BridgeConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if len(cfg.Points) > 0 && cfg.SummaryTopic == "" {
+		errs = append(errs, newValidationError(prefix+".summary_topic", "must not be empty when points are aggregated"))
+	} else if strings.ContainsAny(cfg.SummaryTopic, "+#") {
+		errs = append(errs, newValidationError(prefix+".summary_topic", "must not contain wildcards, got %q", cfg.SummaryTopic))
+	}
+
+	if cfg.Qos > 2 {
+		errs = append(errs, newValidationError(prefix+".qos", "must be 0, 1 or 2, got %d", cfg.Qos))
+	}
+
+	switch cfg.Sink {
+	case "", AggregateSinkMQTT:
+	case AggregateSinkBridge:
+		if len(cfg.Points) > 0 && !bridgeCfg.Enabled {
+			errs = append(errs, newValidationError(prefix+".sink", "bridge needs bridge.enabled"))
+		}
+	default:
+		errs = append(errs, newValidationError(prefix+".sink", "invalid sink %q, valid sinks: '%s', '%s'", cfg.Sink, AggregateSinkMQTT, AggregateSinkBridge))
+	}
+
+	for _, err := range cfg.Engine().Validate() {
+		errs = append(errs, newValidationError(prefix+"."+err.Path, "%s", err.Message))
+	}
+
+	return errs
+}
+
+func validateBridgeConfig(prefix string, cfg BridgeConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if cfg.LoopWindow < 0 {
+		errs = append(errs, newValidationError(prefix+".loop_window", "must not be negative, got %d", cfg.LoopWindow))
+	}
+
+	for i, route := range cfg.Routes {
+		routePrefix := fmt.Sprintf("%s.routes.%d", prefix, i)
+
+		if err := bridge.ValidateFilter(route.Filter); err != nil {
+			errs = append(errs, newValidationError(routePrefix+".filter", "%s", err))
+		}
+
+		if strings.Trim(route.Prefix, "/") == "" {
+			errs = append(errs, newValidationError(routePrefix+".prefix", "must not be empty, it marks the forwarded messages so they are not forwarded back"))
+		} else if strings.ContainsAny(route.Prefix, "+#") {
+			errs = append(errs, newValidationError(routePrefix+".prefix", "must not contain wildcards, got %q", route.Prefix))
+		}
+
+		if route.Qos != nil && *route.Qos > 2 {
+			errs = append(errs, newValidationError(routePrefix+".qos", "must be 0, 1 or 2, got %d", *route.Qos))
+		}
+	}
+
+	errs = append(errs, validateBridgeBatchConfig(prefix+".batch", cfg.Batch)...)
+
+	if !cfg.Enabled {
+		return errs
+	}
+
+	destination := cfg.Destination
+	if strings.TrimSpace(destination.Broker) == "" {
+		errs = append(errs, newValidationError(prefix+".destination.broker", "must not be empty when the bridge is enabled"))
+	} else if strings.Contains(destination.Broker, "://") || strings.ContainsAny(destination.Broker, " /") {
+		errs = append(errs, newValidationError(prefix+".destination.broker", "must be a host name without scheme or path, got %q", destination.Broker))
+	}
+
+	if destination.Port < 1 || destination.Port > 65535 {
+		errs = append(errs, newValidationError(prefix+".destination.port", "must be between 1 and 65535, got %d", destination.Port))
+	}
+
+	if strings.TrimSpace(destination.ClientId) == "" {
+		errs = append(errs, newValidationError(prefix+".destination.client_id", "must not be empty"))
+	}
+
+	if destination.KeepAlive < 0 {
+		errs = append(errs, newValidationError(prefix+".destination.keep_alive", "must not be negative, got %d", destination.KeepAlive))
+	}
+
+	errs = append(errs, validateTLSConfig(prefix+".destination.tls", destination.TLS)...)
+
+	if len(cfg.Routes) == 0 {
+		errs = append(errs, newValidationError(prefix+".routes", "at least one route is needed when the bridge is enabled"))
+	}
+
+	return errs
+}
+
+func validateBridgeBatchConfig(prefix string, cfg BridgeBatchConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if !cfg.Enabled {
+		return errs
+	}
+
+	if cfg.Topic == "" {
+		errs = append(errs, newValidationError(prefix+".topic", "must not be empty when batching is enabled"))
+	} else if strings.ContainsAny(cfg.Topic, "+#") {
+		errs = append(errs, newValidationError(prefix+".topic", "must not contain wildcards, got %q", cfg.Topic))
+	}
+
+	if cfg.Qos > 2 {
+		errs = append(errs, newValidationError(prefix+".qos", "must be 0, 1 or 2, got %d", cfg.Qos))
+	}
+
+	if cfg.Format != batch.FormatJSON && cfg.Format != batch.FormatNDJSON {
+		errs = append(errs, newValidationError(prefix+".format", "invalid format %q, valid formats: '%s', '%s'", cfg.Format, batch.FormatJSON, batch.FormatNDJSON))
+	}
+
+	switch cfg.Compression {
+	case "", batch.CompressionNone, batch.CompressionGzip, batch.CompressionZstd:
+	default:
+		errs = append(errs, newValidationError(prefix+".compression", "invalid compression %q, valid compressions: '%s', '%s', '%s'", cfg.Compression, batch.CompressionNone, batch.CompressionGzip, batch.CompressionZstd))
+	}
+
+	if cfg.MaxMessages < 0 {
+		errs = append(errs, newValidationError(prefix+".max_messages", "must not be negative, got %d", cfg.MaxMessages))
+	}
+
+	if cfg.MaxBytes < 0 {
+		errs = append(errs, newValidationError(prefix+".max_bytes", "must not be negative, got %d", cfg.MaxBytes))
+	}
+
+	if cfg.Interval <= 0 {
+		errs = append(errs, newValidationError(prefix+".interval", "must be positive, got %d", cfg.Interval))
+	}
+
+	return errs
+}
+
+func newValidationError(path, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// annotateValidationErrors adds the file name and line number to each error. The
+// last source that sets a value wins, so the sources are searched in reverse.
+func annotateValidationErrors(errs ValidationErrors, sources []configSource) {
+	for _, err := range errs {
+		err.File = filepath.Base(appConfigFilePath())
+
+		for i := len(sources) - 1; i >= 0; i-- {
+			if sources[i].root == nil {
+				continue
+			}
+
+			if line := lineForPath(sources[i].root, err.Path); line > 0 {
+				err.File = filepath.Base(sources[i].path)
+				err.Line = line
+				break
+			}
+		}
+	}
+}
+
+// yamlValidationErrors converts a yaml.v3 error into validation errors
+func yamlValidationErrors(err error, source configSource) ValidationErrors {
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	errs := make(ValidationErrors, 0, len(messages))
+	for _, message := range messages {
+		validationErr := &ValidationError{
+			File:    filepath.Base(source.path),
+			Message: message,
+		}
+
+		if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+			validationErr.Line, _ = strconv.Atoi(match[1])
+			validationErr.Message = match[2]
+			if source.root != nil {
+				validationErr.Path = pathForLine(source.root, validationErr.Line)
+			}
+		}
+
+		errs = append(errs, validationErr)
+	}
+
+	return errs
+}
+
+// parseYAMLNode parses YAML into a node tree, returning nil if it cannot be parsed
+func parseYAMLNode(data []byte) *yaml.Node {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil
+	}
+
+	return root
+}
+
+// lineForPath returns the line of the key at a dotted path, or 0 if it is not present
+func lineForPath(root *yaml.Node, path string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := 0
+	for _, key := range strings.Split(path, ".") {
+		// Sequence items are addressed by their index
+		if node.Kind == yaml.SequenceNode {
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return line
+			}
+
+			node = node.Content[index]
+			line = node.Line
+			continue
+		}
+
+		if node.Kind != yaml.MappingNode {
+			return line
+		}
+
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return line
+		}
+	}
+
+	return line
+}
+
+// pathForLine returns the dotted path of the key that starts on the given line
+func pathForLine(root *yaml.Node, line int) string {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	return findPathForLine(node, line, "")
+}
+
+func findPathForLine(node *yaml.Node, line int, prefix string) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		if key.Line == line || value.Line == line && value.Kind == yaml.ScalarNode {
+			return path
+		}
+
+		if found := findPathForLine(value, line, path); found != "" {
+			return found
+		}
+	}
+
+	return ""
+}
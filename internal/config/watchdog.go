@@ -0,0 +1,29 @@
+package config
+
+import (
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/watchdog"
+)
+
+// Engine returns the configuration of the device watchdog
+func (c WatchdogConfig) Engine() watchdog.Config {
+	interval := c.Interval
+	if interval == 0 {
+		interval = defaultWatchdogInterval
+	}
+
+	config := watchdog.Config{
+		Pattern:  c.Pattern,
+		Interval: time.Duration(interval) * time.Second,
+	}
+
+	for _, device := range c.Devices {
+		config.Overrides = append(config.Overrides, watchdog.Override{
+			Device:   device.Device,
+			Interval: time.Duration(device.Interval) * time.Second,
+		})
+	}
+
+	return config
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/aggregate"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+	"go.uber.org/zap"
+)
+
+// aggregateTickInterval is how often the aggregator closes the windows past
+// the watermark
+const aggregateTickInterval = time.Second
+
+// initAggregator creates the aggregator. It is off when no points are
+// configured.
+func (e *Engine) initAggregator(cfg config.AggregateConfig) {
+	var aggregator *aggregate.Aggregator
+
+	if len(cfg.Points) > 0 {
+		var err error
+		if aggregator, err = aggregate.New(cfg.Engine(), e.handleSummary); err != nil {
+			e.logger.Error("Failed to create the aggregator", zap.Error(err))
+			return
+		}
+	}
+
+	e.aggregatorMu.Lock()
+	previous := e.aggregator
+	e.aggregator = aggregator
+	e.aggregatorMu.Unlock()
+
+	if previous != nil {
+		e.saveAggregateCounts(previous)
+	}
+
+	if aggregator != nil {
+		e.logger.Info("Aggregator started", zap.Int("points", len(cfg.Points)), zap.Ints("windows", cfg.Windows))
+	}
+}
+
+func (e *Engine) pointAggregator() *aggregate.Aggregator {
+	e.aggregatorMu.RLock()
+	defer e.aggregatorMu.RUnlock()
+
+	return e.aggregator
+}
+
+// aggregateMessage passes a message to the aggregator. Messages on the summary
+// topic are skipped, so summaries are not aggregated again.
+func (e *Engine) aggregateMessage(topic string, payload []byte, at time.Time) {
+	aggregator := e.pointAggregator()
+	if aggregator == nil {
+		return
+	}
+
+	if summaryTopic := e.appConfig().Aggregate.SummaryTopic; summaryTopic != "" && mqttclient.TopicMatches(summaryTopic+"/#", topic) {
+		return
+	}
+
+	aggregator.HandleMessage(topic, payload, at)
+}
+
+// tickAggregator closes the windows past the watermark until the context is done
+func (e *Engine) tickAggregator(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-e.clock.After(interval):
+			if aggregator := e.pointAggregator(); aggregator != nil {
+				aggregator.Tick(now)
+			}
+		}
+	}
+}
+
+// handleSummary sends the summary of a closed window to the configured sink
+func (e *Engine) handleSummary(summary aggregate.Summary) {
+	aggregateCfg := e.appConfig().Aggregate
+	topic := aggregateCfg.SummaryTopic + "/" + summary.Point + "/" + summary.Window
+
+	e.logger.Debug("Window summarised",
+		zap.String("point", summary.Point),
+		zap.String("window", summary.Window),
+		zap.Time("start", summary.Start),
+		zap.Int64("count", summary.Count),
+	)
+
+	if aggregateCfg.Sink == config.AggregateSinkBridge {
+		payload, err := json.Marshal(summary)
+		if err != nil {
+			e.logger.Error("Failed to encode message", zap.String("topic", topic), zap.Error(err))
+			return
+		}
+
+		e.forwardMessage(pipeline.Message{Topic: topic, Payload: payload, Time: summary.End})
+		return
+	}
+
+	// Publishing waits for the broker, which must not block the tick loop
+	go e.publishJSON(topic, aggregateCfg.Qos, aggregateCfg.Retain, summary)
+}
+
+// saveAggregateCounts adds the counts of an aggregator to the state
+func (e *Engine) saveAggregateCounts(aggregator *aggregate.Aggregator) {
+	counts := aggregator.TakeCounts()
+	if counts.Summaries == 0 && counts.Late == 0 {
+		return
+	}
+
+	e.state.UpdateAggregate(func(current *state.AggregateState) {
+		current.Summaries += counts.Summaries
+		current.Late += counts.Late
+		if counts.Summaries > 0 {
+			current.LastSummaryAt = state.TimePtr(e.clock.Now())
+		}
+	})
+}
+
+// flushAggregateCounts saves the counts of the running aggregator
+func (e *Engine) flushAggregateCounts() {
+	if aggregator := e.pointAggregator(); aggregator != nil {
+		e.saveAggregateCounts(aggregator)
+	}
+}
+
+// handleAggregateConfigChange rebuilds the aggregator. The windows still open
+// are dropped.
+func (e *Engine) handleAggregateConfigChange(newAggregate config.AggregateConfig) {
+	e.logger.Info("Aggregate configuration changed. Reloading the aggregator")
+
+	e.initAggregator(newAggregate)
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/batch"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/bridge"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+	"go.uber.org/zap"
+)
+
+// bridgeRetryInterval is the wait between attempts to connect to the destination broker
+const bridgeRetryInterval = 5 * time.Second
+
+// bridgeConnection is a running bridge with its destination client
+type bridgeConnection struct {
+	bridge *bridge.Bridge
+	client MQTTClient
+	broker string
+	cancel context.CancelFunc
+}
+
+// initBridge connects to the destination broker and starts forwarding. The
+// bridge is off when it is not enabled.
+func (e *Engine) initBridge(cfg *config.AppConfig) {
+	if !cfg.Bridge.Enabled {
+		if e.state.Bridge().Status != "" {
+			e.state.UpdateBridge(func(bridge *state.BridgeState) {
+				bridge.Status = state.StatusStopped
+			})
+		}
+		return
+	}
+
+	destination := cfg.Bridge.Destination
+
+	password, err := destination.Password.Resolve()
+	if err != nil {
+		e.logger.Error("Failed to resolve the bridge password", zap.Error(err))
+		return
+	}
+
+	client := e.newClient(mqttclient.MQTTConfig{
+		Broker:                destination.Broker,
+		Port:                  destination.Port,
+		ClientID:              destination.ClientId,
+		CleanSession:          destination.CleanSession,
+		KeepAlive:             destination.KeepAlive,
+		ReconnectOnDisconnect: true,
+		Username:              destination.Username,
+		Password:              password,
+		TLS:                   newTLSConfig(destination.TLS),
+	})
+
+	forwarder, err := bridge.New(newBridgeConfig(cfg), client)
+	if err != nil {
+		e.logger.Error("Failed to create the bridge", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connection := &bridgeConnection{
+		bridge: forwarder,
+		client: client,
+		broker: fmt.Sprintf("%s:%d", destination.Broker, destination.Port),
+		cancel: cancel,
+	}
+
+	client.SetConnectionHandlers(mqttclient.ConnectionHandlers{
+		OnConnectionLost: func(err error) {
+			e.logger.Warn("Bridge connection lost", zap.String("broker", connection.broker), zap.Error(err))
+			e.setBridgeStatus(connection.broker, state.StatusDisconnected)
+		},
+		OnReconnected: func() {
+			e.setBridgeStatus(connection.broker, state.StatusConnected)
+		},
+	})
+
+	e.bridgeMu.Lock()
+	e.bridge = connection
+	e.bridgeMu.Unlock()
+
+	e.setBridgeStatus(connection.broker, state.StatusDisconnected)
+
+	go e.connectBridge(ctx, connection)
+}
+
+// connectBridge connects to the destination broker, retrying until it
+// succeeds or the bridge is stopped
+func (e *Engine) connectBridge(ctx context.Context, connection *bridgeConnection) {
+	for {
+		err := connection.client.Connect()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			e.logger.Info("Bridge connected", zap.String("broker", connection.broker))
+			e.setBridgeStatus(connection.broker, state.StatusConnected)
+			return
+		}
+
+		e.logger.Error("Error connecting the bridge to the destination broker", zap.String("broker", connection.broker), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.clock.After(bridgeRetryInterval):
+		}
+	}
+}
+
+// stopBridge publishes the last batch, disconnects from the destination
+// broker and saves the last stats
+func (e *Engine) stopBridge() {
+	e.bridgeMu.Lock()
+	connection := e.bridge
+	e.bridge = nil
+	e.bridgeMu.Unlock()
+
+	if connection == nil {
+		return
+	}
+
+	connection.cancel()
+	connection.bridge.Close()
+	connection.client.Disconnect()
+
+	e.saveBridgeStats(connection)
+	e.setBridgeStatus(connection.broker, state.StatusDisconnected)
+}
+
+func (e *Engine) bridgeConnection() *bridgeConnection {
+	e.bridgeMu.RLock()
+	defer e.bridgeMu.RUnlock()
+
+	return e.bridge
+}
+
+// forwardMessage forwards a message leaving the pipeline to the destination broker
+func (e *Engine) forwardMessage(message pipeline.Message) {
+	connection := e.bridgeConnection()
+	if connection == nil {
+		return
+	}
+
+	// Failures are counted in the bridge state, and a lost connection is
+	// already logged, so they are not logged for every message
+	if _, err := connection.bridge.Forward(message); err != nil {
+		e.logger.Debug("Failed to forward message", zap.String("topic", message.Topic), zap.Error(err))
+	}
+}
+
+// saveBridgeStats adds the bridge stats to the bridge and pipeline state
+func (e *Engine) saveBridgeStats(connection *bridgeConnection) {
+	stats := connection.bridge.TakeStats()
+	if stats.Forwarded == 0 && stats.Failed == 0 && stats.Looped == 0 && stats.Batches == 0 {
+		return
+	}
+
+	e.state.UpdateBridge(func(bridge *state.BridgeState) {
+		bridge.Forwarded += stats.Forwarded
+		bridge.Failed += stats.Failed
+		bridge.Looped += stats.Looped
+		bridge.Batches += stats.Batches
+		if !stats.LastForwardAt.IsZero() {
+			bridge.LastForwardAt = state.TimePtr(stats.LastForwardAt)
+		}
+	})
+
+	if stats.Forwarded > 0 {
+		e.state.UpdatePipeline(func(pipeline *state.PipelineState) {
+			pipeline.Published += stats.Forwarded
+		})
+	}
+}
+
+// flushBridgeStats saves the stats of the running bridge
+func (e *Engine) flushBridgeStats() {
+	if connection := e.bridgeConnection(); connection != nil {
+		e.saveBridgeStats(connection)
+	}
+}
+
+func (e *Engine) setBridgeStatus(broker, status string) {
+	e.state.UpdateBridge(func(bridge *state.BridgeState) {
+		bridge.Status = status
+		bridge.Broker = broker
+	})
+}
+
+// newBridgeConfig creates the bridge configuration from the app config. Routes
+// without a QoS use the QoS of the subscription.
+func newBridgeConfig(cfg *config.AppConfig) bridge.Config {
+	bridgeConfig := bridge.Config{
+		LoopWindow: time.Duration(cfg.Bridge.LoopWindow) * time.Second,
+	}
+
+	if batchCfg := cfg.Bridge.Batch; batchCfg.Enabled {
+		bridgeConfig.Batching = &bridge.Batching{
+			Topic: batchCfg.Topic,
+			Qos:   batchCfg.Qos,
+			Config: batch.Config{
+				Encoding:    batch.Encoding{Format: batchCfg.Format, Compression: batchCfg.Compression},
+				MaxMessages: batchCfg.MaxMessages,
+				MaxBytes:    batchCfg.MaxBytes,
+				Interval:    time.Duration(batchCfg.Interval) * time.Second,
+			},
+		}
+	}
+
+	for _, route := range cfg.Bridge.Routes {
+		qos := cfg.Mqtt.Qos
+		if route.Qos != nil {
+			qos = *route.Qos
+		}
+
+		bridgeConfig.Routes = append(bridgeConfig.Routes, bridge.Route{
+			Filter:      route.Filter,
+			StripPrefix: route.StripPrefix,
+			Prefix:      route.Prefix,
+			Qos:         qos,
+			Retain:      route.Retain,
+		})
+	}
+
+	return bridgeConfig
+}
+
+// handleBridgeConfigChange reconnects the bridge with the new configuration
+func (e *Engine) handleBridgeConfigChange(newCfg *config.Config) {
+	e.logger.Info("Bridge configuration changed. Restarting the bridge")
+
+	e.stopBridge()
+	e.initBridge(newCfg.App)
+}
@@ -1,45 +1,129 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
-	"time"
 
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
 	"go.uber.org/zap"
 )
 
 func (e *Engine) appConfigChangeCallback() {
-	oldCfg, err := config.CloneConfig(e.cfg)
+	current := *e.cfg
+	current.App = e.appConfig()
+	oldCfg, err := config.CloneConfig(&current)
 	if err != nil {
 		e.logger.Error("failed to clone config", zap.Error(err))
 		return
 	}
 
-	newCfg := config.GetConfig()
+	// Reject invalid edits and keep running with the previous configuration
+	appCfg, err := config.LoadAppConfig()
+	if err != nil {
+		e.logConfigErrors(err)
+		return
+	}
+
+	// Only the application configuration is reloaded, the paths stay as they
+	// were at start. Both sides are cloned, since the clone turns nil lists
+	// and maps into empty ones and the sections are compared with
+	// reflect.DeepEqual.
+	next := *e.cfg
+	next.App = appCfg
+	newCfg, err := config.CloneConfig(&next)
+	if err != nil {
+		e.logger.Error("failed to clone config", zap.Error(err))
+		return
+	}
 
 	e.handleAppConfigChanged(oldCfg, newCfg)
 }
 
+// logConfigErrors logs every validation error of a rejected configuration change
+func (e *Engine) logConfigErrors(err error) {
+	var validationErrs config.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		e.logger.Error("Configuration change rejected, keeping the previous configuration", zap.Error(err))
+		return
+	}
+
+	for _, validationErr := range validationErrs {
+		e.logger.Error("Invalid configuration value",
+			zap.String("file", validationErr.File),
+			zap.String("path", validationErr.Path),
+			zap.Int("line", validationErr.Line),
+			zap.String("error", validationErr.Message),
+		)
+	}
+
+	e.logger.Error("Configuration change rejected, keeping the previous configuration", zap.Int("errors", len(validationErrs)))
+}
+
 // General helper for comparing simple fields
 func (e *Engine) hasConfigFieldChanged(oldVal, newVal interface{}) bool {
 	return oldVal != newVal
 }
 
 func (e *Engine) handleAppConfigChanged(oldCfg, newCfg *config.Config) {
+	// Swap in the new configuration first, so the rebuilt parts and the
+	// message handlers read the same one
+	e.appMu.Lock()
+	e.app = newCfg.App
+	e.appMu.Unlock()
 
 	// Handle changes for the Logging config
 	if e.hasLoggingConfigChanged(oldCfg.App.Logging, newCfg.App.Logging) {
-		e.logger.Warn("Logging configuration changed, but the logger only supports changing the log level at runtime. Please restart the application to apply the changes.")
+		e.handleLoggingConfigChange(oldCfg.App.Logging, newCfg)
 	}
 
 	// Handle changes to the log level
-	if e.hasConfigFieldChanged(oldCfg.App.Logging.Level, newCfg.App.Logging.Level) {
+	if e.hasConfigFieldChanged(oldCfg.App.Logging.Level, newCfg.App.Logging.Level) ||
+		e.hasConfigFieldChanged(oldCfg.App.Logging.ForceLevel, newCfg.App.Logging.ForceLevel) {
 		e.handleLogLevelChange(oldCfg.App.Logging.Level, newCfg.App.Logging.Level)
 	}
 
-	if e.hasMQTTConfigChanged(oldCfg.App.Mqtt, newCfg.App.Mqtt) {
-		e.handleMQTTConfigChanged(oldCfg, newCfg)
+	e.handleConnectionsConfigChange(oldCfg.App, newCfg.App)
+
+	if !reflect.DeepEqual(oldCfg.App.Persist.History, newCfg.App.Persist.History) {
+		e.handlePersistHistoryChange(newCfg.App.Persist.History)
+	}
+
+	if !reflect.DeepEqual(oldCfg.App.Filter, newCfg.App.Filter) || !reflect.DeepEqual(oldCfg.App.Transform, newCfg.App.Transform) {
+		e.handlePipelineConfigChange(oldCfg.App, newCfg.App)
+	}
+
+	// Routes without a QoS follow the subscription QoS
+	if !reflect.DeepEqual(oldCfg.App.Bridge, newCfg.App.Bridge) || (newCfg.App.Bridge.Enabled && oldCfg.App.Mqtt.Qos != newCfg.App.Mqtt.Qos) {
+		e.handleBridgeConfigChange(newCfg)
+	}
+
+	// The summary topic and sink are read when a summary is sent, so only a
+	// change of the windows or points rebuilds the aggregator
+	if !reflect.DeepEqual(oldCfg.App.Aggregate.Engine(), newCfg.App.Aggregate.Engine()) {
+		e.handleAggregateConfigChange(newCfg.App.Aggregate)
+	}
+
+	if !reflect.DeepEqual(oldCfg.App.Rules, newCfg.App.Rules) {
+		e.handleRulesConfigChange(newCfg.App.Rules)
+	}
+
+	if !reflect.DeepEqual(oldCfg.App.Watchdog, newCfg.App.Watchdog) {
+		e.handleWatchdogConfigChange(newCfg.App.Watchdog)
+	}
+
+	// The persister is opened once at start up
+	if oldCfg.App.Persist.Backend != newCfg.App.Persist.Backend || oldCfg.App.Persist.FilePath != newCfg.App.Persist.FilePath {
+		e.logger.Warn("Persist configuration changed. Restart the application to apply it",
+			zap.String("old_backend", oldCfg.App.Persist.Backend),
+			zap.String("new_backend", newCfg.App.Persist.Backend),
+			zap.String("old_file_path", oldCfg.App.Persist.FilePath),
+			zap.String("new_file_path", newCfg.App.Persist.FilePath),
+		)
 	}
 }
 
@@ -52,7 +136,44 @@ func (e *Engine) hasLoggingConfigChanged(oldLogging, newLogging config.LoggingCo
 		oldLogging.MaxBackups != newLogging.MaxBackups ||
 		oldLogging.MaxAge != newLogging.MaxAge ||
 		oldLogging.Compress != newLogging.Compress ||
-		oldLogging.AddTime != newLogging.AddTime
+		oldLogging.AddTime != newLogging.AddTime ||
+		oldLogging.Sampling != newLogging.Sampling ||
+		!equalLoggerLevels(oldLogging.Loggers, newLogging.Loggers) ||
+		!reflect.DeepEqual(oldLogging.Outputs, newLogging.Outputs)
+}
+
+// equalLoggerLevels compares logger level overrides, treating nil and empty as equal
+func equalLoggerLevels(oldLevels, newLevels map[string]string) bool {
+	if len(oldLevels) == 0 && len(newLevels) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(oldLevels, newLevels)
+}
+
+// Handle changes to the log file, rotation and encoding settings by rebuilding the logger outputs
+func (e *Engine) handleLoggingConfigChange(oldLogging config.LoggingConfig, newCfg *config.Config) {
+	loggingConfig := NewLoggingConfig(newCfg)
+	loggingConfig.DebugMode = e.cfg.Flags.DebugMode
+
+	if err := logging.Reload(loggingConfig); err != nil {
+		e.logger.Error("failed to reload the logging configuration", zap.Error(err))
+		return
+	}
+
+	e.logger.Info("Logging configuration reloaded",
+		zap.String("old_file_path", oldLogging.FilePath),
+		zap.String("new_file_path", newCfg.App.Logging.FilePath),
+		zap.Int("max_size", newCfg.App.Logging.MaxSize),
+		zap.Int("max_backups", newCfg.App.Logging.MaxBackups),
+		zap.Int("max_age", newCfg.App.Logging.MaxAge),
+		zap.Bool("compress", newCfg.App.Logging.Compress),
+		zap.Bool("add_time", newCfg.App.Logging.AddTime),
+		zap.Any("loggers", newCfg.App.Logging.Loggers),
+		zap.Int("sampling_initial", newCfg.App.Logging.Sampling.Initial),
+		zap.Int("sampling_thereafter", newCfg.App.Logging.Sampling.Thereafter),
+		zap.Int("outputs", len(newCfg.App.Logging.Outputs)),
+	)
 }
 
 // Handle log level changes
@@ -63,9 +184,13 @@ func (e *Engine) handleLogLevelChange(oldLevel, newLevel string) {
 		return
 	}
 
-	// Apply the new log level if valid
-	if e.cfg.Flags.DebugMode {
-		e.logger.Warn("Debug mode is enabled. The logging level cannot be changed at runtime.")
+	// In debug mode the level is only applied when it is explicitly forced
+	if e.cfg.Flags.DebugMode && !e.appConfig().Logging.ForceLevel {
+		if err := logging.ForceLogLevel("debug"); err != nil {
+			e.logger.Error("failed to set log level", zap.Error(err))
+		}
+
+		e.logger.Warn("Debug mode is enabled. Set logging.force_level to true to change the logging level at runtime.")
 		return
 	}
 
@@ -76,7 +201,7 @@ func (e *Engine) handleLogLevelChange(oldLevel, newLevel string) {
 	}
 
 	if strings.EqualFold(newLevel, "debug") || strings.EqualFold(newLevel, "info") {
-		err := logging.SetLogLevel(newLevel)
+		err := logging.ForceLogLevel(newLevel)
 		if err != nil {
 			e.logger.Error("failed to set log level", zap.Error(err))
 			return
@@ -85,7 +210,7 @@ func (e *Engine) handleLogLevelChange(oldLevel, newLevel string) {
 		e.logger.Info("Logging level changed", zap.String("old_level", oldLevel), zap.String("new_level", newLevel))
 	} else {
 		e.logger.Info("Logging level changed", zap.String("old_level", oldLevel), zap.String("new_level", newLevel))
-		err := logging.SetLogLevel(newLevel)
+		err := logging.ForceLogLevel(newLevel)
 		if err != nil {
 			e.logger.Error("failed to set log level", zap.Error(err))
 			return
@@ -105,52 +230,114 @@ func (e *Engine) hasMQTTConfigChanged(oldMQTT, newMQTT config.MqttConfig) bool {
 		oldMQTT.KeepAlive != newMQTT.KeepAlive ||
 		oldMQTT.ReconnectOnFailure != newMQTT.ReconnectOnFailure ||
 		oldMQTT.Username != newMQTT.Username ||
-		oldMQTT.Password != newMQTT.Password
+		oldMQTT.Password != newMQTT.Password ||
+		oldMQTT.TLS != newMQTT.TLS
+}
+
+// handleConnectionsConfigChange starts the added connections, stops the
+// removed ones and restarts the ones whose settings changed. A connection
+// whose own transform changed only rebuilds its pipeline.
+func (e *Engine) handleConnectionsConfigChange(oldCfg, newCfg *config.AppConfig) {
+	oldConnections := map[string]config.ConnectionConfig{}
+	for _, connection := range oldCfg.MqttConnections() {
+		oldConnections[connection.Name] = connection
+	}
+
+	for _, connection := range newCfg.MqttConnections() {
+		old, ok := oldConnections[connection.Name]
+		delete(oldConnections, connection.Name)
+
+		switch {
+		case !ok:
+			e.logger.Info("MQTT connection added", zap.String("connection", connection.Name))
+			e.startConnection(connection, newCfg)
+		case e.hasMQTTConfigChanged(old.MqttConfig, connection.MqttConfig):
+			e.handleMQTTConfigChanged(connection.Name, old.MqttConfig, connection, newCfg)
+		case !reflect.DeepEqual(old.Transform, connection.Transform):
+			if running := e.connection(connection.Name); running != nil {
+				e.logger.Info("Transform configuration of the connection changed. Reloading its message pipeline", zap.String("connection", connection.Name))
+				e.savePipelineCounts(running)
+				running.transform = connection.Transform
+				e.initPipeline(running, newCfg)
+			}
+		}
+	}
+
+	for name := range oldConnections {
+		e.logger.Info("MQTT connection removed", zap.String("connection", name))
+		e.stopConnection(name, connections.ReasonConfigChange)
+		e.state.DeleteConnection(name)
+	}
 }
 
-func (e *Engine) handleMQTTConfigChanged(oldCfg, newCfg *config.Config) {
-	if oldCfg.App.Mqtt.Broker != newCfg.App.Mqtt.Broker {
-		e.logger.Debug("MQTT broker changed", zap.String("old_broker", oldCfg.App.Mqtt.Broker), zap.String("new_broker", newCfg.App.Mqtt.Broker))
+// handleMQTTConfigChanged logs the changed settings of a connection and restarts it
+func (e *Engine) handleMQTTConfigChanged(name string, oldMqtt config.MqttConfig, newConnection config.ConnectionConfig, appCfg *config.AppConfig) {
+	newMqtt := newConnection.MqttConfig
+
+	if oldMqtt.Broker != newMqtt.Broker {
+		e.logger.Debug("MQTT broker changed", zap.String("connection", name), zap.String("old_broker", oldMqtt.Broker), zap.String("new_broker", newMqtt.Broker))
+	}
+
+	if oldMqtt.Port != newMqtt.Port {
+		e.logger.Debug("MQTT port changed", zap.String("connection", name), zap.Int("old_port", oldMqtt.Port), zap.Int("new_port", newMqtt.Port))
 	}
 
-	if oldCfg.App.Mqtt.Port != newCfg.App.Mqtt.Port {
-		e.logger.Debug("MQTT port changed", zap.Int("old_port", oldCfg.App.Mqtt.Port), zap.Int("new_port", newCfg.App.Mqtt.Port))
+	if oldMqtt.ClientId != newMqtt.ClientId {
+		e.logger.Debug("MQTT client ID changed", zap.String("connection", name), zap.String("old_client_id", oldMqtt.ClientId), zap.String("new_client_id", newMqtt.ClientId))
 	}
 
-	if oldCfg.App.Mqtt.ClientId != newCfg.App.Mqtt.ClientId {
-		e.logger.Debug("MQTT client ID changed", zap.String("old_client_id", oldCfg.App.Mqtt.ClientId), zap.String("new_client_id", newCfg.App.Mqtt.ClientId))
+	if oldMqtt.Topic != newMqtt.Topic {
+		e.logger.Debug("MQTT topic changed", zap.String("connection", name), zap.String("old_topic", oldMqtt.Topic), zap.String("new_topic", newMqtt.Topic))
 	}
 
-	if oldCfg.App.Mqtt.Topic != newCfg.App.Mqtt.Topic {
-		e.logger.Debug("MQTT topic changed", zap.String("old_topic", oldCfg.App.Mqtt.Topic), zap.String("new_topic", newCfg.App.Mqtt.Topic))
+	if oldMqtt.Qos != newMqtt.Qos {
+		e.logger.Debug("MQTT QoS changed", zap.String("connection", name), zap.Uint8("old_qos", oldMqtt.Qos), zap.Uint8("new_qos", newMqtt.Qos))
 	}
 
-	if oldCfg.App.Mqtt.Qos != newCfg.App.Mqtt.Qos {
-		e.logger.Debug("MQTT QoS changed", zap.Uint8("old_qos", oldCfg.App.Mqtt.Qos), zap.Uint8("new_qos", newCfg.App.Mqtt.Qos))
+	if oldMqtt.CleanSession != newMqtt.CleanSession {
+		e.logger.Debug("MQTT clean session changed", zap.String("connection", name), zap.Bool("old_clean_session", oldMqtt.CleanSession), zap.Bool("new_clean_session", newMqtt.CleanSession))
 	}
 
-	if oldCfg.App.Mqtt.CleanSession != newCfg.App.Mqtt.CleanSession {
-		e.logger.Debug("MQTT clean session changed", zap.Bool("old_clean_session", oldCfg.App.Mqtt.CleanSession), zap.Bool("new_clean_session", newCfg.App.Mqtt.CleanSession))
+	if oldMqtt.KeepAlive != newMqtt.KeepAlive {
+		e.logger.Debug("MQTT keep alive changed", zap.String("connection", name), zap.Int("old_keep_alive", oldMqtt.KeepAlive), zap.Int("new_keep_alive", newMqtt.KeepAlive))
 	}
 
-	if oldCfg.App.Mqtt.KeepAlive != newCfg.App.Mqtt.KeepAlive {
-		e.logger.Debug("MQTT keep alive changed", zap.Int("old_keep_alive", oldCfg.App.Mqtt.KeepAlive), zap.Int("new_keep_alive", newCfg.App.Mqtt.KeepAlive))
+	if oldMqtt.ReconnectOnFailure != newMqtt.ReconnectOnFailure {
+		e.logger.Debug("MQTT reconnect on failure changed", zap.String("connection", name), zap.Bool("old_reconnect_on_failure", oldMqtt.ReconnectOnFailure), zap.Bool("new_reconnect_on_failure", newMqtt.ReconnectOnFailure))
 	}
 
-	if oldCfg.App.Mqtt.ReconnectOnFailure != newCfg.App.Mqtt.ReconnectOnFailure {
-		e.logger.Debug("MQTT reconnect on failure changed", zap.Bool("old_reconnect_on_failure", oldCfg.App.Mqtt.ReconnectOnFailure), zap.Bool("new_reconnect_on_failure", newCfg.App.Mqtt.ReconnectOnFailure))
+	if oldMqtt.Username != newMqtt.Username {
+		e.logger.Debug("MQTT username changed", zap.String("connection", name), zap.String("old_username", oldMqtt.Username), zap.String("new_username", newMqtt.Username))
 	}
 
-	if oldCfg.App.Mqtt.Username != newCfg.App.Mqtt.Username {
-		e.logger.Debug("MQTT username changed", zap.String("old_username", oldCfg.App.Mqtt.Username), zap.String("new_username", newCfg.App.Mqtt.Username))
+	if oldMqtt.Password != newMqtt.Password {
+		e.logger.Debug("MQTT password changed", zap.String("connection", name), zap.Stringer("old_password", oldMqtt.Password), zap.Stringer("new_password", newMqtt.Password))
 	}
 
-	if oldCfg.App.Mqtt.Password != newCfg.App.Mqtt.Password {
-		e.logger.Debug("MQTT password changed", zap.String("old_password", oldCfg.App.Mqtt.Password), zap.String("new_password", newCfg.App.Mqtt.Password))
+	if oldMqtt.Broker != newMqtt.Broker || oldMqtt.Port != newMqtt.Port {
+		e.recordConnectionEvent(connections.Event{
+			Type:           connections.EventBrokerSwitch,
+			Connection:     name,
+			Broker:         fmt.Sprintf("%s:%d", newMqtt.Broker, newMqtt.Port),
+			PreviousBroker: fmt.Sprintf("%s:%d", oldMqtt.Broker, oldMqtt.Port),
+		})
 	}
 
-	e.logger.Debug("MQTT configuration changed. Restarting MQTT connection")
-	e.client.Disconnect()
-	time.Sleep(1000 * time.Millisecond)
-	e.tryMQTTConnection(5)
+	e.logger.Debug("MQTT configuration changed. Restarting MQTT connection", zap.String("connection", name))
+	e.stopConnection(name, connections.ReasonConfigChange)
+	e.startConnection(newConnection, appCfg)
+}
+
+// ========================================= Persist =============================================================
+
+// Handle changes to the keys whose history is recorded
+func (e *Engine) handlePersistHistoryChange(history config.PersistHistoryConfig) {
+	historyPersister, ok := e.statePersister.(*persist.HistoryPersister)
+	if !ok {
+		return
+	}
+
+	historyPersister.SetTracked(history.Keys, history.Limit)
+
+	e.logger.Info("State history configuration changed", zap.Strings("keys", history.Keys), zap.Int("limit", history.Limit))
 }
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+// startFakeConnections starts the connections of a configuration and waits
+// until they are subscribed
+func startFakeConnections(t *testing.T, e *Engine, clients *fakeClients, cfg *config.AppConfig) map[string]*fakeClient {
+	t.Helper()
+
+	e.initMQTTClients(cfg)
+
+	started := map[string]*fakeClient{}
+	for _, connection := range cfg.MqttConnections() {
+		clients.waitForConnect(t)
+		eventually(t, connection.Name+" to subscribe", func() bool { return isSubscribed(e, connection.Name) })
+		started[connection.Name] = e.connection(connection.Name).mqttClient().(*fakeClient)
+	}
+
+	return started
+}
+
+func pipelines(e *Engine) map[string]*pipeline.Pipeline {
+	found := map[string]*pipeline.Pipeline{}
+	for _, connection := range e.connectionList() {
+		found[connection.name] = connection.messagePipeline()
+	}
+
+	return found
+}
+
+func assertNoConnects(t *testing.T, clients *fakeClients) {
+	t.Helper()
+
+	select {
+	case client := <-clients.connects:
+		t.Errorf("unexpected connect to %s:%d", client.config.Broker, client.config.Port)
+	default:
+	}
+}
+
+func newConnectionsAppConfig() *config.AppConfig {
+	cfg := newFakeAppConfig()
+	cfg.Connections = []config.ConnectionConfig{
+		{Name: "plant2", MqttConfig: config.MqttConfig{Broker: "plant2.local", Port: 1883, ClientId: "bms", Topic: "plant2/#"}},
+		{Name: "plant3", MqttConfig: config.MqttConfig{Broker: "plant3.local", Port: 1883, ClientId: "bms", Topic: "plant3/#"}},
+	}
+
+	return cfg
+}
+
+func TestHandleConnectionsConfigChange(t *testing.T) {
+	clients := newFakeClients()
+	oldCfg := newConnectionsAppConfig()
+	e, _, _ := newFakeEngine(t, oldCfg, clients)
+
+	started := startFakeConnections(t, e, clients, oldCfg)
+	before := pipelines(e)
+
+	newCfg := newConnectionsAppConfig()
+	newCfg.Mqtt.Port = 8883
+	newCfg.Connections = []config.ConnectionConfig{
+		{Name: "plant3", MqttConfig: oldCfg.Connections[1].MqttConfig, Transform: &config.TransformConfig{DropUnmatched: true}},
+		{Name: "plant4", MqttConfig: config.MqttConfig{Broker: "plant4.local", Port: 1883, ClientId: "bms", Topic: "plant4/#"}},
+	}
+
+	e.handleConnectionsConfigChange(oldCfg, newCfg)
+
+	for i := 0; i < 2; i++ {
+		client := clients.waitForConnect(t)
+		if client.config.Broker != "broker.local" && client.config.Broker != "plant4.local" {
+			t.Errorf("connected to %s, want only the changed and the added connection", client.config.Broker)
+		}
+	}
+	eventually(t, "the restarted connection to subscribe", func() bool { return isSubscribed(e, config.DefaultConnection) })
+	eventually(t, "the added connection to subscribe", func() bool { return isSubscribed(e, "plant4") })
+	assertNoConnects(t, clients)
+
+	if !started[config.DefaultConnection].isDisconnected() || !started["plant2"].isDisconnected() {
+		t.Error("the changed and the removed connection were not disconnected")
+	}
+	if started["plant3"].isDisconnected() {
+		t.Error("plant3 was disconnected for a change of its transform")
+	}
+
+	if e.connection("plant2") != nil {
+		t.Error("plant2 is still running")
+	}
+	if _, ok := e.state.Connections()["plant2"]; ok {
+		t.Error("the state of plant2 was not removed")
+	}
+
+	plant3 := e.connection("plant3")
+	if plant3.mqttClient() != started["plant3"] || plant3.messagePipeline() == before["plant3"] {
+		t.Error("plant3 was not kept with a rebuilt pipeline")
+	}
+
+	if broker := e.state.Connection(config.DefaultConnection).Broker; broker != "broker.local:8883" {
+		t.Errorf("broker = %s, want broker.local:8883", broker)
+	}
+
+	var switched, changed int
+	for _, event := range readEvents(t, e.cfg) {
+		switch {
+		case event.Type == connections.EventBrokerSwitch:
+			switched++
+			if event.Connection != config.DefaultConnection || event.PreviousBroker != "broker.local:1883" || event.Broker != "broker.local:8883" {
+				t.Errorf("broker switch = %+v, want broker.local:1883 to broker.local:8883", event)
+			}
+		case event.Type == connections.EventDisconnect && event.Reason == connections.ReasonConfigChange:
+			changed++
+		}
+	}
+	if switched != 1 || changed != 2 {
+		t.Errorf("recorded %d broker switches and %d disconnects for the change, want 1 and 2", switched, changed)
+	}
+}
+
+func TestHandleAppConfigChangedUnchanged(t *testing.T) {
+	clients := newFakeClients()
+	cfg := newConnectionsAppConfig()
+	e, _, _ := newFakeEngine(t, cfg, clients)
+
+	started := startFakeConnections(t, e, clients, cfg)
+	before := pipelines(e)
+
+	e.handleAppConfigChanged(e.cfg, &config.Config{App: newConnectionsAppConfig()})
+
+	assertNoConnects(t, clients)
+	for name, client := range started {
+		if client.isDisconnected() {
+			t.Errorf("%s was disconnected without a change", name)
+		}
+	}
+	for name, p := range pipelines(e) {
+		if p != before[name] {
+			t.Errorf("the pipeline of %s was rebuilt without a change", name)
+		}
+	}
+}
+
+func TestHandleAppConfigChangedFilter(t *testing.T) {
+	clients := newFakeClients()
+	cfg := newConnectionsAppConfig()
+	cfg.Connections[1].Transform = &config.TransformConfig{}
+	e, _, _ := newFakeEngine(t, cfg, clients)
+
+	startFakeConnections(t, e, clients, cfg)
+	before := pipelines(e)
+
+	newCfg := newConnectionsAppConfig()
+	newCfg.Connections[1].Transform = &config.TransformConfig{}
+	newCfg.Filter.DedupWindow = 60
+	e.handleAppConfigChanged(e.cfg, &config.Config{App: newCfg})
+
+	assertNoConnects(t, clients)
+	for name, p := range pipelines(e) {
+		if p == before[name] {
+			t.Errorf("the pipeline of %s was not rebuilt for a change of the filter", name)
+		}
+	}
+}
+
+func TestHandleAppConfigChangedSwapsConfig(t *testing.T) {
+	clients := newFakeClients()
+	cfg := newConnectionsAppConfig()
+	e, _, _ := newFakeEngine(t, cfg, clients)
+
+	startFakeConnections(t, e, clients, cfg)
+
+	newCfg := newConnectionsAppConfig()
+	newCfg.Rules.AlarmTopic = "site/alarms"
+	e.handleAppConfigChanged(&config.Config{App: cfg}, &config.Config{App: newCfg})
+
+	if e.appConfig() != newCfg {
+		t.Error("the new configuration was not swapped in")
+	}
+	if cfg.Rules.AlarmTopic == "site/alarms" {
+		t.Error("the previous configuration was changed in place")
+	}
+}
@@ -4,35 +4,91 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
-	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/aggregate"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
 	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/watchdog"
 	"go.uber.org/zap"
 )
 
-var (
-	startTime time.Time
-	endTime   time.Time
-)
+// stopFilePollInterval is how often the stop file is looked for
+const stopFilePollInterval = time.Second
 
 type Engine struct {
 	cfg            *config.Config
 	logger         *zap.Logger
-	statePersister *persist.FilePersister
-	client         *mqttclient.MQTTClient
+	statePersister persist.Persister
+	state          *state.Store
+	connectionLog  *connections.EventLog
 	stopFileChan   chan struct{}
+	startTime      time.Time
+
+	newClient ClientFactory
+	clock     Clock
+	fs        FileSystem
+
+	// appMu guards app, the application configuration in effect, which is
+	// replaced when the configuration changes
+	appMu sync.RWMutex
+	app   *config.AppConfig
+
+	// connectionsMu guards connections, which are added, removed and
+	// restarted when the configuration changes
+	connectionsMu sync.RWMutex
+	connections   map[string]*mqttConnection
+
+	// bridgeMu guards bridge, which is nil while the bridge is off
+	bridgeMu sync.RWMutex
+	bridge   *bridgeConnection
+
+	// rulesMu guards rules, which is replaced when the rules configuration changes
+	rulesMu sync.RWMutex
+	rules   *rules.Engine
+	// alarms queues the raised and cleared alarms for processAlarms
+	alarms chan *rules.Alarm
+
+	// aggregatorMu guards aggregator, which is nil while no points are aggregated
+	aggregatorMu sync.RWMutex
+	aggregator   *aggregate.Aggregator
+
+	// watchdogMu guards watchdog, which is nil while the watchdog is off
+	watchdogMu sync.RWMutex
+	watchdog   *watchdog.Watchdog
 }
 
-func NewEngine(cfg *config.Config, logger *zap.Logger, statePersister *persist.FilePersister) *Engine {
-	return &Engine{
-		cfg:            cfg,
-		logger:         logger,
-		statePersister: statePersister,
-		stopFileChan:   make(chan struct{}), // Initialize stop file channel
+// NewEngine creates an engine. The options replace the MQTT clients, clock,
+// file system and persister, which default to the real ones and a state kept
+// in memory.
+func NewEngine(cfg *config.Config, logger *zap.Logger, options ...Option) *Engine {
+	e := &Engine{
+		cfg:           cfg,
+		app:           cfg.App,
+		logger:        logger,
+		connections:   make(map[string]*mqttConnection),
+		connectionLog: connections.NewEventLog(cfg.ConnectionsFilePath),
+		stopFileChan:  make(chan struct{}), // Initialize stop file channel
+		alarms:        make(chan *rules.Alarm, alarmQueueSize),
+		newClient:     newMQTTClient,
+		clock:         systemClock{},
+		fs:            osFileSystem{},
+	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	if e.statePersister == nil {
+		e.statePersister = persist.NewMemoryPersister()
 	}
+	e.state = state.NewStore(e.statePersister)
+
+	return e
 }
 
 func (e *Engine) Run(ctx context.Context) {
@@ -41,39 +97,57 @@ func (e *Engine) Run(ctx context.Context) {
 	e.logger.Info("Starting application")
 
 	// Create tmp directory
-	tmpDirPath := "./tmp"
-	if err := os.MkdirAll(tmpDirPath, os.ModePerm); err != nil {
+	tmpDirPath := e.cfg.TmpDirPath
+	if err := e.fs.MkdirAll(tmpDirPath, os.ModePerm); err != nil {
 		e.logger.Fatal("Failed to create tmp directory", zap.String("directory", tmpDirPath), zap.Error(err))
 	} else {
 		e.logger.Info("Tmp directory created", zap.String("directory", tmpDirPath))
 	}
 
-	startTime = time.Now()
+	e.startTime = e.clock.Now()
+
+	if from, err := state.Migrate(e.statePersister); err != nil {
+		e.logger.Error("Failed to migrate the state", zap.Error(err))
+	} else if from != state.SchemaVersion {
+		e.logger.Info("State migrated", zap.Int("from_version", from), zap.Int("to_version", state.SchemaVersion))
+	}
 
-	e.statePersister.Set("app", map[string]interface{}{})
-	e.statePersister.Set("app.status", "running")
-	e.statePersister.Set("app.name", e.cfg.System.AppName)
-	e.statePersister.Set("app.version", fmt.Sprintf("%s-%d", e.cfg.System.AppVersion, e.cfg.System.BuildNumber))
-	e.statePersister.Set("app.environment", e.cfg.Flags.Environment)
-	e.statePersister.Set("app.start_time", startTime.Format(time.RFC3339))
+	e.state.SetApp(state.AppState{
+		Status:      state.StatusRunning,
+		Name:        e.cfg.System.AppName,
+		Version:     fmt.Sprintf("%s-%d", e.cfg.System.AppVersion, e.cfg.System.BuildNumber),
+		Environment: e.cfg.Flags.Environment,
+		ConfigFiles: config.AppConfigFiles(),
+		StartTime:   state.TimePtr(e.startTime),
+	})
 
-	e.WriteToLogFile("./connections/connections.log", fmt.Sprintf("%s: App started\n", startTime.Format(time.RFC3339)))
+	e.recordConnectionEvent(connections.Event{Time: e.startTime, Type: connections.EventAppStart})
 
-	e.start()
+	e.start(ctx)
 
 	// Main Engine logic
 	<-ctx.Done()
 }
 
-func (e *Engine) start() {
+func (e *Engine) start(ctx context.Context) {
 	go config.WatchAppConfigFileWithPolling(e.appConfigChangeCallback, 50*time.Millisecond, 50*time.Millisecond)
 
-	stopFilePath := "./tmp/stop_signal"
-	e.WatchStopFile(stopFilePath)
+	e.WatchStopFile(ctx, e.cfg.StopFilePath)
+
+	go e.processAlarms(ctx)
+
+	appCfg := e.appConfig()
+	e.initRules(appCfg.Rules)
+	e.initWatchdog(appCfg.Watchdog)
+	e.initBridge(appCfg)
+	e.initAggregator(appCfg.Aggregate)
 
-	e.initMQTTClient()
+	go e.persistMessageStats(ctx, messageStatsInterval)
+	go e.tickRules(ctx, rulesTickInterval)
+	go e.checkDevices(ctx, watchdogCheckInterval)
+	go e.tickAggregator(ctx, aggregateTickInterval)
 
-	go func() { e.tryMQTTConnection(5) }()
+	e.initMQTTClients(appCfg)
 }
 
 func (e *Engine) Cleanup() {
@@ -81,14 +155,16 @@ func (e *Engine) Cleanup() {
 	e.logger.Debug("Cleaning up")
 	defer e.logger.Debug("Cleanup complete")
 
-	// Disconnect MQTT client and set status to disconnected
-	e.client.Disconnect()
-	e.mqttStatePersistStop()
+	// Disconnect the MQTT clients and set their status to disconnected
+	e.stopConnections(connections.ReasonShutdown)
+	e.stopBridge()
+	e.flushMessageStats()
+	e.saveDevices()
 
 	// Delete the `tmp` directory if it exists
-	tmpDir := "./tmp"
-	if _, err := os.Stat(tmpDir); err == nil {
-		err := os.RemoveAll(tmpDir)
+	tmpDir := e.cfg.TmpDirPath
+	if _, err := e.fs.Stat(tmpDir); err == nil {
+		err := e.fs.RemoveAll(tmpDir)
 		if err != nil {
 			e.logger.Error("Failed to delete tmp directory", zap.String("directory", tmpDir), zap.Error(err))
 		} else {
@@ -102,62 +178,62 @@ func (e *Engine) Cleanup() {
 }
 
 func (e *Engine) Stop() {
-	endTime = time.Now()
+	endTime := e.clock.Now()
 
-	duration := endTime.Sub(startTime)
+	duration := endTime.Sub(e.startTime)
 
 	e.logger.Info("Stopping application")
 
-	e.WriteToLogFile("./connections/connections.log", fmt.Sprintf("%s: App stopped\n", endTime.Format(time.RFC3339)))
+	e.recordConnectionEvent(connections.Event{Time: endTime, Type: connections.EventAppStop})
 
-	e.statePersister.Set("app.status", "stopped")
-	e.statePersister.Set("app.end_time", endTime.Format(time.RFC3339))
-	e.statePersister.Set("app.duration", duration.String())
+	e.state.UpdateApp(func(app *state.AppState) {
+		app.Status = state.StatusStopped
+		app.EndTime = state.TimePtr(endTime)
+		app.Duration = state.Duration(duration)
+	})
 
 }
 
-func (e *Engine) WatchStopFile(stopFilePath string) {
+// WatchStopFile signals StopFileDetected once the stop file exists. It stops
+// watching when the context is done.
+func (e *Engine) WatchStopFile(ctx context.Context, stopFilePath string) {
 	go func() {
-		ticker := time.NewTicker(1 * time.Second) // Polling interval
-		defer ticker.Stop()
-
 		for {
+			if _, err := e.fs.Stat(stopFilePath); err == nil {
+				close(e.stopFileChan) // Signal stop file detection
+				return
+			}
+
 			select {
-			case <-e.stopFileChan: // Stop watching if channel is closed
+			case <-ctx.Done():
 				return
-			default:
-				if _, err := os.Stat(stopFilePath); err == nil {
-					close(e.stopFileChan) // Signal stop file detection
-					return
-				}
-				time.Sleep(1 * time.Second)
+			case <-e.clock.After(stopFilePollInterval):
 			}
 		}
 	}()
 }
 
+// appConfig returns the application configuration in effect. A change
+// replaces it instead of changing it, so it can be read without a lock.
+func (e *Engine) appConfig() *config.AppConfig {
+	e.appMu.RLock()
+	defer e.appMu.RUnlock()
+
+	return e.app
+}
+
 func (e *Engine) StopFileDetected() <-chan struct{} {
 	return e.stopFileChan
 }
 
-func (e *Engine) WriteToLogFile(path string, message string) {
-	// Create the directory structure if it doesn't exist
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		e.logger.Error("Failed to create directory for log file", zap.String("directory", dir), zap.Error(err))
-		return
-	}
-
-	// Open the file for appending or create it if it doesn't exist
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		e.logger.Error("Failed to open log file", zap.String("file", path), zap.Error(err))
-		return
+// recordConnectionEvent appends an event to the connection history. The time
+// is set to now if it is zero.
+func (e *Engine) recordConnectionEvent(event connections.Event) {
+	if event.Time.IsZero() {
+		event.Time = e.clock.Now()
 	}
-	defer file.Close()
 
-	// Write the message to the file
-	if _, err := file.WriteString(message); err != nil {
-		e.logger.Error("Failed to write to log file", zap.String("file", path), zap.Error(err))
+	if err := e.connectionLog.Record(event); err != nil {
+		e.logger.Error("Failed to record connection event", zap.String("type", event.Type), zap.String("file", e.cfg.ConnectionsFilePath), zap.Error(err))
 	}
 }
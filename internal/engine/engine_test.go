@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt/mqtttest"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logging.NewLogger(&logging.LoggingConfig{
+		Level:   "error",
+		Outputs: []logging.OutputConfig{{Type: "stdout", Format: "console"}},
+	})
+
+	os.Exit(m.Run())
+}
+
+// newTestConfig returns a configuration with the default connection on a broker
+func newTestConfig(t *testing.T, broker *mqtttest.Broker) *config.Config {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	return &config.Config{
+		ConnectionsFilePath: filepath.Join(dir, "connections.log"),
+		TmpDirPath:          filepath.Join(dir, "tmp"),
+		StopFilePath:        filepath.Join(dir, "stop"),
+		Flags:               &config.FlagsConfig{},
+		System:              &config.SystemConfig{},
+		App:                 newTestAppConfig(broker),
+	}
+}
+
+func newTestAppConfig(broker *mqtttest.Broker) *config.AppConfig {
+	return &config.AppConfig{
+		Mqtt: config.MqttConfig{
+			Broker:             broker.Host(),
+			Port:               broker.Port(),
+			ClientId:           "engine-test",
+			Topic:              "site/#",
+			Qos:                1,
+			CleanSession:       true,
+			KeepAlive:          30,
+			ReconnectOnFailure: true,
+		},
+	}
+}
+
+func newTestEngine(cfg *config.Config) *Engine {
+	return NewEngine(cfg, zap.NewNop())
+}
+
+// eventually fails the test if the condition does not hold in time
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(mqtttest.WaitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readEvents returns the events of the connection log
+func readEvents(t *testing.T, cfg *config.Config) []connections.Event {
+	t.Helper()
+
+	events, err := connections.ReadEvents(cfg.ConnectionsFilePath)
+	if err != nil {
+		t.Fatalf("failed to read the connection log: %v", err)
+	}
+
+	return events
+}
+
+func hasEvent(events []connections.Event, eventType, reason string) bool {
+	for _, event := range events {
+		if event.Type == eventType && (reason == "" || event.Reason == reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSubscribed(e *Engine, name string) bool {
+	current := e.state.Connection(name)
+	return current.Status == state.StatusConnected &&
+		len(current.Subscriptions) == 1 &&
+		current.Subscriptions[0].Status == state.StatusSubscribed
+}
+
+func TestConnectAndSubscribe(t *testing.T) {
+	broker := mqtttest.New(t)
+	cfg := newTestConfig(t, broker)
+	e := newTestEngine(cfg)
+	defer e.stopConnections(connections.ReasonShutdown)
+
+	e.initMQTTClients(cfg.App)
+
+	broker.WaitForSubscription(t, "site/#")
+	eventually(t, "the subscription state", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	broker.Publish("site/ahu1/temp", []byte(`{"value":21.5}`), false)
+
+	eventually(t, "the message to be counted", func() bool {
+		e.flushMessageStats()
+		return e.state.Pipeline().Received == 1
+	})
+
+	subscription := e.state.Connection(config.DefaultConnection).Subscriptions[0]
+	if subscription.Topic != "site/#" || subscription.MessagesReceived != 1 {
+		t.Errorf("subscription = %s with %d messages, want site/# with 1", subscription.Topic, subscription.MessagesReceived)
+	}
+
+	if !hasEvent(readEvents(t, cfg), connections.EventConnect, "") {
+		t.Error("no connect event recorded")
+	}
+}
+
+func TestReconnectAfterBrokerRestart(t *testing.T) {
+	broker := mqtttest.New(t)
+	cfg := newTestConfig(t, broker)
+	e := newTestEngine(cfg)
+	defer e.stopConnections(connections.ReasonShutdown)
+
+	e.initMQTTClients(cfg.App)
+	broker.WaitForSubscription(t, "site/#")
+	eventually(t, "the subscription state", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	broker.Restart(t)
+
+	broker.WaitForSubscription(t, "site/#")
+	eventually(t, "the subscription state after reconnecting", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	if !hasEvent(readEvents(t, cfg), connections.EventDisconnect, "") {
+		t.Error("no disconnect event recorded for the lost connection")
+	}
+
+	broker.Publish("site/ahu1/temp", []byte("21.5"), false)
+	eventually(t, "a message after reconnecting", func() bool {
+		e.flushMessageStats()
+		return e.state.Pipeline().Received == 1
+	})
+}
+
+func TestConnectBadCredentials(t *testing.T) {
+	broker := mqtttest.New(t, mqtttest.WithCredentials("bms", "secret"))
+
+	tests := []struct {
+		name     string
+		username string
+		password config.Secret
+		want     string
+	}{
+		{"no username", "", "secret", "bad MQTT credentials detected: No username provided"},
+		{"no password", "bms", "", "bad MQTT credentials detected: No password provided"},
+		{"wrong password", "bms", "wrong", "bad MQTT credentials detected: error connecting to MQTT broker: bad user name or password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, broker)
+			cfg.App.Mqtt.Username = tt.username
+			cfg.App.Mqtt.Password = tt.password
+			e := newTestEngine(cfg)
+
+			err := e.connectMQTTClient(newTestConnection(config.DefaultConnection, cfg.App.Mqtt))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("connectMQTTClient() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	cfg := newTestConfig(t, broker)
+	cfg.App.Mqtt.Username = "bms"
+	cfg.App.Mqtt.Password = "secret"
+	e := newTestEngine(cfg)
+	defer e.stopConnections(connections.ReasonShutdown)
+
+	e.initMQTTClients(cfg.App)
+	broker.WaitForSubscription(t, "site/#")
+}
+
+func TestHotReloadMqttConfig(t *testing.T) {
+	oldBroker := mqtttest.New(t)
+	newBroker := mqtttest.New(t)
+
+	oldCfg := newTestConfig(t, oldBroker)
+	newCfg := *oldCfg
+	newCfg.App = newTestAppConfig(newBroker)
+	newCfg.App.Mqtt.Topic = "site/ahu1/#"
+
+	e := newTestEngine(oldCfg)
+	defer e.stopConnections(connections.ReasonShutdown)
+
+	e.initMQTTClients(oldCfg.App)
+	oldBroker.WaitForSubscription(t, "site/#")
+
+	e.handleAppConfigChanged(oldCfg, &newCfg)
+
+	newBroker.WaitForSubscription(t, "site/ahu1/#")
+	oldBroker.WaitForClients(t, 0)
+	eventually(t, "the subscription state on the new broker", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	current := e.state.Connection(config.DefaultConnection)
+	if want := fmt.Sprintf("%s:%d", newBroker.Host(), newBroker.Port()); current.Broker != want {
+		t.Errorf("broker = %s, want %s", current.Broker, want)
+	}
+	if current.Subscriptions[0].Topic != "site/ahu1/#" {
+		t.Errorf("subscription = %s, want site/ahu1/#", current.Subscriptions[0].Topic)
+	}
+
+	events := readEvents(t, oldCfg)
+	if !hasEvent(events, connections.EventBrokerSwitch, "") {
+		t.Error("no broker switch event recorded")
+	}
+	if !hasEvent(events, connections.EventDisconnect, connections.ReasonConfigChange) {
+		t.Error("no disconnect event recorded for the configuration change")
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	broker := mqtttest.New(t)
+	cfg := newTestConfig(t, broker)
+	e := newTestEngine(cfg)
+
+	if err := os.MkdirAll(cfg.TmpDirPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	e.initMQTTClients(cfg.App)
+	broker.WaitForSubscription(t, "site/#")
+	eventually(t, "the subscription state", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	connection := e.connection(config.DefaultConnection)
+	broker.Publish("site/ahu1/temp", []byte("21.5"), false)
+	eventually(t, "the message to be received", func() bool {
+		connection.stats.mu.Lock()
+		defer connection.stats.mu.Unlock()
+		return connection.stats.counts["site/ahu1/temp"] == 1
+	})
+
+	e.Cleanup()
+
+	broker.WaitForClients(t, 0)
+
+	if len(e.connectionList()) != 0 {
+		t.Errorf("%d connections left after cleanup, want 0", len(e.connectionList()))
+	}
+
+	current := e.state.Connection(config.DefaultConnection)
+	if current.Status != state.StatusDisconnected || current.LastDisconnectReason != connections.ReasonShutdown {
+		t.Errorf("connection is %s with reason %q, want %s with reason %q", current.Status, current.LastDisconnectReason, state.StatusDisconnected, connections.ReasonShutdown)
+	}
+
+	if received := e.state.Pipeline().Received; received != 1 {
+		t.Errorf("received = %d after cleanup, want 1", received)
+	}
+
+	if !hasEvent(readEvents(t, cfg), connections.EventDisconnect, connections.ReasonShutdown) {
+		t.Error("no shutdown disconnect event recorded")
+	}
+
+	if _, err := os.Stat(cfg.TmpDirPath); !os.IsNotExist(err) {
+		t.Errorf("tmp directory still exists after cleanup: %v", err)
+	}
+}
+
+func TestHandleMqttConnectionError(t *testing.T) {
+	e := &Engine{logger: zap.NewNop()}
+
+	err := e.handleMqttConnectionError(fmt.Errorf("network unreachable"), "bms", "secret")
+	if !strings.HasPrefix(err.Error(), "error connecting to MQTT broker") {
+		t.Errorf("handleMqttConnectionError() = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"go.uber.org/zap"
+)
+
+// fakeClock is a clock whose time only moves when the test advances it
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	// waits are the durations passed to After, in order
+	waits []time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	c.waits = append(c.waits, d)
+
+	return ch
+}
+
+// Advance moves the time on and fires the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// waitForWaits waits until After was called n times in total
+func (c *fakeClock) waitForWaits(t *testing.T, n int) []time.Duration {
+	t.Helper()
+
+	var waits []time.Duration
+	eventually(t, fmt.Sprintf("%d waits on the clock", n), func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		waits = append([]time.Duration(nil), c.waits...)
+		return len(waits) >= n
+	})
+
+	return waits
+}
+
+// fakeClient is an MQTT client that connects with the results queued on its factory
+type fakeClient struct {
+	factory *fakeClients
+	config  mqttclient.MQTTConfig
+
+	mu           sync.Mutex
+	connected    bool
+	subscribed   bool
+	disconnected bool
+	handlers     mqttclient.ConnectionHandlers
+	messages     func(topic string, payload []byte)
+	published    []string
+}
+
+func (c *fakeClient) Connect() error {
+	err := c.factory.nextResult()
+
+	c.mu.Lock()
+	c.connected = err == nil
+	c.mu.Unlock()
+
+	c.factory.connects <- c
+	return err
+}
+
+func (c *fakeClient) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connected = false
+	c.disconnected = true
+}
+
+func (c *fakeClient) Subscribe() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return fmt.Errorf("client is not connected")
+	}
+
+	c.subscribed = true
+	return nil
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return fmt.Errorf("client is not connected")
+	}
+
+	c.published = append(c.published, topic)
+	return nil
+}
+
+func (c *fakeClient) SetConnectionHandlers(handlers mqttclient.ConnectionHandlers) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers = handlers
+}
+
+func (c *fakeClient) SetMessageHandler(handler func(topic string, payload []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = handler
+}
+
+func (c *fakeClient) ClientID() string {
+	return c.config.ClientID + "-fake"
+}
+
+func (c *fakeClient) connectionHandlers() mqttclient.ConnectionHandlers {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.handlers
+}
+
+func (c *fakeClient) isDisconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.disconnected
+}
+
+// fakeClients creates fake clients and queues the results of their connect attempts
+type fakeClients struct {
+	mu      sync.Mutex
+	results []error
+	// connects receives every client that attempted to connect
+	connects chan *fakeClient
+}
+
+func newFakeClients(results ...error) *fakeClients {
+	return &fakeClients{results: results, connects: make(chan *fakeClient, 100)}
+}
+
+func (f *fakeClients) new(config mqttclient.MQTTConfig) MQTTClient {
+	return &fakeClient{factory: f, config: config}
+}
+
+// nextResult returns the next queued result, success once the queue is empty
+func (f *fakeClients) nextResult() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.results) == 0 {
+		return nil
+	}
+
+	err := f.results[0]
+	f.results = f.results[1:]
+	return err
+}
+
+// waitForConnect returns the next client that attempted to connect
+func (f *fakeClients) waitForConnect(t *testing.T) *fakeClient {
+	t.Helper()
+
+	select {
+	case client := <-f.connects:
+		return client
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a connect attempt")
+		return nil
+	}
+}
+
+// fakeFileSystem is a file system that only knows which paths exist
+type fakeFileSystem struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newFakeFileSystem() *fakeFileSystem {
+	return &fakeFileSystem{paths: make(map[string]bool)}
+}
+
+func (f *fakeFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.paths[path] = true
+	return nil
+}
+
+func (f *fakeFileSystem) Stat(path string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.paths[path] {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeFileSystem) RemoveAll(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.paths, path)
+	return nil
+}
+
+func (f *fakeFileSystem) exists(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.paths[path]
+}
+
+// newFakeEngine returns an engine with fake clients, clock and file system
+func newFakeEngine(t *testing.T, app *config.AppConfig, clients *fakeClients) (*Engine, *fakeClock, *fakeFileSystem) {
+	t.Helper()
+
+	clock := newFakeClock()
+	fileSystem := newFakeFileSystem()
+
+	cfg := &config.Config{
+		ConnectionsFilePath: t.TempDir() + "/connections.log",
+		TmpDirPath:          "/bms/tmp",
+		StopFilePath:        "/bms/stop",
+		Flags:               &config.FlagsConfig{},
+		System:              &config.SystemConfig{},
+		App:                 app,
+	}
+
+	e := NewEngine(cfg, zap.NewNop(),
+		WithClientFactory(clients.new),
+		WithClock(clock),
+		WithFileSystem(fileSystem),
+	)
+
+	return e, clock, fileSystem
+}
+
+func newFakeAppConfig() *config.AppConfig {
+	return &config.AppConfig{
+		Mqtt: config.MqttConfig{
+			Broker:   "broker.local",
+			Port:     1883,
+			ClientId: "bms",
+			Topic:    "site/#",
+			Qos:      1,
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
+)
+
+// NewLoggingConfig creates the logger configuration from the app config
+func NewLoggingConfig(cfg *config.Config) *logging.LoggingConfig {
+	loggingConfig := logging.NewLoggingConfig(
+		cfg.App.Logging.Level,
+		config.ResolvePath(cfg.App.Logging.FilePath),
+		cfg.App.Logging.MaxSize,
+		cfg.App.Logging.MaxBackups,
+		cfg.App.Logging.MaxAge,
+		cfg.App.Logging.Compress,
+		cfg.Flags.DebugMode,
+		cfg.App.Logging.AddTime,
+		cfg.App.Logging.ForceLevel,
+	)
+
+	loggingConfig.Loggers = cfg.App.Logging.Loggers
+	loggingConfig.Sampling = logging.SamplingConfig{
+		Initial:    cfg.App.Logging.Sampling.Initial,
+		Thereafter: cfg.App.Logging.Sampling.Thereafter,
+		Tick:       time.Duration(cfg.App.Logging.Sampling.Tick) * time.Second,
+	}
+
+	for _, output := range cfg.App.Logging.Outputs {
+		loggingConfig.Outputs = append(loggingConfig.Outputs, logging.OutputConfig{
+			Type:          output.Type,
+			Format:        output.Format,
+			Level:         output.Level,
+			Path:          config.ResolvePath(output.Path),
+			Network:       output.Network,
+			Address:       output.Address,
+			Tag:           output.Tag,
+			URL:           output.URL,
+			Labels:        output.Labels,
+			BatchSize:     output.BatchSize,
+			FlushInterval: time.Duration(output.FlushInterval) * time.Second,
+		})
+	}
+
+	return loggingConfig
+}
@@ -1,75 +1,246 @@
 package engine
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/filter"
 	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
 	"go.uber.org/zap"
 )
 
-var (
-	mqttStartTime time.Time
-	mqttEndTime   time.Time
-)
+// mqttConnection is a connection to a broker with its own client, connect
+// loop, message pipeline and state under mqtt.<name>
+type mqttConnection struct {
+	name   string
+	config config.MqttConfig
+	// transform replaces the transform section for this connection when set
+	transform *config.TransformConfig
+	stats     *messageStats
+
+	// ctx is cancelled when the connection is stopped, which ends its connect loop
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards the client, which is replaced on every connect attempt, and
+	// the time the connection was made
+	mu        sync.Mutex
+	client    MQTTClient
+	startTime time.Time
+
+	// pipelineMu guards pipeline and its filter stage, which are rebuilt when
+	// the stages change
+	pipelineMu sync.RWMutex
+	pipeline   *pipeline.Pipeline
+	filter     *filter.Filter
+}
+
+func (c *mqttConnection) mqttClient() MQTTClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client
+}
+
+// broker returns the host and port of the broker
+func (c *mqttConnection) broker() string {
+	return fmt.Sprintf("%s:%d", c.config.Broker, c.config.Port)
+}
+
+// initMQTTClients starts a connection for the mqtt section and each named
+// connection, and removes the state of connections that no longer exist
+func (e *Engine) initMQTTClients(cfg *config.AppConfig) {
+	configured := map[string]bool{}
+	for _, connection := range cfg.MqttConnections() {
+		configured[connection.Name] = true
+		e.startConnection(connection, cfg)
+	}
+
+	for name := range e.state.Connections() {
+		if !configured[name] {
+			e.state.DeleteConnection(name)
+		}
+	}
+}
 
-func (e *Engine) initMQTTClient() {
-	e.logger.Info("Initializing MQTT client")
+// startConnection adds a connection and connects it in the background
+func (e *Engine) startConnection(cfg config.ConnectionConfig, appCfg *config.AppConfig) {
+	e.logger.Info("Initializing MQTT client", zap.String("connection", cfg.Name))
 
-	e.mqttStatePersistStop()
+	ctx, cancel := context.WithCancel(context.Background())
+	connection := &mqttConnection{
+		name:      cfg.Name,
+		config:    cfg.MqttConfig,
+		transform: cfg.Transform,
+		stats:     &messageStats{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	e.initPipeline(connection, appCfg)
+
+	e.connectionsMu.Lock()
+	e.connections[connection.name] = connection
+	e.connectionsMu.Unlock()
+
+	e.mqttStatePersistStop(connection, "")
+
+	go e.tryMQTTConnection(connection, 5)
 }
 
-func (e *Engine) connectMQTTClient() error {
+// stopConnection disconnects and removes a connection, recording the reason
+func (e *Engine) stopConnection(name, reason string) {
+	e.connectionsMu.Lock()
+	connection := e.connections[name]
+	delete(e.connections, name)
+	e.connectionsMu.Unlock()
+
+	if connection == nil {
+		return
+	}
+
+	connection.cancel()
+	if client := connection.mqttClient(); client != nil {
+		client.Disconnect()
+	}
+
+	e.mqttStatePersistStop(connection, reason)
+	e.flushConnectionStats(connection)
+}
+
+// stopConnections disconnects every connection
+func (e *Engine) stopConnections(reason string) {
+	for _, connection := range e.connectionList() {
+		e.stopConnection(connection.name, reason)
+	}
+}
+
+func (e *Engine) connection(name string) *mqttConnection {
+	e.connectionsMu.RLock()
+	defer e.connectionsMu.RUnlock()
+
+	return e.connections[name]
+}
+
+// connectionList returns the running connections
+func (e *Engine) connectionList() []*mqttConnection {
+	e.connectionsMu.RLock()
+	defer e.connectionsMu.RUnlock()
+
+	list := make([]*mqttConnection, 0, len(e.connections))
+	for _, connection := range e.connections {
+		list = append(list, connection)
+	}
+
+	return list
+}
+
+func (e *Engine) connectMQTTClient(connection *mqttConnection) error {
+	password, err := connection.config.Password.Resolve()
+	if err != nil {
+		return fmt.Errorf("failed to resolve MQTT password: %w", err)
+	}
+
 	config := mqttclient.MQTTConfig{
-		Broker:                e.cfg.App.Mqtt.Broker,
-		Port:                  e.cfg.App.Mqtt.Port,
-		ClientID:              e.cfg.App.Mqtt.ClientId,
-		Topic:                 e.cfg.App.Mqtt.Topic,
-		Qos:                   e.cfg.App.Mqtt.Qos,
-		CleanSession:          e.cfg.App.Mqtt.CleanSession,
-		KeepAlive:             e.cfg.App.Mqtt.KeepAlive,
-		ReconnectOnDisconnect: e.cfg.App.Mqtt.ReconnectOnFailure,
-		Username:              e.cfg.App.Mqtt.Username,
-		Password:              e.cfg.App.Mqtt.Password,
-	}
-
-	e.client = mqttclient.NewMQTTClient(config)
-	if err := e.client.Connect(); err != nil {
+		Broker:                connection.config.Broker,
+		Port:                  connection.config.Port,
+		ClientID:              connection.config.ClientId,
+		Topic:                 connection.config.Topic,
+		Qos:                   connection.config.Qos,
+		CleanSession:          connection.config.CleanSession,
+		KeepAlive:             connection.config.KeepAlive,
+		ReconnectOnDisconnect: connection.config.ReconnectOnFailure,
+		Username:              connection.config.Username,
+		Password:              password,
+		TLS:                   newTLSConfig(connection.config.TLS),
+	}
+
+	client := e.newClient(config)
+	client.SetConnectionHandlers(mqttclient.ConnectionHandlers{
+		OnConnectionLost: func(err error) {
+			e.mqttStatePersistStop(connection, err.Error())
+		},
+		OnReconnectAttempt: func(attempt int, err error) {
+			e.recordConnectionEvent(connections.Event{
+				Type:       connections.EventReconnectAttempt,
+				Connection: connection.name,
+				Broker:     connection.broker(),
+				Attempt:    attempt,
+				Reason:     err.Error(),
+			})
+		},
+		OnReconnected: func() {
+			e.mqttStatePersistStart(connection)
+			e.subscribe(connection)
+		},
+	})
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		e.handleMessage(connection, topic, payload)
+	})
+
+	connection.mu.Lock()
+	connection.client = client
+	connection.mu.Unlock()
+
+	if err := client.Connect(); err != nil {
 		return e.handleMqttConnectionError(err, config.Username, config.Password)
 	}
 
 	return nil
 }
 
-func (e *Engine) tryMQTTConnection(retryInterval int) {
-	e.logger.Info("Attempting to connect to MQTT broker")
+// tryMQTTConnection connects a connection, retrying until it succeeds or the
+// connection is stopped
+func (e *Engine) tryMQTTConnection(connection *mqttConnection, retryInterval int) {
+	e.logger.Info("Attempting to connect to MQTT broker", zap.String("connection", connection.name))
 
 	if retryInterval > 60 {
 		e.logger.Warn("Exceeded maximum retry interval of 60 seconds. Resetting to 60 seconds")
 		retryInterval = 60
 	}
 
-	for {
-		if e.client != nil && e.client.Client.IsConnected() {
-			e.client.Disconnect()
-		}
+	for attempt := 1; ; attempt++ {
+		err := e.connectMQTTClient(connection)
 
-		if e.statePersister.Get("mqtt.status") == "connected" {
-			e.mqttStatePersistStop()
+		// Stopped while connecting, the client must not stay connected
+		if connection.ctx.Err() != nil {
+			if client := connection.mqttClient(); client != nil {
+				client.Disconnect()
+			}
+			return
 		}
 
-		if err := e.connectMQTTClient(); err != nil {
-			e.logger.Error("Error connecting to MQTT broker", zap.Error(err))
-			e.logger.Info("Retrying in 5 seconds")
-			time.Sleep(5 * time.Second)
-			continue
+		if err == nil {
+			break
 		}
 
-		e.client.Subscribe()
-		e.mqttStatePersistStart()
-		break
+		e.logger.Error("Error connecting to MQTT broker", zap.String("connection", connection.name), zap.Error(err))
+		e.recordConnectionEvent(connections.Event{
+			Type:       connections.EventReconnectAttempt,
+			Connection: connection.name,
+			Broker:     connection.broker(),
+			Attempt:    attempt,
+			Reason:     err.Error(),
+		})
+		e.logger.Info(fmt.Sprintf("Retrying in %d seconds", retryInterval), zap.String("connection", connection.name))
+
+		select {
+		case <-connection.ctx.Done():
+			return
+		case <-e.clock.After(time.Duration(retryInterval) * time.Second):
+		}
 	}
+
+	e.mqttStatePersistStart(connection)
+	e.subscribe(connection)
 }
 
 // Handle MQTT connection error
@@ -86,31 +257,156 @@ func (e *Engine) handleMqttConnectionError(err error, username, password string)
 	return fmt.Errorf("error connecting to MQTT broker: %w", err)
 }
 
-// mqttStatePersistStart persists the state of the MQTT connection
-func (e *Engine) mqttStatePersistStart() {
-	mqttStartTime = time.Now()
+// mqttStatePersistStart persists the state of a connection that was made
+func (e *Engine) mqttStatePersistStart(connection *mqttConnection) {
+	startTime := e.clock.Now()
+	clientID := connection.config.ClientId
+
+	connection.mu.Lock()
+	connection.startTime = startTime
+	if connection.client != nil {
+		clientID = connection.client.ClientID()
+	}
+	connection.mu.Unlock()
+
+	e.recordConnectionEvent(connections.Event{
+		Time:       startTime,
+		Type:       connections.EventConnect,
+		Connection: connection.name,
+		Broker:     connection.broker(),
+		ClientID:   clientID,
+	})
+
+	e.state.UpdateConnection(connection.name, func(current *state.ConnectionState) {
+		*current = state.ConnectionState{
+			Status:        state.StatusConnected,
+			Broker:        connection.broker(),
+			ClientID:      clientID,
+			Topic:         connection.config.Topic,
+			StartTime:     state.TimePtr(startTime),
+			Subscriptions: current.Subscriptions,
+		}
+	})
+}
+
+// mqttStatePersistStop persists the state of a connection and records the
+// disconnect with its reason if the client was connected
+func (e *Engine) mqttStatePersistStop(connection *mqttConnection, reason string) {
+	wasConnected := e.state.Connection(connection.name).Status == state.StatusConnected
+
+	connection.mu.Lock()
+	startTime := connection.startTime
+	connection.mu.Unlock()
+
+	if startTime.IsZero() {
+		e.state.UpdateConnection(connection.name, func(current *state.ConnectionState) {
+			current.Status = state.StatusDisconnected
+			current.Broker = connection.broker()
+		})
+		return
+	}
+
+	endTime := e.clock.Now()
+
+	duration := endTime.Sub(startTime)
+
+	if wasConnected {
+		e.recordConnectionEvent(connections.Event{
+			Time:       endTime,
+			Type:       connections.EventDisconnect,
+			Connection: connection.name,
+			Broker:     connection.broker(),
+			Reason:     reason,
+		})
+	}
+
+	e.state.UpdateConnection(connection.name, func(current *state.ConnectionState) {
+		current.Status = state.StatusDisconnected
+		current.EndTime = state.TimePtr(endTime)
+		current.Duration = state.Duration(duration)
+		if wasConnected {
+			current.LastDisconnectReason = reason
+		}
+
+		for i := range current.Subscriptions {
+			current.Subscriptions[i].Status = state.StatusInactive
+		}
+	})
+}
+
+// subscribe subscribes to the topic of a connection and records the
+// subscription. The message counters are kept when the topic is unchanged.
+func (e *Engine) subscribe(connection *mqttConnection) {
+	client := connection.mqttClient()
+	if client == nil {
+		return
+	}
 
-	e.WriteToLogFile("./connections/connections.log", fmt.Sprintf("%s: MQTT connection started\n", mqttStartTime.Format(time.RFC3339)))
+	if err := client.Subscribe(); err != nil {
+		e.logger.Error("Failed to subscribe to topic", zap.String("connection", connection.name), zap.String("topic", connection.config.Topic), zap.Error(err))
+		return
+	}
+
+	subscription := state.SubscriptionState{
+		Topic:        connection.config.Topic,
+		Qos:          connection.config.Qos,
+		Status:       state.StatusSubscribed,
+		SubscribedAt: state.TimePtr(e.clock.Now()),
+	}
+
+	e.state.UpdateConnection(connection.name, func(current *state.ConnectionState) {
+		for _, existing := range current.Subscriptions {
+			if existing.Topic == subscription.Topic {
+				subscription.MessagesReceived = existing.MessagesReceived
+				subscription.LastMessageAt = existing.LastMessageAt
+			}
+		}
 
-	e.statePersister.Set("mqtt", map[string]interface{}{})
-	e.statePersister.Set("mqtt.status", "connected")
-	e.statePersister.Set("mqtt.start_time", startTime.Format(time.RFC3339))
-	e.statePersister.Set("mqtt.topic", e.cfg.App.Mqtt.Topic)
-	e.statePersister.Set("mqtt.client_id", e.client.Config.ClientID)
+		current.Subscriptions = []state.SubscriptionState{subscription}
+	})
 }
 
-// mqttStatePersistStop persists the state of the MQTT connection
-func (e *Engine) mqttStatePersistStop() {
-	e.statePersister.Set("mqtt.status", "disconnected")
+// newTLSConfig creates the client TLS configuration, resolving the file paths
+func newTLSConfig(cfg config.MqttTLSConfig) mqttclient.TLSConfig {
+	tlsConfig := mqttclient.TLSConfig{
+		Enabled:            cfg.Enabled,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		tlsConfig.CAFile = config.ResolvePath(cfg.CAFile)
+	}
+	if cfg.CertFile != "" {
+		tlsConfig.CertFile = config.ResolvePath(cfg.CertFile)
+	}
+	if cfg.KeyFile != "" {
+		tlsConfig.KeyFile = config.ResolvePath(cfg.KeyFile)
+	}
 
-	if !mqttStartTime.IsZero() {
-		mqttEndTime = time.Now()
+	return tlsConfig
+}
 
-		duration := mqttEndTime.Sub(startTime)
+// publishJSON publishes a value as JSON through the default connection.
+// Failures are logged, since the published messages are notifications that
+// must not stop the engine.
+func (e *Engine) publishJSON(topic string, qos byte, retained bool, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		e.logger.Error("Failed to encode message", zap.String("topic", topic), zap.Error(err))
+		return
+	}
 
-		e.WriteToLogFile("./connections/connections.log", fmt.Sprintf("%s: MQTT connection stopped\n", mqttEndTime.Format(time.RFC3339)))
+	var client MQTTClient
+	if connection := e.connection(config.DefaultConnection); connection != nil {
+		client = connection.mqttClient()
+	}
+
+	if client == nil {
+		e.logger.Warn("Message not published, the MQTT client is not connected", zap.String("topic", topic))
+		return
+	}
 
-		e.statePersister.Set("mqtt.end_time", mqttEndTime.Format(time.RFC3339))
-		e.statePersister.Set("mqtt.duration", duration.String())
+	if err := client.Publish(topic, qos, retained, payload); err != nil {
+		e.logger.Warn("Failed to publish message", zap.String("topic", topic), zap.Error(err))
 	}
 }
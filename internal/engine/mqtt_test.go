@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/connections"
+)
+
+func newTestConnection(name string, cfg config.MqttConfig) *mqttConnection {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mqttConnection{
+		name:   name,
+		config: cfg,
+		stats:  &messageStats{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// waitDone fails the test if done is not closed in time
+func waitDone(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestTryMQTTConnectionRetries(t *testing.T) {
+	refused := errors.New("connection refused")
+	clients := newFakeClients(refused, refused)
+	e, clock, _ := newFakeEngine(t, newFakeAppConfig(), clients)
+	start := clock.Now()
+
+	connection := newTestConnection(config.DefaultConnection, e.cfg.App.Mqtt)
+	done := make(chan struct{})
+	go func() {
+		e.tryMQTTConnection(connection, 5)
+		close(done)
+	}()
+
+	clients.waitForConnect(t)
+	clock.waitForWaits(t, 1)
+
+	clock.Advance(4 * time.Second)
+	select {
+	case <-clients.connects:
+		t.Fatal("retried before the retry interval passed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	clients.waitForConnect(t)
+	clock.waitForWaits(t, 2)
+
+	clock.Advance(5 * time.Second)
+	clients.waitForConnect(t)
+	waitDone(t, done, "the connect loop to end")
+
+	if waits := clock.waitForWaits(t, 2); len(waits) != 2 || waits[0] != 5*time.Second || waits[1] != 5*time.Second {
+		t.Errorf("waits = %v, want [5s 5s]", waits)
+	}
+
+	current := e.state.Connection(config.DefaultConnection)
+	if current.Status != state.StatusConnected || current.ClientID != "bms-fake" {
+		t.Errorf("connection is %s as %q, want %s as bms-fake", current.Status, current.ClientID, state.StatusConnected)
+	}
+	if want := start.Add(10 * time.Second); !current.StartTime.Equal(want) {
+		t.Errorf("start time = %v, want %v", current.StartTime, want)
+	}
+	if len(current.Subscriptions) != 1 || current.Subscriptions[0].Status != state.StatusSubscribed {
+		t.Errorf("subscriptions = %+v, want site/# subscribed", current.Subscriptions)
+	}
+
+	events := readEvents(t, e.cfg)
+	want := []connections.Event{
+		{Time: start, Type: connections.EventReconnectAttempt, Attempt: 1},
+		{Time: start.Add(5 * time.Second), Type: connections.EventReconnectAttempt, Attempt: 2},
+		{Time: start.Add(10 * time.Second), Type: connections.EventConnect},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if !event.Time.Equal(want[i].Time) || event.Type != want[i].Type || event.Attempt != want[i].Attempt {
+			t.Errorf("event %d = %s attempt %d at %v, want %s attempt %d at %v", i, event.Type, event.Attempt, event.Time, want[i].Type, want[i].Attempt, want[i].Time)
+		}
+	}
+	if events[0].Reason != "error connecting to MQTT broker: connection refused" {
+		t.Errorf("reason = %q, want the connect error", events[0].Reason)
+	}
+}
+
+func TestTryMQTTConnectionStopped(t *testing.T) {
+	clients := newFakeClients(errors.New("connection refused"))
+	e, clock, _ := newFakeEngine(t, newFakeAppConfig(), clients)
+
+	connection := newTestConnection(config.DefaultConnection, e.cfg.App.Mqtt)
+	done := make(chan struct{})
+	go func() {
+		e.tryMQTTConnection(connection, 120)
+		close(done)
+	}()
+
+	clients.waitForConnect(t)
+	if waits := clock.waitForWaits(t, 1); waits[0] != time.Minute {
+		t.Errorf("retry interval = %v, want it limited to 1m0s", waits[0])
+	}
+
+	connection.cancel()
+	waitDone(t, done, "the connect loop to stop")
+
+	select {
+	case <-clients.connects:
+		t.Error("connected again after the connection was stopped")
+	default:
+	}
+
+	if status := e.state.Connection(config.DefaultConnection).Status; status == state.StatusConnected {
+		t.Errorf("status = %s after the connection was stopped", status)
+	}
+}
+
+func TestConnectionLostAndReconnected(t *testing.T) {
+	clients := newFakeClients()
+	e, clock, _ := newFakeEngine(t, newFakeAppConfig(), clients)
+
+	connection := newTestConnection(config.DefaultConnection, e.cfg.App.Mqtt)
+	e.tryMQTTConnection(connection, 5)
+	client := clients.waitForConnect(t)
+	handlers := client.connectionHandlers()
+
+	clock.Advance(90 * time.Second)
+	handlers.OnConnectionLost(errors.New("EOF"))
+
+	current := e.state.Connection(config.DefaultConnection)
+	if current.Status != state.StatusDisconnected || current.LastDisconnectReason != "EOF" {
+		t.Errorf("connection is %s with reason %q, want %s with reason EOF", current.Status, current.LastDisconnectReason, state.StatusDisconnected)
+	}
+	if current.Duration != state.Duration(90*time.Second) {
+		t.Errorf("duration = %v, want 1m30s", current.Duration)
+	}
+	if current.Subscriptions[0].Status != state.StatusInactive {
+		t.Errorf("subscription is %s, want %s", current.Subscriptions[0].Status, state.StatusInactive)
+	}
+
+	handlers.OnReconnectAttempt(1, errors.New("connection refused"))
+	clock.Advance(5 * time.Second)
+	handlers.OnReconnected()
+
+	current = e.state.Connection(config.DefaultConnection)
+	if current.Status != state.StatusConnected || !current.StartTime.Equal(clock.Now()) {
+		t.Errorf("connection is %s since %v, want %s since %v", current.Status, current.StartTime, state.StatusConnected, clock.Now())
+	}
+	if current.Subscriptions[0].Status != state.StatusSubscribed {
+		t.Errorf("subscription is %s, want %s", current.Subscriptions[0].Status, state.StatusSubscribed)
+	}
+
+	var types []string
+	for _, event := range readEvents(t, e.cfg) {
+		types = append(types, event.Type)
+	}
+	want := []string{connections.EventConnect, connections.EventDisconnect, connections.EventReconnectAttempt, connections.EventConnect}
+	if len(types) != len(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("events = %v, want %v", types, want)
+			break
+		}
+	}
+}
+
+func TestConnectMQTTClientPassword(t *testing.T) {
+	t.Setenv("BMS_TEST_MQTT_PASSWORD", "secret")
+
+	tests := []struct {
+		name     string
+		password config.Secret
+		want     string
+		wantErr  string
+	}{
+		{"plain", "plain", "plain", ""},
+		{"environment reference", "${env:BMS_TEST_MQTT_PASSWORD}", "secret", ""},
+		{"unset reference", "${env:BMS_TEST_MQTT_UNSET}", "", "failed to resolve MQTT password: environment variable BMS_TEST_MQTT_UNSET is not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newFakeClients()
+			e, _, _ := newFakeEngine(t, newFakeAppConfig(), clients)
+
+			cfg := e.cfg.App.Mqtt
+			cfg.Username = "bms"
+			cfg.Password = tt.password
+
+			err := e.connectMQTTClient(newTestConnection(config.DefaultConnection, cfg))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("connectMQTTClient() error = %v, want %q", err, tt.wantErr)
+				}
+				assertNoConnects(t, clients)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("connectMQTTClient() error = %v", err)
+			}
+			if client := clients.waitForConnect(t); client.config.Password != tt.want {
+				t.Errorf("the client connected with password %q, want %q", client.config.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchStopFile(t *testing.T) {
+	e, clock, fileSystem := newFakeEngine(t, newFakeAppConfig(), newFakeClients())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e.WatchStopFile(ctx, e.cfg.StopFilePath)
+	clock.waitForWaits(t, 1)
+
+	fileSystem.MkdirAll(e.cfg.StopFilePath, 0755)
+	select {
+	case <-e.StopFileDetected():
+		t.Fatal("stop file detected before the poll interval passed")
+	default:
+	}
+
+	clock.Advance(stopFilePollInterval)
+
+	select {
+	case <-e.StopFileDetected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop file not detected")
+	}
+}
+
+func TestStopAndCleanup(t *testing.T) {
+	clients := newFakeClients()
+	e, clock, fileSystem := newFakeEngine(t, newFakeAppConfig(), clients)
+	fileSystem.MkdirAll(e.cfg.TmpDirPath, 0755)
+
+	e.startTime = clock.Now()
+	e.initMQTTClients(e.cfg.App)
+	client := clients.waitForConnect(t)
+	eventually(t, "the subscription state", func() bool { return isSubscribed(e, config.DefaultConnection) })
+
+	clock.Advance(time.Hour)
+	e.Stop()
+	e.Cleanup()
+
+	app := e.state.App()
+	if app.Status != state.StatusStopped || app.Duration != state.Duration(time.Hour) || !app.EndTime.Equal(clock.Now()) {
+		t.Errorf("app is %s for %v until %v, want %s for 1h0m0s until %v", app.Status, app.Duration, app.EndTime, state.StatusStopped, clock.Now())
+	}
+
+	if !client.isDisconnected() {
+		t.Error("client not disconnected by cleanup")
+	}
+
+	current := e.state.Connection(config.DefaultConnection)
+	if current.Status != state.StatusDisconnected || current.LastDisconnectReason != connections.ReasonShutdown {
+		t.Errorf("connection is %s with reason %q, want %s with reason %q", current.Status, current.LastDisconnectReason, state.StatusDisconnected, connections.ReasonShutdown)
+	}
+
+	if fileSystem.exists(e.cfg.TmpDirPath) {
+		t.Error("tmp directory not removed by cleanup")
+	}
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"io/fs"
+	"os"
+	"time"
+
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+)
+
+// MQTTClient is a connection to a broker. It is implemented by
+// mqttclient.MQTTClient.
+type MQTTClient interface {
+	Connect() error
+	Disconnect()
+	Subscribe() error
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	SetConnectionHandlers(handlers mqttclient.ConnectionHandlers)
+	SetMessageHandler(handler func(topic string, payload []byte))
+	// ClientID returns the client ID sent to the broker
+	ClientID() string
+}
+
+// ClientFactory creates the client of a connection or of the bridge
+type ClientFactory func(config mqttclient.MQTTConfig) MQTTClient
+
+// Clock tells the time and waits for it to pass. The periodic loops of the
+// engine wait with After, so a fake clock drives them too.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// FileSystem is the file system the engine keeps its tmp and stop files on
+type FileSystem interface {
+	MkdirAll(path string, perm fs.FileMode) error
+	Stat(path string) (fs.FileInfo, error)
+	RemoveAll(path string) error
+}
+
+// Option changes a dependency of the engine
+type Option func(e *Engine)
+
+// WithClientFactory creates the MQTT clients with a factory instead of mqttclient.NewMQTTClient
+func WithClientFactory(factory ClientFactory) Option {
+	return func(e *Engine) {
+		e.newClient = factory
+	}
+}
+
+// WithClock replaces the system clock
+func WithClock(clock Clock) Option {
+	return func(e *Engine) {
+		e.clock = clock
+	}
+}
+
+// WithFileSystem replaces the operating system's file system
+func WithFileSystem(fileSystem FileSystem) Option {
+	return func(e *Engine) {
+		e.fs = fileSystem
+	}
+}
+
+// WithPersister keeps the state in a persister. Without it the state is kept
+// in memory only.
+func WithPersister(persister persist.Persister) Option {
+	return func(e *Engine) {
+		e.statePersister = persister
+	}
+}
+
+func newMQTTClient(config mqttclient.MQTTConfig) MQTTClient {
+	return mqttclient.NewMQTTClient(config)
+}
+
+// systemClock is the clock of the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// osFileSystem is the file system of the os package
+type osFileSystem struct{}
+
+func (osFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (osFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
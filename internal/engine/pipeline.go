@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/filter"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/transform"
+	"go.uber.org/zap"
+)
+
+// initPipeline builds the message pipeline of a connection from the
+// configured stages. The filter runs first, on the messages as received. A
+// connection with its own transform section uses it instead of the app's.
+func (e *Engine) initPipeline(connection *mqttConnection, cfg *config.AppConfig) {
+	transformCfg := cfg.Transform
+	if connection.transform != nil {
+		transformCfg = *connection.transform
+	}
+
+	filterStage, err := filter.New(cfg.Filter.Engine())
+	if err != nil {
+		e.logger.Error("Failed to create the filter stage", zap.String("connection", connection.name), zap.Error(err))
+		return
+	}
+
+	transformer, err := transform.New(transformCfg.Engine())
+	if err != nil {
+		e.logger.Error("Failed to create the transform stage", zap.String("connection", connection.name), zap.Error(err))
+		return
+	}
+
+	connection.pipelineMu.Lock()
+	connection.pipeline = pipeline.New(e.pipelineErrorHandler(connection.name), filterStage, transformer)
+	connection.filter = filterStage
+	connection.pipelineMu.Unlock()
+
+	e.logger.Info("Message pipeline started",
+		zap.String("connection", connection.name),
+		zap.Int("deadbands", len(cfg.Filter.Deadbands)),
+		zap.Int("rate_limits", len(cfg.Filter.RateLimits)),
+		zap.Int("transforms", len(transformCfg.Transforms)),
+	)
+}
+
+func (c *mqttConnection) messagePipeline() *pipeline.Pipeline {
+	c.pipelineMu.RLock()
+	defer c.pipelineMu.RUnlock()
+
+	return c.pipeline
+}
+
+// processMessage passes a received message through the pipeline of its
+// connection and hands the messages that come out to the sinks
+func (e *Engine) processMessage(connection *mqttConnection, topic string, payload []byte, at time.Time) {
+	messages := []pipeline.Message{{Topic: topic, Payload: payload, Time: at}}
+
+	if p := connection.messagePipeline(); p != nil {
+		messages = p.Process(messages[0])
+	}
+
+	for _, message := range messages {
+		e.evaluateRules(message.Topic, message.Payload, message.Time)
+		e.aggregateMessage(message.Topic, message.Payload, message.Time)
+		e.forwardMessage(message)
+	}
+}
+
+// pipelineErrorHandler logs the messages of a connection dropped because a stage failed
+func (e *Engine) pipelineErrorHandler(name string) pipeline.ErrorHandler {
+	return func(stage string, message pipeline.Message, err error) {
+		e.logger.Warn("Message dropped by the pipeline",
+			zap.String("connection", name),
+			zap.String("stage", stage),
+			zap.String("topic", message.Topic),
+			zap.Error(err),
+		)
+	}
+}
+
+// savePipelineCounts adds the messages the pipeline of a connection dropped
+// since the last call to the state
+func (e *Engine) savePipelineCounts(connection *mqttConnection) {
+	connection.pipelineMu.RLock()
+	p, filterStage := connection.pipeline, connection.filter
+	connection.pipelineMu.RUnlock()
+
+	if p == nil {
+		return
+	}
+
+	dropped := p.TakeCounts().Dropped
+	var filtered filter.Counts
+	if filterStage != nil {
+		filtered = filterStage.TakeCounts()
+	}
+
+	if dropped == 0 && filtered == (filter.Counts{}) {
+		return
+	}
+
+	e.state.UpdatePipeline(func(pipeline *state.PipelineState) {
+		pipeline.Dropped += dropped
+		pipeline.Filtered.Duplicates += filtered.Duplicates
+		pipeline.Filtered.Deadband += filtered.Deadband
+		pipeline.Filtered.RateLimited += filtered.RateLimited
+	})
+}
+
+// handlePipelineConfigChange rebuilds the pipelines when the filter or
+// transform section changed. Connections with their own transform section
+// are only rebuilt for a change of the filter.
+func (e *Engine) handlePipelineConfigChange(oldCfg, newCfg *config.AppConfig) {
+	e.logger.Info("Pipeline configuration changed. Reloading the message pipelines")
+
+	filterChanged := !reflect.DeepEqual(oldCfg.Filter, newCfg.Filter)
+
+	for _, connection := range e.connectionList() {
+		if connection.transform != nil && !filterChanged {
+			continue
+		}
+
+		e.savePipelineCounts(connection)
+		e.initPipeline(connection, newCfg)
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+	"go.uber.org/zap"
+)
+
+// rulesTickInterval is how often the stale rules and pending alarms are evaluated
+const rulesTickInterval = time.Second
+
+// alarmQueueSize is how many alarms wait to be saved and published before
+// new alarms are dropped
+const alarmQueueSize = 100
+
+// initRules creates the rules engine and restores the alarms that were active
+// when the application stopped. When the engine replaces another, the point
+// values and the state of unchanged rules are carried over.
+func (e *Engine) initRules(cfg config.RulesConfig) {
+	engine, err := rules.New(cfg.Engine(), e.handleAlarm)
+	if err != nil {
+		e.logger.Error("Failed to create the rules engine", zap.Error(err))
+		return
+	}
+
+	engine.Restore(e.state.Alarms())
+
+	e.rulesMu.Lock()
+	if e.rules != nil {
+		engine.TakeOver(e.rules)
+	}
+	e.rules = engine
+	e.rulesMu.Unlock()
+
+	// Save the active alarms in order with the changes already queued
+	e.queueAlarm(nil)
+
+	e.logger.Info("Rules engine started", zap.Int("points", len(cfg.Points)), zap.Int("rules", len(cfg.Rules)))
+}
+
+func (e *Engine) rulesEngine() *rules.Engine {
+	e.rulesMu.RLock()
+	defer e.rulesMu.RUnlock()
+
+	return e.rules
+}
+
+// evaluateRules passes a message to the rules engine. Messages on the alarm
+// topic are skipped, so alarms do not feed back into the rules.
+func (e *Engine) evaluateRules(topic string, payload []byte, at time.Time) {
+	engine := e.rulesEngine()
+	if engine == nil {
+		return
+	}
+
+	if alarmTopic := e.appConfig().Rules.AlarmTopic; alarmTopic != "" && mqttclient.TopicMatches(alarmTopic+"/#", topic) {
+		return
+	}
+
+	engine.HandleMessage(topic, payload, at)
+}
+
+// tickRules evaluates the time based rules until the context is done
+func (e *Engine) tickRules(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-e.clock.After(interval):
+			if engine := e.rulesEngine(); engine != nil {
+				engine.Tick(now)
+			}
+		}
+	}
+}
+
+// handleAlarm queues a raised or cleared alarm to be saved and published
+func (e *Engine) handleAlarm(alarm rules.Alarm) {
+	fields := []zap.Field{
+		zap.String("rule", alarm.Rule),
+		zap.String("severity", alarm.Severity),
+		zap.String("message", alarm.Message),
+	}
+
+	if alarm.State == rules.StateRaised {
+		e.logger.Warn("Alarm raised", fields...)
+	} else {
+		e.logger.Info("Alarm cleared", fields...)
+	}
+
+	// Publishing waits for the broker, which must not block the message handler
+	e.queueAlarm(&alarm)
+}
+
+// queueAlarm passes an alarm to processAlarms without waiting. The rules
+// engine calls handleAlarm with its notify lock held, so an alarm is dropped
+// when the queue is full rather than blocking every rule while the broker is
+// slow or processAlarms has stopped. The active alarms are still saved with
+// the next alarm that is processed.
+func (e *Engine) queueAlarm(alarm *rules.Alarm) {
+	select {
+	case e.alarms <- alarm:
+	default:
+		if alarm != nil {
+			e.logger.Warn("Alarm queue is full, alarm not published", zap.String("rule", alarm.Rule), zap.String("state", alarm.State))
+		}
+	}
+}
+
+// processAlarms saves the active alarms and publishes the queued alarms one
+// at a time, so a retained raise and its clear reach the broker in order and
+// an older list of active alarms never replaces a newer one. A nil alarm only
+// saves the active alarms.
+func (e *Engine) processAlarms(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alarm := <-e.alarms:
+			if engine := e.rulesEngine(); engine != nil {
+				e.state.SetAlarms(engine.Active())
+			}
+
+			if alarm != nil {
+				e.publishAlarm(*alarm)
+			}
+		}
+	}
+}
+
+func (e *Engine) publishAlarm(alarm rules.Alarm) {
+	rulesCfg := e.appConfig().Rules
+	if rulesCfg.AlarmTopic == "" {
+		return
+	}
+
+	e.publishJSON(rulesCfg.AlarmTopic+"/"+alarm.Rule, rulesCfg.AlarmQos, rulesCfg.Retain, alarm)
+}
+
+// handleRulesConfigChange rebuilds the rules engine. The alarms of rules that
+// still exist stay active and clear normally; the alarms of removed rules are
+// dropped. Point values carry over, and unchanged rules keep their pending
+// raises and clears.
+func (e *Engine) handleRulesConfigChange(newRules config.RulesConfig) {
+	e.logger.Info("Rules configuration changed. Reloading the rules")
+
+	e.initRules(newRules)
+}
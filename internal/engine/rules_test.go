@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+)
+
+func TestHandleAlarmDoesNotBlockWhenTheQueueIsFull(t *testing.T) {
+	above := 30.0
+	cfg := newFakeAppConfig()
+	cfg.Rules = config.RulesConfig{
+		AlarmTopic: "bms/alarms",
+		Points:     []config.RulePointConfig{{Name: "temp", Topic: "site/ahu1"}},
+		Rules:      []config.RuleConfig{{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "temp", Above: &above}},
+	}
+
+	e, _, _ := newFakeEngine(t, cfg, newFakeClients())
+
+	// processAlarms is not running, so nothing takes the alarms off the queue
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		e.initRules(cfg.Rules)
+
+		at := time.Now()
+		for i := 0; i < alarmQueueSize; i++ {
+			at = at.Add(time.Second)
+			e.evaluateRules("site/ahu1", []byte("31"), at)
+			e.evaluateRules("site/ahu1", []byte("29"), at)
+		}
+	}()
+
+	waitDone(t, done, "the rules engine")
+
+	if len(e.alarms) != alarmQueueSize {
+		t.Errorf("queued %d alarms, want %d", len(e.alarms), alarmQueueSize)
+	}
+
+	// The queued alarms are the first ones, in order
+	if first := <-e.alarms; first != nil {
+		t.Errorf("first queued alarm = %+v, want the nil save of initRules", first)
+	}
+	if second := <-e.alarms; second == nil || second.State != rules.StateRaised {
+		t.Errorf("second queued alarm = %+v, want the first raise", second)
+	}
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/batch"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"go.uber.org/zap"
+)
+
+// messageStatsInterval is how often the message counters are saved to the state
+const messageStatsInterval = 5 * time.Second
+
+// messageStats counts the received messages per topic between saves, so the
+// state is not written for every message
+type messageStats struct {
+	mu            sync.Mutex
+	counts        map[string]int64
+	lastMessageAt map[string]time.Time
+}
+
+func (s *messageStats) add(topic string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counts == nil {
+		s.counts = make(map[string]int64)
+		s.lastMessageAt = make(map[string]time.Time)
+	}
+
+	s.counts[topic]++
+	s.lastMessageAt[topic] = at
+}
+
+// take returns the counters and resets them
+func (s *messageStats) take() (map[string]int64, map[string]time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts, lastMessageAt := s.counts, s.lastMessageAt
+	s.counts, s.lastMessageAt = nil, nil
+
+	return counts, lastMessageAt
+}
+
+// handleMessage is called by the MQTT client of a connection for every received message
+func (e *Engine) handleMessage(connection *mqttConnection, topic string, payload []byte) {
+	now := e.clock.Now()
+
+	connection.stats.add(topic, now)
+
+	if encoding, ok := batch.ParseTopic(topic); ok {
+		e.handleBatch(connection, topic, encoding, payload, now)
+		return
+	}
+
+	e.watchDevice(topic, now)
+	e.processMessage(connection, topic, payload, now)
+}
+
+// handleBatch unpacks a batch published by a bridge and handles its messages
+// as if each was received on its own topic
+func (e *Engine) handleBatch(connection *mqttConnection, topic string, encoding batch.Encoding, payload []byte, now time.Time) {
+	messages, err := batch.Decode(encoding, payload)
+	if err != nil {
+		e.logger.Warn("Failed to unpack batch", zap.String("connection", connection.name), zap.String("topic", topic), zap.Error(err))
+		return
+	}
+
+	for _, message := range messages {
+		if message.Time.IsZero() {
+			message.Time = now
+		}
+
+		e.watchDevice(message.Topic, now)
+		e.processMessage(connection, message.Topic, message.Payload, message.Time)
+	}
+}
+
+// persistMessageStats saves the message counters periodically until the context is done
+func (e *Engine) persistMessageStats(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.clock.After(interval):
+			e.flushMessageStats()
+		}
+	}
+}
+
+// flushMessageStats adds the counted messages of every connection to the
+// pipeline and subscription state, and saves the bridge and aggregator counts
+func (e *Engine) flushMessageStats() {
+	e.flushBridgeStats()
+	e.flushAggregateCounts()
+
+	for _, connection := range e.connectionList() {
+		e.flushConnectionStats(connection)
+	}
+}
+
+// flushConnectionStats adds the counted messages of a connection to the
+// pipeline state and its subscriptions
+func (e *Engine) flushConnectionStats(connection *mqttConnection) {
+	e.savePipelineCounts(connection)
+
+	counts, lastMessageAt := connection.stats.take()
+	if len(counts) == 0 {
+		return
+	}
+
+	var received int64
+	var last time.Time
+	for topic, count := range counts {
+		received += count
+		if lastMessageAt[topic].After(last) {
+			last = lastMessageAt[topic]
+		}
+	}
+
+	e.state.UpdatePipeline(func(pipeline *state.PipelineState) {
+		pipeline.Received += received
+		if pipeline.LastMessageAt == nil || last.After(*pipeline.LastMessageAt) {
+			pipeline.LastMessageAt = state.TimePtr(last)
+		}
+	})
+
+	e.state.UpdateConnection(connection.name, func(current *state.ConnectionState) {
+		subscriptions := current.Subscriptions
+		for i := range subscriptions {
+			for topic, count := range counts {
+				if !mqttclient.TopicMatches(subscriptions[i].Topic, topic) {
+					continue
+				}
+
+				subscriptions[i].MessagesReceived += count
+				if subscriptions[i].LastMessageAt == nil || lastMessageAt[topic].After(*subscriptions[i].LastMessageAt) {
+					subscriptions[i].LastMessageAt = state.TimePtr(lastMessageAt[topic])
+				}
+			}
+		}
+	})
+}
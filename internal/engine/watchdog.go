@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/config"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/internal/state"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/watchdog"
+	"go.uber.org/zap"
+)
+
+// watchdogCheckInterval is how often silent devices are looked for and the
+// devices are saved to the state
+const watchdogCheckInterval = 5 * time.Second
+
+// deviceEvent is the message published for a watchdog event
+type deviceEvent struct {
+	Type     string    `json:"type"`
+	Device   string    `json:"device"`
+	Time     time.Time `json:"time"`
+	LastSeen time.Time `json:"last_seen"`
+	Silence  string    `json:"silence"`
+}
+
+// initWatchdog creates the device watchdog and restores the devices seen
+// before, counting their silence from no earlier than the start of the
+// application. The watchdog is off when no pattern is configured.
+func (e *Engine) initWatchdog(cfg config.WatchdogConfig) {
+	var dog *watchdog.Watchdog
+
+	if cfg.Pattern != "" {
+		var err error
+		dog, err = watchdog.New(cfg.Engine(), e.handleDeviceEvent)
+		if err != nil {
+			e.logger.Error("Failed to create the device watchdog", zap.Error(err))
+			return
+		}
+
+		dog.Restore(devicesFromState(e.state.Devices()), e.startTime)
+
+		e.logger.Info("Device watchdog started", zap.String("pattern", cfg.Pattern), zap.Int("devices", len(dog.Devices())))
+	}
+
+	e.watchdogMu.Lock()
+	e.watchdog = dog
+	e.watchdogMu.Unlock()
+
+	e.saveDevices()
+}
+
+func (e *Engine) deviceWatchdog() *watchdog.Watchdog {
+	e.watchdogMu.RLock()
+	defer e.watchdogMu.RUnlock()
+
+	return e.watchdog
+}
+
+// watchDevice records the message of a device
+func (e *Engine) watchDevice(topic string, at time.Time) {
+	if dog := e.deviceWatchdog(); dog != nil {
+		dog.Seen(topic, at)
+	}
+}
+
+// checkDevices looks for silent devices and saves the devices until the
+// context is done
+func (e *Engine) checkDevices(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-e.clock.After(interval):
+			if dog := e.deviceWatchdog(); dog != nil {
+				dog.Check(now)
+				e.saveDevices()
+			}
+		}
+	}
+}
+
+// saveDevices saves the tracked devices to the state
+func (e *Engine) saveDevices() {
+	devices := []state.DeviceState{}
+
+	if dog := e.deviceWatchdog(); dog != nil {
+		for _, device := range dog.Devices() {
+			devices = append(devices, state.DeviceState{
+				ID:          device.ID,
+				Topic:       device.Topic,
+				Status:      device.Status,
+				LastSeen:    state.TimePtr(device.LastSeen),
+				Interval:    state.Duration(device.Interval),
+				Messages:    device.Messages,
+				SilentSince: device.SilentSince,
+			})
+		}
+	}
+
+	e.state.SetDevices(devices)
+}
+
+func devicesFromState(saved []state.DeviceState) []watchdog.Device {
+	devices := make([]watchdog.Device, 0, len(saved))
+	for _, device := range saved {
+		if device.LastSeen == nil {
+			continue
+		}
+
+		devices = append(devices, watchdog.Device{
+			ID:          device.ID,
+			Topic:       device.Topic,
+			Status:      device.Status,
+			LastSeen:    *device.LastSeen,
+			Messages:    device.Messages,
+			SilentSince: device.SilentSince,
+		})
+	}
+
+	return devices
+}
+
+// handleDeviceEvent logs and publishes a device going silent or recovering
+func (e *Engine) handleDeviceEvent(event watchdog.Event) {
+	fields := []zap.Field{
+		zap.String("device", event.Device),
+		zap.Time("last_seen", event.LastSeen),
+		zap.Duration("silence", event.Silence.Round(time.Second)),
+	}
+
+	if event.Type == watchdog.EventSilent {
+		e.logger.Warn("Device silent", fields...)
+	} else {
+		e.logger.Info("Device recovered", fields...)
+	}
+
+	eventTopic := e.appConfig().Watchdog.EventTopic
+	if eventTopic == "" {
+		return
+	}
+
+	// Publishing waits for the broker, which must not block the message handler
+	go e.publishJSON(eventTopic+"/"+event.Device, 1, false, deviceEvent{
+		Type:     event.Type,
+		Device:   event.Device,
+		Time:     event.Time,
+		LastSeen: event.LastSeen,
+		Silence:  event.Silence.Round(time.Second).String(),
+	})
+}
+
+// handleWatchdogConfigChange recreates the watchdog. Devices keep their last
+// seen time when the pattern still gives them the same ID.
+func (e *Engine) handleWatchdogConfigChange(newWatchdog config.WatchdogConfig) {
+	e.logger.Info("Watchdog configuration changed. Reloading the device watchdog")
+
+	e.saveDevices()
+	e.initWatchdog(newWatchdog)
+}
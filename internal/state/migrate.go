@@ -0,0 +1,106 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+)
+
+// SchemaVersion is the version of the state layout written by this build
+const SchemaVersion = 3
+
+// migrations upgrade the state from the version at their index plus one to
+// the next version. Version 1 is the untyped layout without a schema_version.
+var migrations = []func(persister persist.Persister){
+	migrateV1ToV2,
+	migrateV2ToV3,
+}
+
+// Migrate upgrades the state to the current schema version and returns the
+// version it started from. A newer version than this build knows is an error,
+// since the layout cannot be trusted.
+func Migrate(persister persist.Persister) (int, error) {
+	version := schemaVersion(persister)
+
+	if version > SchemaVersion {
+		return version, fmt.Errorf("state schema version %d is newer than the supported version %d", version, SchemaVersion)
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		migrations[v-1](persister)
+		persister.Set(schemaVersionKey, v+1)
+	}
+
+	return version, nil
+}
+
+// schemaVersion returns the schema version of the state. State without a
+// version is version 1, and an empty state is already current.
+func schemaVersion(persister persist.Persister) int {
+	switch version := persister.Get(schemaVersionKey).(type) {
+	case int:
+		return version
+	case float64:
+		return int(version)
+	}
+
+	if len(persister.Keys("")) == 0 {
+		return SchemaVersion
+	}
+
+	return 1
+}
+
+// migrateV1ToV2 converts the untyped layout. Times were saved as RFC 3339
+// strings, which the typed state reads as is. The MQTT start time and
+// duration were measured from the application start, so they are dropped,
+// and the broker is now part of the connection state.
+func migrateV1ToV2(persister persist.Persister) {
+	for _, key := range []string{"app.start_time", "app.end_time", "mqtt.end_time"} {
+		value, ok := persister.Get(key).(string)
+		if !ok {
+			continue
+		}
+
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			persister.Delete(key)
+		}
+	}
+
+	if value, ok := persister.Get("app.duration").(string); ok {
+		if _, err := time.ParseDuration(value); err != nil {
+			persister.Delete("app.duration")
+		}
+	}
+
+	persister.Delete("mqtt.start_time")
+	persister.Delete("mqtt.duration")
+}
+
+// migrateV2ToV3 moves the single MQTT connection and its subscriptions below
+// mqtt.default, since every named connection now has its own section. The
+// recorded history of the connection keys moves with them.
+func migrateV2ToV3(persister persist.Persister) {
+	for _, prefix := range []string{connectionsKey, historyKey + "." + connectionsKey} {
+		values := map[string]interface{}{}
+		for _, key := range persister.Keys(prefix) {
+			values[strings.TrimPrefix(key, prefix+".")] = persister.Get(key)
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		persister.Delete(prefix)
+		for key, value := range values {
+			persister.Set(prefix+"."+defaultConnection+"."+key, value)
+		}
+	}
+
+	if subscriptions := persister.Get("subscriptions"); subscriptions != nil {
+		persister.Set(connectionKey(defaultConnection)+".subscriptions", subscriptions)
+	}
+	persister.Delete("subscriptions")
+}
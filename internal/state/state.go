@@ -0,0 +1,424 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/persist"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+)
+
+// Keys of the state sections in the persister
+const (
+	appKey           = "app"
+	connectionsKey   = "mqtt"
+	pipelineKey      = "pipeline"
+	alarmsKey        = "alarms"
+	devicesKey       = "devices"
+	bridgeKey        = "bridge"
+	aggregateKey     = "aggregate"
+	schemaVersionKey = "schema_version"
+	// historyKey is where the persister keeps the history of tracked keys
+	historyKey = "history"
+)
+
+// defaultConnection is the name of the connection configured in the mqtt
+// section, which held the only connection before version 3
+const defaultConnection = "default"
+
+// Status values
+const (
+	StatusRunning      = "running"
+	StatusStopped      = "stopped"
+	StatusConnected    = "connected"
+	StatusDisconnected = "disconnected"
+	StatusSubscribed   = "subscribed"
+	StatusInactive     = "inactive"
+	StatusOnline       = "online"
+	StatusSilent       = "silent"
+)
+
+// State is the complete application state
+type State struct {
+	SchemaVersion int      `json:"schema_version"`
+	App           AppState `json:"app"`
+	// Connections are the MQTT connections by name
+	Connections map[string]ConnectionState `json:"mqtt"`
+	Pipeline    PipelineState              `json:"pipeline"`
+	Alarms      []rules.Alarm              `json:"alarms"`
+	Devices     []DeviceState              `json:"devices"`
+	Bridge      BridgeState                `json:"bridge"`
+	Aggregate   AggregateState             `json:"aggregate"`
+}
+
+// AppState is the state of the application process
+type AppState struct {
+	Status      string     `json:"status"`
+	Name        string     `json:"name"`
+	Version     string     `json:"version"`
+	Environment string     `json:"environment"`
+	ConfigFiles []string   `json:"config_files"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	Duration    Duration   `json:"duration,omitempty"`
+}
+
+// ConnectionState is the state of a connection to an MQTT broker, saved under
+// mqtt.<name>
+type ConnectionState struct {
+	Status    string     `json:"status"`
+	Broker    string     `json:"broker,omitempty"`
+	ClientID  string     `json:"client_id,omitempty"`
+	Topic     string     `json:"topic,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	Duration  Duration   `json:"duration,omitempty"`
+	// LastDisconnectReason is the reason of the last disconnect, see connections.Event
+	LastDisconnectReason string              `json:"last_disconnect_reason,omitempty"`
+	Subscriptions        []SubscriptionState `json:"subscriptions"`
+}
+
+// SubscriptionState is the state of a topic subscription
+type SubscriptionState struct {
+	Topic            string     `json:"topic"`
+	Qos              byte       `json:"qos"`
+	Status           string     `json:"status"`
+	SubscribedAt     *time.Time `json:"subscribed_at,omitempty"`
+	MessagesReceived int64      `json:"messages_received"`
+	LastMessageAt    *time.Time `json:"last_message_at,omitempty"`
+}
+
+// PipelineState counts the messages handled by the message pipeline
+type PipelineState struct {
+	Received      int64      `json:"received"`
+	Published     int64      `json:"published"`
+	Dropped       int64      `json:"dropped"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+	// Filtered counts the dropped messages by the reason the filter stage dropped them
+	Filtered FilteredState `json:"filtered"`
+}
+
+// FilteredState counts the messages dropped by the filter stage
+type FilteredState struct {
+	Duplicates  int64 `json:"duplicates"`
+	Deadband    int64 `json:"deadband"`
+	RateLimited int64 `json:"rate_limited"`
+}
+
+// BridgeState is the state of the bridge to the destination broker
+type BridgeState struct {
+	Status        string     `json:"status,omitempty"`
+	Broker        string     `json:"broker,omitempty"`
+	Forwarded     int64      `json:"forwarded"`
+	Failed        int64      `json:"failed"`
+	Looped        int64      `json:"looped"`
+	Batches       int64      `json:"batches"`
+	LastForwardAt *time.Time `json:"last_forward_at,omitempty"`
+}
+
+// AggregateState counts the summaries made by the aggregator
+type AggregateState struct {
+	Summaries     int64      `json:"summaries"`
+	Late          int64      `json:"late"`
+	LastSummaryAt *time.Time `json:"last_summary_at,omitempty"`
+}
+
+// DeviceState is the last seen state of a device tracked by the watchdog
+type DeviceState struct {
+	ID          string     `json:"id"`
+	Topic       string     `json:"topic"`
+	Status      string     `json:"status"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	Interval    Duration   `json:"interval"`
+	Messages    int64      `json:"messages"`
+	SilentSince *time.Time `json:"silent_since,omitempty"`
+}
+
+// Duration is a time.Duration saved in its string form, for example "1h2m3s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h2m3s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// TimePtr returns a pointer to a copy of t, for the optional time fields
+func TimePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// Store reads and writes the typed state sections through a persister
+type Store struct {
+	mu        sync.Mutex
+	persister persist.Persister
+}
+
+func NewStore(persister persist.Persister) *Store {
+	return &Store{persister: persister}
+}
+
+// App returns the application state
+func (s *Store) App() AppState {
+	var app AppState
+	decodeSection(s.persister, appKey, &app)
+	return app
+}
+
+// SetApp replaces the application state
+func (s *Store) SetApp(app AppState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encodeSection(s.persister, appKey, app)
+}
+
+// UpdateApp changes the application state in place
+func (s *Store) UpdateApp(update func(app *AppState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var app AppState
+	decodeSection(s.persister, appKey, &app)
+	update(&app)
+	encodeSection(s.persister, appKey, app)
+}
+
+// Connection returns the state of a named MQTT connection
+func (s *Store) Connection(name string) ConnectionState {
+	var connection ConnectionState
+	decodeSection(s.persister, connectionKey(name), &connection)
+	return connection
+}
+
+// Connections returns the state of every MQTT connection by name
+func (s *Store) Connections() map[string]ConnectionState {
+	var connections map[string]ConnectionState
+	decodeSection(s.persister, connectionsKey, &connections)
+	return connections
+}
+
+// SetConnection replaces the state of a named MQTT connection
+func (s *Store) SetConnection(name string, connection ConnectionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encodeSection(s.persister, connectionKey(name), connection)
+}
+
+// UpdateConnection changes the state of a named MQTT connection in place
+func (s *Store) UpdateConnection(name string, update func(connection *ConnectionState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var connection ConnectionState
+	decodeSection(s.persister, connectionKey(name), &connection)
+	update(&connection)
+	encodeSection(s.persister, connectionKey(name), connection)
+}
+
+// DeleteConnection removes the state of a connection that no longer exists
+func (s *Store) DeleteConnection(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.persister.Delete(connectionKey(name))
+}
+
+func connectionKey(name string) string {
+	return connectionsKey + "." + name
+}
+
+// Pipeline returns the state of the message pipeline
+func (s *Store) Pipeline() PipelineState {
+	var pipeline PipelineState
+	decodeSection(s.persister, pipelineKey, &pipeline)
+	return pipeline
+}
+
+// UpdatePipeline changes the state of the message pipeline in place
+func (s *Store) UpdatePipeline(update func(pipeline *PipelineState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pipeline PipelineState
+	decodeSection(s.persister, pipelineKey, &pipeline)
+	update(&pipeline)
+	encodeSection(s.persister, pipelineKey, pipeline)
+}
+
+// Alarms returns the active alarms
+func (s *Store) Alarms() []rules.Alarm {
+	var alarms []rules.Alarm
+	decodeList(s.persister, alarmsKey, &alarms)
+	return alarms
+}
+
+// SetAlarms replaces the active alarms
+func (s *Store) SetAlarms(alarms []rules.Alarm) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encodeList(s.persister, alarmsKey, alarms)
+}
+
+// Bridge returns the state of the bridge
+func (s *Store) Bridge() BridgeState {
+	var bridge BridgeState
+	decodeSection(s.persister, bridgeKey, &bridge)
+	return bridge
+}
+
+// UpdateBridge changes the state of the bridge in place
+func (s *Store) UpdateBridge(update func(bridge *BridgeState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bridge BridgeState
+	decodeSection(s.persister, bridgeKey, &bridge)
+	update(&bridge)
+	encodeSection(s.persister, bridgeKey, bridge)
+}
+
+// Aggregate returns the state of the aggregator
+func (s *Store) Aggregate() AggregateState {
+	var aggregate AggregateState
+	decodeSection(s.persister, aggregateKey, &aggregate)
+	return aggregate
+}
+
+// UpdateAggregate changes the state of the aggregator in place
+func (s *Store) UpdateAggregate(update func(aggregate *AggregateState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var aggregate AggregateState
+	decodeSection(s.persister, aggregateKey, &aggregate)
+	update(&aggregate)
+	encodeSection(s.persister, aggregateKey, aggregate)
+}
+
+// Devices returns the devices tracked by the watchdog
+func (s *Store) Devices() []DeviceState {
+	var devices []DeviceState
+	decodeList(s.persister, devicesKey, &devices)
+	return devices
+}
+
+// SetDevices replaces the devices tracked by the watchdog
+func (s *Store) SetDevices(devices []DeviceState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encodeList(s.persister, devicesKey, devices)
+}
+
+// Load reads the complete state. Sections that cannot be decoded are left
+// empty and reported in the returned error.
+func Load(persister persist.Persister) (*State, error) {
+	state := &State{SchemaVersion: schemaVersion(persister)}
+
+	var errs []error
+	errs = append(errs, decodeSection(persister, appKey, &state.App))
+	errs = append(errs, decodeSection(persister, connectionsKey, &state.Connections))
+	errs = append(errs, decodeSection(persister, pipelineKey, &state.Pipeline))
+	errs = append(errs, decodeList(persister, alarmsKey, &state.Alarms))
+	errs = append(errs, decodeList(persister, devicesKey, &state.Devices))
+	errs = append(errs, decodeSection(persister, bridgeKey, &state.Bridge))
+	errs = append(errs, decodeSection(persister, aggregateKey, &state.Aggregate))
+
+	for _, err := range errs {
+		if err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+// decodeSection decodes the values below a key into a struct
+func decodeSection(persister persist.Persister, key string, target interface{}) error {
+	tree := persist.Subtree(persister, key)
+	if len(tree) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("invalid %s state: %w", key, err)
+	}
+
+	return nil
+}
+
+// encodeSection replaces the values below a key with the fields of a struct
+func encodeSection(persister persist.Persister, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return
+	}
+
+	persister.Set(key, tree)
+}
+
+// decodeList decodes a list value into a slice
+func decodeList(persister persist.Persister, key string, target interface{}) error {
+	value := persister.Get(key)
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("invalid %s state: %w", key, err)
+	}
+
+	return nil
+}
+
+// encodeList saves a slice as a list value
+func encodeList(persister persist.Persister, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	var list []interface{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return
+	}
+
+	persister.Set(key, list)
+}
@@ -0,0 +1,240 @@
+// Package aggregate summarises the values of points over tumbling windows.
+// Every window of a point yields the count, min, max, mean and last value of
+// the point within it.
+package aggregate
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+)
+
+// Summary is the summary of a point over one window
+type Summary struct {
+	Point  string    `json:"point"`
+	Window string    `json:"window"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Count  int64     `json:"count"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Mean   float64   `json:"mean"`
+	Last   float64   `json:"last"`
+}
+
+// Counts are the number of summaries made and of values that arrived after
+// their window was closed
+type Counts struct {
+	Summaries int64
+	Late      int64
+}
+
+// Aggregator collects the values of the points and makes a summary of each
+// window once the watermark has passed its end. It is safe for concurrent use.
+type Aggregator struct {
+	mu        sync.Mutex
+	config    Config
+	onSummary func(summary Summary)
+
+	// open are the windows still collecting values
+	open map[windowKey]*window
+	// closed is the end of the last window closed for each point and length
+	closed map[seriesKey]time.Time
+	counts Counts
+}
+
+type seriesKey struct {
+	point  string
+	length time.Duration
+}
+
+type windowKey struct {
+	seriesKey
+	start int64
+}
+
+// window holds the values of a point collected over one window
+type window struct {
+	point  string
+	length time.Duration
+	start  time.Time
+	count  int64
+	min    float64
+	max    float64
+	sum    float64
+	last   float64
+	lastAt time.Time
+}
+
+// New creates an aggregator for a valid configuration. onSummary is called for
+// every closed window.
+func New(config Config, onSummary func(summary Summary)) (*Aggregator, error) {
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	return &Aggregator{
+		config:    config,
+		onSummary: onSummary,
+		open:      make(map[windowKey]*window),
+		closed:    make(map[seriesKey]time.Time),
+	}, nil
+}
+
+// HandleMessage adds the values of the points a message carries to their
+// windows. Values of windows that are already closed are counted as late and
+// dropped.
+func (a *Aggregator) HandleMessage(topic string, payload []byte, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, point := range a.config.Points {
+		if !mqttclient.TopicMatches(point.Topic, topic) {
+			continue
+		}
+
+		raw, ok := field.FromPayload(payload, point.Field)
+		if !ok {
+			continue
+		}
+
+		value, ok := field.Number(raw)
+		if !ok {
+			continue
+		}
+
+		measuredAt := at
+		if point.TimeField != "" {
+			if measuredAt, ok = pointTime(payload, point.TimeField); !ok {
+				continue
+			}
+		}
+
+		for _, length := range a.config.Windows {
+			a.add(point.Name, length, value, measuredAt, at)
+		}
+	}
+}
+
+// add adds a value measured at a time to its window of a length
+func (a *Aggregator) add(point string, length time.Duration, value float64, measuredAt, now time.Time) {
+	series := seriesKey{point: point, length: length}
+	start := measuredAt.Truncate(length)
+	end := start.Add(length)
+
+	if !end.After(a.closed[series]) || !now.Before(end.Add(a.config.Watermark)) {
+		a.counts.Late++
+		return
+	}
+
+	key := windowKey{seriesKey: series, start: start.UnixNano()}
+	current := a.open[key]
+	if current == nil {
+		current = &window{point: point, length: length, start: start, min: value, max: value}
+		a.open[key] = current
+	}
+
+	current.count++
+	current.sum += value
+	current.min = math.Min(current.min, value)
+	current.max = math.Max(current.max, value)
+	if !measuredAt.Before(current.lastAt) {
+		current.last = value
+		current.lastAt = measuredAt
+	}
+}
+
+// Tick closes the windows whose end plus the watermark has passed and calls
+// onSummary for each, oldest first
+func (a *Aggregator) Tick(now time.Time) {
+	a.mu.Lock()
+
+	var done []*window
+	for key, current := range a.open {
+		end := current.start.Add(current.length)
+		if now.Before(end.Add(a.config.Watermark)) {
+			continue
+		}
+
+		done = append(done, current)
+		delete(a.open, key)
+
+		if end.After(a.closed[key.seriesKey]) {
+			a.closed[key.seriesKey] = end
+		}
+	}
+
+	a.counts.Summaries += int64(len(done))
+	a.mu.Unlock()
+
+	sort.Slice(done, func(i, j int) bool {
+		if !done[i].start.Equal(done[j].start) {
+			return done[i].start.Before(done[j].start)
+		}
+		if done[i].length != done[j].length {
+			return done[i].length < done[j].length
+		}
+		return done[i].point < done[j].point
+	})
+
+	for _, current := range done {
+		a.onSummary(current.summary())
+	}
+}
+
+// TakeCounts returns the counts since the last call and resets them
+func (a *Aggregator) TakeCounts() Counts {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	counts := a.counts
+	a.counts = Counts{}
+	return counts
+}
+
+func (w *window) summary() Summary {
+	return Summary{
+		Point:  w.point,
+		Window: Label(w.length),
+		Start:  w.start.UTC(),
+		End:    w.start.Add(w.length).UTC(),
+		Count:  w.count,
+		Min:    w.min,
+		Max:    w.max,
+		Mean:   w.sum / float64(w.count),
+		Last:   w.last,
+	}
+}
+
+// pointTime takes the time at a dotted path of a JSON payload, as RFC 3339
+// or Unix seconds or milliseconds
+func pointTime(payload []byte, path string) (time.Time, bool) {
+	value, ok := field.FromPayload(payload, path)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if text, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(text)); err == nil {
+			return t, true
+		}
+	}
+
+	seconds, ok := field.Number(value)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	// Unix times in milliseconds are larger than any in seconds until the year 33658
+	if seconds > 1e12 {
+		return time.UnixMilli(int64(seconds)), true
+	}
+
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), true
+}
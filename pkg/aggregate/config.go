@@ -0,0 +1,130 @@
+package aggregate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+)
+
+// Config is the configuration of the aggregator
+type Config struct {
+	// Windows are the lengths of the tumbling windows, aligned to the clock
+	Windows []time.Duration
+	// Watermark is how long after its end a window stays open for late points
+	Watermark time.Duration
+	Points    []Point
+}
+
+// Point is a named value taken from the messages on a topic
+type Point struct {
+	Name string
+	// Topic is a topic filter that may contain the + and # wildcards
+	Topic string
+	// Field is the dotted path of the value in a JSON payload. When empty the
+	// whole payload is the value.
+	Field string
+	// TimeField is the dotted path of the time the value was measured, as
+	// RFC 3339 or Unix seconds or milliseconds. When empty the time the
+	// message was received is used.
+	TimeField string
+}
+
+// Validate checks a configuration and returns every problem found
+func (c Config) Validate() []*field.Error {
+	var errs []*field.Error
+
+	windows := map[time.Duration]bool{}
+	for i, window := range c.Windows {
+		path := fmt.Sprintf("windows.%d", i)
+
+		switch {
+		case window < time.Second || window%time.Second != 0:
+			errs = append(errs, field.Errorf(path, "must be a whole number of seconds, got %s", window))
+		case windows[window]:
+			errs = append(errs, field.Errorf(path, "duplicate window %s", Label(window)))
+		}
+		windows[window] = true
+	}
+
+	if c.Watermark < 0 {
+		errs = append(errs, field.Errorf("watermark", "must not be negative, got %s", c.Watermark))
+	}
+
+	names := map[string]bool{}
+	for i, point := range c.Points {
+		prefix := fmt.Sprintf("points.%d", i)
+
+		switch {
+		case point.Name == "":
+			errs = append(errs, field.Errorf(prefix+".name", "must not be empty"))
+		case strings.ContainsAny(point.Name, "/+#"):
+			errs = append(errs, field.Errorf(prefix+".name", "must not contain /, + or #, got %q", point.Name))
+		case names[point.Name]:
+			errs = append(errs, field.Errorf(prefix+".name", "duplicate point %q", point.Name))
+		}
+		names[point.Name] = true
+
+		if err := checkFilter(point.Topic); err != nil {
+			errs = append(errs, field.Errorf(prefix+".topic", "%s", err))
+		}
+
+		if point.Field != "" && !validPath(point.Field) {
+			errs = append(errs, field.Errorf(prefix+".field", "must be a dotted field name such as data.temperature, got %q", point.Field))
+		}
+
+		if point.TimeField != "" && !validPath(point.TimeField) {
+			errs = append(errs, field.Errorf(prefix+".time_field", "must be a dotted field name such as data.ts, got %q", point.TimeField))
+		}
+	}
+
+	if len(c.Points) > 0 && len(c.Windows) == 0 {
+		errs = append(errs, field.Errorf("windows", "at least one window is needed to aggregate points"))
+	}
+
+	return errs
+}
+
+// Label returns the short form of a window used in summary topics, for
+// example 5m for five minutes
+func Label(window time.Duration) string {
+	switch {
+	case window >= time.Hour && window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window >= time.Minute && window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", window/time.Second)
+	}
+}
+
+// checkFilter checks a topic filter
+func checkFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("must not be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level of %q", filter)
+		}
+
+		if level != "+" && level != "#" && strings.ContainsAny(level, "+#") {
+			return fmt.Errorf("wildcards must be a whole level in %q", filter)
+		}
+	}
+
+	return nil
+}
+
+func validPath(path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,255 @@
+// Package batch packs many messages into one payload, as a JSON array or
+// newline-delimited JSON, optionally compressed with gzip or zstd. A batch is
+// published to a topic whose last level names its encoding, for example
+// upstream/$batch.ndjson.gz, so a subscriber can unpack it.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats of a batch
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Compressions of a batch
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// LevelPrefix starts the topic level that marks a batch and names its encoding
+const LevelPrefix = "$batch."
+
+// MaxDecodedSize is the largest batch unpacked, which guards against
+// payloads that decompress to more than fits in memory
+const MaxDecodedSize = 64 << 20
+
+// extensions are the file extensions naming the compressions in the topic level
+var extensions = map[string]string{
+	CompressionNone: "",
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// Encoding is how the messages of a batch are packed
+type Encoding struct {
+	Format      string
+	Compression string
+}
+
+// Level returns the topic level that marks a batch of this encoding, for
+// example $batch.ndjson.gz
+func (e Encoding) Level() string {
+	return LevelPrefix + e.Format + extensions[e.compression()]
+}
+
+// Validate checks an encoding
+func (e Encoding) Validate() error {
+	if e.Format != FormatJSON && e.Format != FormatNDJSON {
+		return fmt.Errorf("invalid format %q, valid formats: '%s', '%s'", e.Format, FormatJSON, FormatNDJSON)
+	}
+
+	if _, ok := extensions[e.compression()]; !ok {
+		return fmt.Errorf("invalid compression %q, valid compressions: '%s', '%s', '%s'", e.Compression, CompressionNone, CompressionGzip, CompressionZstd)
+	}
+
+	return nil
+}
+
+func (e Encoding) compression() string {
+	if e.Compression == "" {
+		return CompressionNone
+	}
+
+	return e.Compression
+}
+
+// ParseTopic returns the encoding named by the last level of a batch topic.
+// It returns false for topics that do not carry a batch.
+func ParseTopic(topic string) (Encoding, bool) {
+	level := topic[strings.LastIndex(topic, "/")+1:]
+	if !strings.HasPrefix(level, LevelPrefix) {
+		return Encoding{}, false
+	}
+
+	name := strings.TrimPrefix(level, LevelPrefix)
+	for compression, extension := range extensions {
+		if extension == "" {
+			continue
+		}
+
+		if format, ok := strings.CutSuffix(name, extension); ok {
+			encoding := Encoding{Format: format, Compression: compression}
+			return encoding, encoding.Validate() == nil
+		}
+	}
+
+	encoding := Encoding{Format: name, Compression: CompressionNone}
+	return encoding, encoding.Validate() == nil
+}
+
+// item is a message in a batch. A JSON payload is kept as it is, any other
+// payload is carried as base64 in data.
+type item struct {
+	Topic   string          `json:"topic"`
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Data    []byte          `json:"data,omitempty"`
+}
+
+// encodeItem encodes a message as one JSON value of a batch
+func encodeItem(message pipeline.Message) ([]byte, error) {
+	entry := item{Topic: message.Topic, Time: message.Time.UTC()}
+	if json.Valid(message.Payload) {
+		entry.Payload = message.Payload
+	} else {
+		entry.Data = message.Payload
+	}
+
+	return json.Marshal(entry)
+}
+
+// pack joins the encoded items in the format and compresses them
+func pack(encoding Encoding, items [][]byte) ([]byte, error) {
+	var joined []byte
+	if encoding.Format == FormatJSON {
+		joined = append([]byte("["), bytes.Join(items, []byte(","))...)
+		joined = append(joined, ']')
+	} else {
+		joined = append(bytes.Join(items, []byte("\n")), '\n')
+	}
+
+	var buf bytes.Buffer
+	switch encoding.compression() {
+	case CompressionGzip:
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(joined); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		writer, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(joined); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return joined, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encode packs messages into one batch payload
+func Encode(encoding Encoding, messages []pipeline.Message) ([]byte, error) {
+	if err := encoding.Validate(); err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		encoded, err := encodeItem(message)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, encoded)
+	}
+
+	return pack(encoding, items)
+}
+
+// Decode unpacks the messages of a batch payload
+func Decode(encoding Encoding, payload []byte) ([]pipeline.Message, error) {
+	if err := encoding.Validate(); err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = bytes.NewReader(payload)
+	switch encoding.compression() {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress the batch: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress the batch: %w", err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxDecodedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress the batch: %w", err)
+	}
+
+	if len(data) > MaxDecodedSize {
+		return nil, fmt.Errorf("batch is larger than %d bytes unpacked", MaxDecodedSize)
+	}
+
+	var items []item
+	if encoding.Format == FormatJSON {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("failed to decode the batch: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, MaxDecodedSize)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var entry item
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("failed to decode line %d of the batch: %w", line, err)
+			}
+			items = append(items, entry)
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to decode the batch: %w", err)
+		}
+	}
+
+	messages := make([]pipeline.Message, 0, len(items))
+	for i, entry := range items {
+		if entry.Topic == "" {
+			return nil, fmt.Errorf("message %d of the batch has no topic", i+1)
+		}
+
+		payload := []byte(entry.Payload)
+		if entry.Payload == nil {
+			payload = entry.Data
+		}
+
+		messages = append(messages, pipeline.Message{Topic: entry.Topic, Payload: payload, Time: entry.Time})
+	}
+
+	return messages, nil
+}
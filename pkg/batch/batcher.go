@@ -0,0 +1,150 @@
+package batch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+// Config is the configuration of a batcher
+type Config struct {
+	Encoding
+	// MaxMessages flushes a batch when it holds this many messages, zero for no limit
+	MaxMessages int
+	// MaxBytes flushes a batch when its messages take this many bytes before
+	// compression, zero for no limit
+	MaxBytes int
+	// Interval flushes a batch this long after its first message
+	Interval time.Duration
+}
+
+// Validate checks a configuration
+func (c Config) Validate() error {
+	if err := c.Encoding.Validate(); err != nil {
+		return err
+	}
+
+	if c.MaxMessages < 0 {
+		return fmt.Errorf("max messages must not be negative")
+	}
+
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("max bytes must not be negative")
+	}
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	return nil
+}
+
+// Batch is a packed batch of messages
+type Batch struct {
+	Payload  []byte
+	Messages int
+}
+
+// FlushFunc receives each flushed batch, or the error packing it
+type FlushFunc func(batch Batch, err error)
+
+// Batcher collects messages and flushes them as a batch when it is full or
+// its interval has passed. It is safe for concurrent use.
+type Batcher struct {
+	mu      sync.Mutex
+	config  Config
+	onFlush FlushFunc
+	items   [][]byte
+	size    int
+	timer   *time.Timer
+	// generation tells the timer of a flushed batch from that of the current one
+	generation int
+}
+
+// NewBatcher creates a batcher for a valid configuration
+func NewBatcher(config Config, onFlush FlushFunc) (*Batcher, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Batcher{config: config, onFlush: onFlush}, nil
+}
+
+// Add adds a message to the current batch and flushes it when it is full
+func (b *Batcher) Add(message pipeline.Message) error {
+	encoded, err := encodeItem(message)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+
+	b.items = append(b.items, encoded)
+	b.size += len(encoded) + 1
+
+	if len(b.items) == 1 {
+		generation := b.generation
+		b.timer = time.AfterFunc(b.config.Interval, func() { b.flushGeneration(generation) })
+	}
+
+	full := (b.config.MaxMessages > 0 && len(b.items) >= b.config.MaxMessages) ||
+		(b.config.MaxBytes > 0 && b.size >= b.config.MaxBytes)
+	if !full {
+		b.mu.Unlock()
+		return nil
+	}
+
+	items := b.take()
+	b.mu.Unlock()
+
+	b.flush(items)
+	return nil
+}
+
+// Flush flushes the current batch, if it holds any messages
+func (b *Batcher) Flush() {
+	b.mu.Lock()
+	items := b.take()
+	b.mu.Unlock()
+
+	b.flush(items)
+}
+
+// flushGeneration flushes the current batch when its interval has passed
+func (b *Batcher) flushGeneration(generation int) {
+	b.mu.Lock()
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+	items := b.take()
+	b.mu.Unlock()
+
+	b.flush(items)
+}
+
+// take returns the items of the current batch and starts a new one. The
+// caller must hold the lock.
+func (b *Batcher) take() [][]byte {
+	items := b.items
+	b.items, b.size = nil, 0
+	b.generation++
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	return items
+}
+
+func (b *Batcher) flush(items [][]byte) {
+	if len(items) == 0 {
+		return
+	}
+
+	payload, err := pack(b.config.Encoding, items)
+	b.onFlush(Batch{Payload: payload, Messages: len(items)}, err)
+}
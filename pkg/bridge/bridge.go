@@ -0,0 +1,355 @@
+// Package bridge forwards messages to another broker. Routes select the
+// messages by topic, rewrite the topic prefix and set the QoS, and messages
+// that the bridge has forwarded itself are not forwarded again. Prefixes are
+// compared and joined as whole topic levels.
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/batch"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+// DefaultLoopWindow is how long a forwarded message is remembered to
+// recognise it when it comes back
+const DefaultLoopWindow = 30 * time.Second
+
+// Publisher sends messages to the destination broker
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+}
+
+// Route forwards the messages on the topics matching Filter
+type Route struct {
+	Filter string
+	// StripPrefix is removed from the start of the topic, then Prefix is
+	// added. Prefix must be set, since it marks the forwarded messages.
+	StripPrefix string
+	Prefix      string
+	Qos         byte
+	Retain      bool
+}
+
+// Batching packs the forwarded messages into batches, published to Topic
+// followed by the level naming their encoding
+type Batching struct {
+	Topic string
+	Qos   byte
+	batch.Config
+}
+
+// Config is the configuration of a bridge
+type Config struct {
+	Routes []Route
+	// LoopWindow is how long forwarded messages are remembered, DefaultLoopWindow when zero
+	LoopWindow time.Duration
+	// Batching is nil to publish each message on its own
+	Batching *Batching
+}
+
+// Validate checks a configuration
+func (c Config) Validate() error {
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("at least one route is needed")
+	}
+
+	for i, route := range c.Routes {
+		if err := ValidateFilter(route.Filter); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+
+		if strings.Trim(route.Prefix, "/") == "" {
+			return fmt.Errorf("route %d: prefix must not be empty, it marks the forwarded messages so they are not forwarded back", i)
+		}
+
+		if strings.ContainsAny(route.Prefix, "+#") {
+			return fmt.Errorf("route %d: prefix must not contain wildcards", i)
+		}
+
+		if route.Qos > 2 {
+			return fmt.Errorf("route %d: qos must be 0, 1 or 2", i)
+		}
+	}
+
+	if c.LoopWindow < 0 {
+		return fmt.Errorf("loop window must not be negative")
+	}
+
+	if c.Batching != nil {
+		if c.Batching.Topic == "" || strings.ContainsAny(c.Batching.Topic, "+#") {
+			return fmt.Errorf("batch topic must not be empty or contain wildcards")
+		}
+
+		if c.Batching.Qos > 2 {
+			return fmt.Errorf("batch qos must be 0, 1 or 2")
+		}
+
+		if err := c.Batching.Config.Validate(); err != nil {
+			return fmt.Errorf("batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateFilter checks a topic filter
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("filter must not be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level of %q", filter)
+		}
+
+		if level != "+" && level != "#" && strings.ContainsAny(level, "+#") {
+			return fmt.Errorf("wildcards must be a whole level in %q", filter)
+		}
+	}
+
+	return nil
+}
+
+// Stats count the messages handled by the bridge
+type Stats struct {
+	Forwarded int64
+	Failed    int64
+	// Looped are the messages not forwarded because the bridge sent them
+	Looped int64
+	// Batches are the batches published, when batching is on
+	Batches       int64
+	LastForwardAt time.Time
+}
+
+// Bridge forwards messages through a publisher. It is safe for concurrent use.
+type Bridge struct {
+	mu        sync.Mutex
+	routes    []Route
+	publisher Publisher
+	window    time.Duration
+	// forwarded maps the hash of each recently forwarded message to when it was sent
+	forwarded map[uint64]time.Time
+	forgotAt  time.Time
+	stats     Stats
+
+	// batcher collects the forwarded messages when batching is on
+	batcher  *batch.Batcher
+	batching *Batching
+}
+
+// New creates a bridge for a valid configuration
+func New(config Config, publisher Publisher) (*Bridge, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	window := config.LoopWindow
+	if window == 0 {
+		window = DefaultLoopWindow
+	}
+
+	b := &Bridge{
+		routes:    config.Routes,
+		publisher: publisher,
+		window:    window,
+		forwarded: make(map[uint64]time.Time),
+		batching:  config.Batching,
+	}
+
+	if config.Batching != nil {
+		batcher, err := batch.NewBatcher(config.Batching.Config, b.publishBatch)
+		if err != nil {
+			return nil, err
+		}
+		b.batcher = batcher
+	}
+
+	return b, nil
+}
+
+// isEcho reports whether a message is one the bridge forwarded within the
+// loop window, which happens when the destination forwards back to the
+// source with the prefix removed. Messages are remembered by their source
+// topic and payload, so an echo is recognised on the topic it was first
+// received on. The caller must hold the lock.
+func (b *Bridge) isEcho(topic string, payload []byte, at time.Time) bool {
+	sentAt, ok := b.forwarded[messageHash(topic, payload)]
+	return ok && at.Sub(sentAt) <= b.window
+}
+
+// Forward publishes a message with the first route that matches its topic,
+// or adds it to the current batch when batching is on. It returns false when
+// no route matches or the message would loop. A looping message is only
+// not forwarded, it is still a message received from the source.
+func (b *Bridge) Forward(message pipeline.Message) (bool, error) {
+	route, ok := b.route(message.Topic)
+	if !ok {
+		return false, nil
+	}
+
+	at := message.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	// A topic that already carries the prefix came from the destination
+	b.mu.Lock()
+	if HasTopicPrefix(message.Topic, route.Prefix) || b.isEcho(message.Topic, message.Payload, at) {
+		b.stats.Looped++
+		b.mu.Unlock()
+		return false, nil
+	}
+	b.mu.Unlock()
+
+	topic := JoinTopic(route.Prefix, TrimTopicPrefix(message.Topic, route.StripPrefix))
+
+	if b.batcher != nil {
+		if err := b.batcher.Add(pipeline.Message{Topic: topic, Payload: message.Payload, Time: message.Time}); err != nil {
+			b.mu.Lock()
+			b.stats.Failed++
+			b.mu.Unlock()
+			return false, fmt.Errorf("failed to batch %s: %w", topic, err)
+		}
+
+		b.mu.Lock()
+		b.remember(message.Topic, message.Payload, time.Now())
+		b.mu.Unlock()
+		return true, nil
+	}
+
+	err := b.publisher.Publish(topic, route.Qos, route.Retain, message.Payload)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.stats.Failed++
+		return false, fmt.Errorf("failed to forward to %s: %w", topic, err)
+	}
+
+	now := time.Now()
+	b.stats.Forwarded++
+	b.stats.LastForwardAt = now
+	b.remember(message.Topic, message.Payload, now)
+
+	return true, nil
+}
+
+// Close publishes the messages still waiting in the current batch
+func (b *Bridge) Close() {
+	if b.batcher != nil {
+		b.batcher.Flush()
+	}
+}
+
+// publishBatch publishes a flushed batch. The messages of a batch that
+// cannot be packed or published are counted as failed. The messages were
+// remembered when they were added.
+func (b *Bridge) publishBatch(packed batch.Batch, err error) {
+	topic := b.batching.Topic + "/" + b.batching.Encoding.Level()
+	if err == nil {
+		err = b.publisher.Publish(topic, b.batching.Qos, false, packed.Payload)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.stats.Failed += int64(packed.Messages)
+		return
+	}
+
+	now := time.Now()
+	b.stats.Forwarded += int64(packed.Messages)
+	b.stats.Batches++
+	b.stats.LastForwardAt = now
+}
+
+// TakeStats returns the stats since the last call and resets the counters
+func (b *Bridge) TakeStats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.stats
+	b.stats = Stats{}
+	return stats
+}
+
+func (b *Bridge) route(topic string) (Route, bool) {
+	for _, route := range b.routes {
+		if mqttclient.TopicMatches(route.Filter, topic) {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+// remember records a forwarded message by its source topic to recognise it
+// when it comes back. The caller must hold the lock.
+func (b *Bridge) remember(topic string, payload []byte, now time.Time) {
+	b.forwarded[messageHash(topic, payload)] = now
+	b.forget(now)
+}
+
+// forget drops the forwarded messages older than the loop window, at most
+// once a second
+func (b *Bridge) forget(now time.Time) {
+	if now.Sub(b.forgotAt) < time.Second {
+		return
+	}
+	b.forgotAt = now
+
+	for hash, sentAt := range b.forwarded {
+		if now.Sub(sentAt) > b.window {
+			delete(b.forwarded, hash)
+		}
+	}
+}
+
+func messageHash(topic string, payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(topic))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum64()
+}
+
+// HasTopicPrefix reports whether the levels of a topic start with the levels
+// of a prefix, so that prefix bms matches bms/ahu1 but not bmsx/ahu1
+func HasTopicPrefix(topic, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return false
+	}
+
+	return topic == prefix || strings.HasPrefix(topic, prefix+"/")
+}
+
+// TrimTopicPrefix removes the levels of a prefix from the start of a topic.
+// The topic is returned unchanged when it does not start with them.
+func TrimTopicPrefix(topic, prefix string) string {
+	if !HasTopicPrefix(topic, prefix) {
+		return topic
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(topic, strings.TrimSuffix(prefix, "/")), "/")
+}
+
+// JoinTopic adds the levels of a prefix in front of a topic
+func JoinTopic(prefix, topic string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if topic == "" {
+		return prefix
+	}
+
+	return prefix + "/" + topic
+}
@@ -0,0 +1,132 @@
+package bridge_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/bridge"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+type published struct {
+	topic   string
+	payload string
+}
+
+type fakePublisher struct {
+	messages []published
+}
+
+func (p *fakePublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	p.messages = append(p.messages, published{topic: topic, payload: string(payload)})
+	return nil
+}
+
+func newBridge(t *testing.T, publisher bridge.Publisher) *bridge.Bridge {
+	t.Helper()
+
+	b, err := bridge.New(bridge.Config{
+		Routes: []bridge.Route{
+			{Filter: "site/#", StripPrefix: "site/", Prefix: "cloud/plant1/", Qos: 1},
+		},
+		LoopWindow: 30 * time.Second,
+	}, publisher)
+	if err != nil {
+		t.Fatalf("failed to create the bridge: %v", err)
+	}
+
+	return b
+}
+
+func TestForwardDropsEchoes(t *testing.T) {
+	tests := []struct {
+		name string
+		// echo is received after site/ahu1/temp 21.5 was forwarded
+		echo          pipeline.Message
+		wantForwarded bool
+	}{
+		{
+			name:          "back without the prefix",
+			echo:          pipeline.Message{Topic: "site/ahu1/temp", Payload: []byte("21.5")},
+			wantForwarded: false,
+		},
+		{
+			name:          "back after the loop window",
+			echo:          pipeline.Message{Topic: "site/ahu1/temp", Payload: []byte("21.5"), Time: time.Now().Add(time.Minute)},
+			wantForwarded: true,
+		},
+		{
+			name:          "other payload",
+			echo:          pipeline.Message{Topic: "site/ahu1/temp", Payload: []byte("22.0")},
+			wantForwarded: true,
+		},
+		{
+			name:          "other topic",
+			echo:          pipeline.Message{Topic: "site/ahu2/temp", Payload: []byte("21.5")},
+			wantForwarded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &fakePublisher{}
+			b := newBridge(t, publisher)
+
+			ok, err := b.Forward(pipeline.Message{Topic: "site/ahu1/temp", Payload: []byte("21.5")})
+			if err != nil || !ok {
+				t.Fatalf("Forward() = %v, %v, want true, nil", ok, err)
+			}
+
+			if want := (published{topic: "cloud/plant1/ahu1/temp", payload: "21.5"}); publisher.messages[0] != want {
+				t.Fatalf("published %+v, want %+v", publisher.messages[0], want)
+			}
+
+			ok, err = b.Forward(tt.echo)
+			if err != nil {
+				t.Fatalf("Forward() error = %v", err)
+			}
+
+			if ok != tt.wantForwarded {
+				t.Errorf("Forward() = %v, want %v", ok, tt.wantForwarded)
+			}
+
+			wantPublished, wantLooped := 2, int64(0)
+			if !tt.wantForwarded {
+				wantPublished, wantLooped = 1, 1
+			}
+
+			if len(publisher.messages) != wantPublished {
+				t.Errorf("published %d messages, want %d", len(publisher.messages), wantPublished)
+			}
+
+			stats := b.TakeStats()
+			if stats.Looped != wantLooped {
+				t.Errorf("Looped = %d, want %d", stats.Looped, wantLooped)
+			}
+		})
+	}
+}
+
+func TestForwardDropsTopicsWithThePrefix(t *testing.T) {
+	publisher := &fakePublisher{}
+
+	b, err := bridge.New(bridge.Config{
+		Routes: []bridge.Route{{Filter: "#", Prefix: "cloud/plant1"}},
+	}, publisher)
+	if err != nil {
+		t.Fatalf("failed to create the bridge: %v", err)
+	}
+
+	ok, err := b.Forward(pipeline.Message{Topic: "cloud/plant1/ahu1/temp", Payload: []byte("21.5")})
+	if err != nil || ok {
+		t.Fatalf("Forward() = %v, %v, want false, nil", ok, err)
+	}
+
+	if len(publisher.messages) != 0 {
+		t.Errorf("published %d messages, want 0", len(publisher.messages))
+	}
+
+	if stats := b.TakeStats(); stats.Looped != 1 {
+		t.Errorf("Looped = %d, want 1", stats.Looped)
+	}
+}
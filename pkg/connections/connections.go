@@ -0,0 +1,177 @@
+package connections
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventAppStart         = "app_start"
+	EventAppStop          = "app_stop"
+	EventConnect          = "connect"
+	EventDisconnect       = "disconnect"
+	EventReconnectAttempt = "reconnect_attempt"
+	EventBrokerSwitch     = "broker_switch"
+)
+
+// Disconnect reasons recorded by the application itself. Connection losses
+// record the error reported by the client instead.
+const (
+	ReasonShutdown     = "shutdown"
+	ReasonConfigChange = "configuration changed"
+)
+
+// Event is a single entry of the connection history
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Connection is the name of the connection, empty for the application events
+	Connection     string `json:"connection,omitempty"`
+	Broker         string `json:"broker,omitempty"`
+	PreviousBroker string `json:"previous_broker,omitempty"`
+	ClientID       string `json:"client_id,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	Attempt        int    `json:"attempt,omitempty"`
+}
+
+// EventLog appends connection events to a file as JSON lines
+type EventLog struct {
+	mu       sync.Mutex
+	filePath string
+}
+
+func NewEventLog(filePath string) *EventLog {
+	return &EventLog{filePath: filePath}
+}
+
+// Record appends an event to the log. The time is set to now if it is zero.
+func (l *EventLog) Record(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for connection log: %w", err)
+	}
+
+	file, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open connection log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to connection log: %w", err)
+	}
+
+	return nil
+}
+
+// ReadEvents reads all events from a connection log, sorted by time. Lines in
+// the older free-text format ("<RFC 3339 time>: App started") are converted.
+func ReadEvents(filePath string) ([]Event, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(line), &event); err == nil {
+			events = append(events, event)
+			continue
+		}
+
+		if event, ok := parseLegacyEvent(line); ok {
+			events = append(events, event)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	return events, nil
+}
+
+// ForConnection returns the application events and the events of a named
+// connection. Events without a connection name were recorded before there
+// were named connections and belong to defaultName.
+func ForConnection(events []Event, name, defaultName string) []Event {
+	var filtered []Event
+	for _, event := range events {
+		switch event.Type {
+		case EventAppStart, EventAppStop:
+			filtered = append(filtered, event)
+			continue
+		}
+
+		connection := event.Connection
+		if connection == "" {
+			connection = defaultName
+		}
+
+		if connection == name {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
+
+// legacyEventTypes maps the messages of the free-text format to event types
+var legacyEventTypes = map[string]string{
+	"App started":             EventAppStart,
+	"App stopped":             EventAppStop,
+	"MQTT connection started": EventConnect,
+	"MQTT connection stopped": EventDisconnect,
+}
+
+func parseLegacyEvent(line string) (Event, bool) {
+	// The time itself contains colons, so split on the last ": "
+	index := strings.LastIndex(line, ": ")
+	if index < 0 {
+		return Event{}, false
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, line[:index])
+	if err != nil {
+		return Event{}, false
+	}
+
+	eventType, ok := legacyEventTypes[line[index+2:]]
+	if !ok {
+		return Event{}, false
+	}
+
+	return Event{Time: timestamp, Type: eventType}, true
+}
@@ -0,0 +1,190 @@
+package connections
+
+import (
+	"sort"
+	"time"
+)
+
+// Outage is a period in which the client was not connected to the broker
+type Outage struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// DayCount is the number of disconnects on a day
+type DayCount struct {
+	Day         time.Time
+	Disconnects int
+}
+
+// Report summarises the connection history over a window
+type Report struct {
+	From   time.Time
+	To     time.Time
+	Events []Event
+	// Uptime is the time connected to a broker within the window
+	Uptime   time.Duration
+	Downtime time.Duration
+	// Failures counts the disconnects that were not planned, see PlannedDisconnect
+	Failures          int
+	Outages           []Outage
+	DisconnectsPerDay []DayCount
+}
+
+// UptimePercent returns the share of the window in which the client was connected
+func (r *Report) UptimePercent() float64 {
+	total := r.Uptime + r.Downtime
+	if total <= 0 {
+		return 0
+	}
+
+	return float64(r.Uptime) / float64(total) * 100
+}
+
+// MTBF returns the mean time between failures, or 0 if there were none
+func (r *Report) MTBF() time.Duration {
+	if r.Failures == 0 {
+		return 0
+	}
+
+	return r.Uptime / time.Duration(r.Failures)
+}
+
+// LongestOutage returns the longest outage in the window
+func (r *Report) LongestOutage() (Outage, bool) {
+	var longest Outage
+	found := false
+
+	for _, outage := range r.Outages {
+		if !found || outage.Duration() > longest.Duration() {
+			longest = outage
+			found = true
+		}
+	}
+
+	return longest, found
+}
+
+// NewReport builds a report over [from, to] from events sorted by time. The
+// window starts no earlier than the first event, since nothing is known
+// about the time before it.
+func NewReport(events []Event, from, to time.Time) *Report {
+	report := &Report{From: from, To: to}
+
+	if len(events) == 0 {
+		return report
+	}
+
+	if from.Before(events[0].Time) {
+		report.From = events[0].Time
+	}
+
+	// Replay the events before the window to find the state at its start
+	connected := false
+	lastReason := ""
+	index := 0
+	for ; index < len(events) && events[index].Time.Before(report.From); index++ {
+		connected, lastReason = applyEvent(connected, lastReason, events[index])
+	}
+
+	days := map[time.Time]int{}
+	periodStart := report.From
+
+	closePeriod := func(end time.Time) {
+		if end.Before(periodStart) {
+			return
+		}
+
+		if connected {
+			report.Uptime += end.Sub(periodStart)
+		} else {
+			report.Downtime += end.Sub(periodStart)
+			report.Outages = append(report.Outages, Outage{Start: periodStart, End: end, Reason: lastReason})
+		}
+
+		periodStart = end
+	}
+
+	for ; index < len(events) && !events[index].Time.After(report.To); index++ {
+		event := events[index]
+		report.Events = append(report.Events, event)
+
+		if event.Type == EventDisconnect {
+			local := event.Time.Local()
+			day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+			days[day]++
+		}
+
+		if event.Type == EventDisconnect && !PlannedDisconnect(event.Reason) {
+			report.Failures++
+		}
+
+		newConnected, newReason := applyEvent(connected, lastReason, event)
+		if newConnected != connected {
+			closePeriod(event.Time)
+		}
+
+		connected, lastReason = newConnected, newReason
+	}
+
+	closePeriod(report.To)
+
+	// Merge consecutive outage periods, for example a disconnect followed by an app stop
+	report.Outages = mergeOutages(report.Outages)
+
+	for day, count := range days {
+		report.DisconnectsPerDay = append(report.DisconnectsPerDay, DayCount{Day: day, Disconnects: count})
+	}
+
+	sort.Slice(report.DisconnectsPerDay, func(i, j int) bool {
+		return report.DisconnectsPerDay[i].Day.Before(report.DisconnectsPerDay[j].Day)
+	})
+
+	return report
+}
+
+// PlannedDisconnect reports whether a disconnect was caused by the application
+// itself, in which case it does not count as a failure
+func PlannedDisconnect(reason string) bool {
+	return reason == ReasonShutdown || reason == ReasonConfigChange
+}
+
+// applyEvent returns the connection state after an event
+func applyEvent(connected bool, reason string, event Event) (bool, string) {
+	switch event.Type {
+	case EventConnect:
+		return true, ""
+	case EventDisconnect:
+		return false, event.Reason
+	case EventAppStop:
+		return false, "application stopped"
+	case EventAppStart:
+		if connected {
+			// The previous run ended without recording a stop
+			return false, "application restarted"
+		}
+		return false, reason
+	}
+
+	return connected, reason
+}
+
+func mergeOutages(outages []Outage) []Outage {
+	var merged []Outage
+
+	for _, outage := range outages {
+		if len(merged) > 0 && merged[len(merged)-1].End.Equal(outage.Start) {
+			merged[len(merged)-1].End = outage.End
+			continue
+		}
+
+		merged = append(merged, outage)
+	}
+
+	return merged
+}
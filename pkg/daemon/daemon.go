@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// DetachedEnv is set in the environment of a process started by Start
+const DetachedEnv = "BMS_DETACHED"
+
+// pollInterval is how often the PID file and process are checked while waiting
+const pollInterval = 100 * time.Millisecond
+
+// Process is a background process started by Start
+type Process struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	err    error
+}
+
+// IsDetached reports whether the current process was started by Start
+func IsDetached() bool {
+	return os.Getenv(DetachedEnv) == "1"
+}
+
+// Start runs the current executable again in the background with the given
+// arguments. Its standard output and error are appended to outputPath.
+func Start(args []string, outputPath string) (*Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	output, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), DetachedEnv+"=1")
+	cmd.Stdout = output
+	cmd.Stderr = output
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	process := &Process{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		process.err = cmd.Wait()
+		close(process.exited)
+	}()
+
+	return process, nil
+}
+
+// PID returns the process ID
+func (p *Process) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// WaitReady waits until the process has written its PID to the PID file. It
+// fails when the process exits first or the timeout passes.
+func (p *Process) WaitReady(pidPath string, timeout time.Duration) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+	for {
+		if pid, err := ReadPID(pidPath); err == nil && pid == p.PID() {
+			return nil
+		}
+
+		select {
+		case <-p.exited:
+			if p.err != nil {
+				return fmt.Errorf("process exited: %w", p.err)
+			}
+			return errors.New("process exited")
+		case <-deadline:
+			return fmt.Errorf("process did not start within %s", timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitStopped waits until a process has exited. It returns false when the
+// process is still running after the timeout.
+func WaitStopped(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(pollInterval)
+	}
+
+	return true
+}
@@ -0,0 +1,181 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotRunning is returned when no process holds the PID file
+var ErrNotRunning = errors.New("not running")
+
+// AlreadyRunningError is returned when another process holds the PID file
+type AlreadyRunningError struct {
+	PID  int
+	Path string
+}
+
+func (e *AlreadyRunningError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("another instance holds the lock on %s", e.Path)
+	}
+
+	return fmt.Sprintf("already running with PID %d (%s)", e.PID, e.Path)
+}
+
+// PIDFile is a PID file that is locked for as long as the process runs, so
+// two instances cannot share the same working directory
+type PIDFile struct {
+	path string
+	file *os.File
+}
+
+// Acquire locks the PID file and writes the PID of the current process to it.
+// It fails with an AlreadyRunningError when another process holds the lock.
+func Acquire(path string) (*PIDFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := openLocked(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without file locks a PID file is only stale when its process has exited
+	if !lockSupported {
+		if pid, err := ReadPID(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+			file.Close()
+			return nil, &AlreadyRunningError{PID: pid, Path: path}
+		}
+	}
+
+	if err := file.Truncate(0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+
+	if err := file.Sync(); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+
+	return &PIDFile{path: path, file: file}, nil
+}
+
+// openLocked opens and locks the PID file. An instance that releases the file
+// removes it while holding the lock, so a file locked after it was opened may
+// no longer be the one at the path; it is then opened again.
+func openLocked(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lockFile(file); err != nil {
+			file.Close()
+
+			if errors.Is(err, errLocked) {
+				pid, _ := ReadPID(path)
+				return nil, &AlreadyRunningError{PID: pid, Path: path}
+			}
+
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		locked, err := file.Stat()
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return nil, err
+		}
+
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+
+		unlockFile(file)
+		file.Close()
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// Path returns the path of the PID file
+func (p *PIDFile) Path() string {
+	return p.path
+}
+
+// Release removes the PID file and releases the lock
+func (p *PIDFile) Release() error {
+	if p == nil || p.file == nil {
+		return nil
+	}
+
+	// Remove the file while still holding the lock, so a new instance cannot
+	// lock it and have its PID removed
+	removeErr := os.Remove(p.path)
+	unlockFile(p.file)
+	closeErr := p.file.Close()
+	p.file = nil
+
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		return removeErr
+	}
+
+	return closeErr
+}
+
+// ReadPID returns the PID saved in a PID file
+func ReadPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID file %s", path)
+	}
+
+	return pid, nil
+}
+
+// Running returns the PID of the process that holds the PID file. It returns
+// ErrNotRunning when there is no PID file or the process has exited.
+func Running(path string) (int, error) {
+	pid, err := ReadPID(path)
+	if os.IsNotExist(err) {
+		return 0, ErrNotRunning
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !processAlive(pid) {
+		return pid, ErrNotRunning
+	}
+
+	return pid, nil
+}
+
+// Stale reports whether a PID file exists but its process has exited
+func Stale(path string) bool {
+	pid, err := Running(path)
+	return errors.Is(err, ErrNotRunning) && pid != 0
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Windows has no advisory file locks, so Acquire checks the saved PID instead
+const lockSupported = false
+
+var errLocked = errors.New("file is locked")
+
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) {}
+
+func processAlive(pid int) bool {
+	const processQueryLimitedInformation = 0x1000
+	const stillActive = 259
+
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+
+	return code == stillActive
+}
+
+// Terminate is not supported on Windows, use the stop file instead
+func Terminate(pid int) error {
+	return fmt.Errorf("stopping process %d by signal is not supported on windows", pid)
+}
+
+// detach starts the command in a new process group without a console window
+func detach(cmd *exec.Cmd) {
+	const detachedProcess = 0x00000008
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
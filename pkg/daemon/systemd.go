@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// UnitOptions describes the service of a systemd unit file
+type UnitOptions struct {
+	Description      string
+	ExecStart        []string
+	WorkingDirectory string
+	User             string
+	Group            string
+	// StopTimeout is the number of seconds systemd waits for a graceful stop
+	StopTimeout int
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{ .Description }}
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{ .ExecStart }}
+WorkingDirectory={{ .WorkingDirectory }}
+{{- if .User }}
+User={{ .User }}
+{{- end }}
+{{- if .Group }}
+Group={{ .Group }}
+{{- end }}
+Restart=on-failure
+RestartSec=5
+KillSignal=SIGTERM
+TimeoutStopSec={{ .StopTimeout }}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// SystemdUnit returns a systemd unit file that runs the service in the
+// foreground, so systemd supervises it and starts it at boot
+func SystemdUnit(options UnitOptions) (string, error) {
+	if len(options.ExecStart) == 0 {
+		return "", fmt.Errorf("the unit needs a command to start")
+	}
+
+	if options.StopTimeout <= 0 {
+		options.StopTimeout = 30
+	}
+
+	args := make([]string, len(options.ExecStart))
+	for i, arg := range options.ExecStart {
+		args[i] = quoteUnitArg(arg)
+	}
+
+	data := struct {
+		UnitOptions
+		ExecStart string
+	}{options, strings.Join(args, " ")}
+
+	var buf bytes.Buffer
+	if err := unitTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// quoteUnitArg quotes an argument of ExecStart when it contains spaces or quotes
+func quoteUnitArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(arg) + `"`
+}
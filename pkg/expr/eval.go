@@ -0,0 +1,270 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	eval(vars Vars) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(vars Vars) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n variableNode) eval(vars Vars) (interface{}, error) {
+	if vars == nil {
+		return nil, fmt.Errorf("unknown variable %q", n.name)
+	}
+
+	value, ok := vars(n.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", n.name)
+	}
+
+	return Normalize(value), nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n unaryNode) eval(vars Vars) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply ! to %s", typeName(value))
+		}
+		return !b, nil
+	default:
+		f, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply - to %s", typeName(value))
+		}
+		return -f, nil
+	}
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n binaryNode) eval(vars Vars) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || only evaluate the right side when needed
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply %s to %s", n.op, typeName(left))
+		}
+
+		if n.op == "&&" && !l || n.op == "||" && l {
+			return l, nil
+		}
+
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply %s to %s", n.op, typeName(right))
+		}
+
+		return r, nil
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+
+	// + joins strings when either side is a string
+	if n.op == "+" {
+		if ls, ok := left.(string); ok {
+			return ls + toString(right), nil
+		}
+		if rs, ok := right.(string); ok {
+			return toString(left) + rs, nil
+		}
+	}
+
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			switch n.op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, typeName(left), typeName(right))
+	}
+
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type conditionalNode struct {
+	condition node
+	then      node
+	otherwise node
+}
+
+func (n conditionalNode) eval(vars Vars) (interface{}, error) {
+	value, err := n.condition.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not a boolean", typeName(value))
+	}
+
+	if condition {
+		return n.then.eval(vars)
+	}
+
+	return n.otherwise.eval(vars)
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n callNode) eval(vars Vars) (interface{}, error) {
+	// has() checks a variable without failing when it is not defined
+	if n.name == "has" {
+		variable, ok := n.args[0].(variableNode)
+		if !ok {
+			return nil, fmt.Errorf("has expects a variable name")
+		}
+
+		if vars == nil {
+			return false, nil
+		}
+
+		_, defined := vars(variable.name)
+		return defined, nil
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	value, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+
+	return value, nil
+}
+
+// equal compares values of the same type. Values of different types are not equal.
+func equal(left, right interface{}) bool {
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case float64:
+		r, ok := right.(float64)
+		return ok && l == r
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	default:
+		return false
+	}
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,195 @@
+// Package expr is a small, safe expression language for configuration. An
+// expression can only read the variables it is given and call the built-in
+// functions; it has no loops, assignments or access to the system, and its
+// size and nesting are limited.
+//
+// Values are numbers (float64), strings, booleans and nil. Supported are the
+// literals true, false and null, the operators ! - * / % + - < <= > >= == !=
+// && || and the conditional a ? b : c, parentheses, and functions such as
+// abs(x), min(a, b), round(x, 2) and convert(x, "degF", "degC"). Variable
+// names may contain dots, for example payload.temperature.
+package expr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Limits of an expression
+const (
+	MaxLength = 4096
+	MaxDepth  = 64
+)
+
+// Vars resolves a variable name to its value. It returns false when the
+// variable is not defined.
+type Vars func(name string) (interface{}, bool)
+
+// MapVars resolves variables from a map
+func MapVars(values map[string]interface{}) Vars {
+	return func(name string) (interface{}, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+// Expression is a parsed expression that can be evaluated many times
+type Expression struct {
+	source    string
+	root      node
+	variables []string
+}
+
+// Parse parses an expression
+func Parse(source string) (*Expression, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxLength)
+	}
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text, next.pos+1)
+	}
+
+	variables := map[string]bool{}
+	collectVariables(root, variables)
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &Expression{source: source, root: root, variables: names}, nil
+}
+
+// MustParse parses an expression and panics when it is invalid
+func MustParse(source string) *Expression {
+	expression, err := Parse(source)
+	if err != nil {
+		panic(err)
+	}
+
+	return expression
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Variables returns the sorted names of the variables the expression reads
+func (e *Expression) Variables() []string {
+	return e.variables
+}
+
+// Eval evaluates the expression
+func (e *Expression) Eval(vars Vars) (interface{}, error) {
+	return e.root.eval(vars)
+}
+
+// EvalBool evaluates an expression that must result in a boolean
+func (e *Expression) EvalBool(vars Vars) (bool, error) {
+	value, err := e.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q is %s, not a boolean", e.source, typeName(value))
+	}
+
+	return result, nil
+}
+
+// EvalNumber evaluates an expression that must result in a number
+func (e *Expression) EvalNumber(vars Vars) (float64, error) {
+	value, err := e.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	result, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression %q is %s, not a number", e.source, typeName(value))
+	}
+
+	return result, nil
+}
+
+func collectVariables(n node, variables map[string]bool) {
+	switch n := n.(type) {
+	case variableNode:
+		variables[n.name] = true
+	case unaryNode:
+		collectVariables(n.operand, variables)
+	case binaryNode:
+		collectVariables(n.left, variables)
+		collectVariables(n.right, variables)
+	case conditionalNode:
+		collectVariables(n.condition, variables)
+		collectVariables(n.then, variables)
+		collectVariables(n.otherwise, variables)
+	case callNode:
+		for _, arg := range n.args {
+			collectVariables(arg, variables)
+		}
+	}
+}
+
+// Normalize converts a decoded JSON or YAML value to an expression value,
+// turning every number into a float64
+func Normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	default:
+		return value
+	}
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return fmt.Sprintf("a %T", value)
+	}
+}
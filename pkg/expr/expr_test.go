@@ -0,0 +1,190 @@
+package expr_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/expr"
+)
+
+var testVars = expr.MapVars(map[string]interface{}{
+	"temp":             21.5,
+	"setpoint":         20,
+	"fan.on":           true,
+	"mode":             "auto",
+	"payload.pressure": int64(101),
+	"missing":          nil,
+})
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		// Precedence
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"12 / 3 / 2", 2.0},
+		{"7 % 4 * 2", 6.0},
+		{"-2 * 3", -6.0},
+		{"1 + 2 < 4", true},
+		{"1 < 2 == 2 < 3", true},
+		{"true || false && false", true},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"temp > setpoint && fan.on", true},
+		// Conditional
+		{"temp > 25 ? \"hot\" : \"ok\"", "ok"},
+		{"temp > 20 ? \"warm\" : \"cold\"", "warm"},
+		{"false ? 1 : true ? 2 : 3", 2.0},
+		{"true ? 1 : 2 + 10", 1.0},
+		{"temp > 20 || false ? 1 : 0", 1.0},
+		// Values
+		{"payload.pressure", 101.0},
+		{"missing == null", true},
+		{"mode == \"auto\"", true},
+		{"mode + \"-\" + 1", "auto-1"},
+		{"\"a\" < \"b\"", true},
+		{"1 == \"1\"", false},
+		// Short-circuit skips the side that would fail
+		{"false && unknown", false},
+		{"true || unknown", true},
+		{"has(unknown)", false},
+		{"has(temp)", true},
+		// Functions
+		{"abs(-2)", 2.0},
+		{"min(temp, setpoint)", 20.0},
+		{"round(2.345, 2)", 2.35},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expression, err := expr.Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			got, err := expression.Eval(testVars)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		// Type errors
+		{"!temp", "cannot apply ! to a number"},
+		{"-mode", "cannot apply - to a string"},
+		{"temp && true", "cannot apply && to a number"},
+		{"false || 1", "cannot apply || to a number"},
+		{"temp * mode", "cannot apply * to a number and a string"},
+		{"fan.on < 1", "cannot apply < to a boolean and a number"},
+		{"missing + 1", "cannot apply + to null and a number"},
+		{"temp ? 1 : 2", "condition is a number, not a boolean"},
+		{"abs(mode)", "abs:"},
+		// Unknown variables
+		{"unknown", `unknown variable "unknown"`},
+		{"temp > unknown", `unknown variable "unknown"`},
+		{"true ? unknown : 1", `unknown variable "unknown"`},
+		{"min(temp, payload.unknown)", `unknown variable "payload.unknown"`},
+		// Arithmetic
+		{"temp / 0", "division by zero"},
+		{"temp % 0", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expression, err := expr.Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			_, err = expression.Eval(testVars)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Eval() error = %v, want none", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Eval() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvalWithoutVars(t *testing.T) {
+	_, err := expr.MustParse("temp > 1").Eval(nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown variable "temp"`) {
+		t.Errorf("Eval() error = %v, want an unknown variable", err)
+	}
+}
+
+func TestEvalBoolAndNumber(t *testing.T) {
+	if _, err := expr.MustParse("temp").EvalBool(testVars); err == nil || !strings.Contains(err.Error(), "is a number, not a boolean") {
+		t.Errorf("EvalBool() error = %v, want a type error", err)
+	}
+
+	if _, err := expr.MustParse("fan.on").EvalNumber(testVars); err == nil || !strings.Contains(err.Error(), "is a boolean, not a number") {
+		t.Errorf("EvalNumber() error = %v, want a type error", err)
+	}
+
+	got, err := expr.MustParse("temp - setpoint").EvalNumber(testVars)
+	if err != nil || got != 1.5 {
+		t.Errorf("EvalNumber() = %v, %v, want 1.5", got, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{"1 +", ""},
+		{"(1 + 2", ""},
+		{"true ? 1", "':'"},
+		{"1 2", `unexpected "2"`},
+		{"nope(1)", ""},
+		{"abs(1, 2)", ""},
+		{strings.Repeat("(", expr.MaxDepth+1) + "1" + strings.Repeat(")", expr.MaxDepth+1), "nested deeper"},
+		{strings.Repeat("1", expr.MaxLength+1), "longer than"},
+	}
+
+	for _, tt := range tests {
+		name := tt.source
+		if len(name) > 20 {
+			name = name[:20]
+		}
+
+		t.Run(name, func(t *testing.T) {
+			_, err := expr.Parse(tt.source)
+			if err == nil {
+				t.Fatal("Parse() error = nil, want an error")
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	got := expr.MustParse("b > a ? min(c, a) : has(d)").Variables()
+	want := []string{"a", "b", "c", "d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// function is a built-in function. A maxArgs of -1 allows any number of arguments.
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(args []interface{}) (interface{}, error)
+}
+
+func (f function) arity() string {
+	switch {
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("expects %d arguments", f.minArgs)
+	case f.maxArgs < 0:
+		return fmt.Sprintf("expects at least %d arguments", f.minArgs)
+	default:
+		return fmt.Sprintf("expects %d to %d arguments", f.minArgs, f.maxArgs)
+	}
+}
+
+var functions = map[string]function{
+	"abs":     numberFunction(math.Abs),
+	"floor":   numberFunction(math.Floor),
+	"ceil":    numberFunction(math.Ceil),
+	"sqrt":    numberFunction(math.Sqrt),
+	"min":     {minArgs: 1, maxArgs: -1, call: minMax(math.Min)},
+	"max":     {minArgs: 1, maxArgs: -1, call: minMax(math.Max)},
+	"round":   {minArgs: 1, maxArgs: 2, call: round},
+	"convert": {minArgs: 3, maxArgs: 3, call: convert},
+	"has":     {minArgs: 1, maxArgs: 1},
+	"number": {minArgs: 1, maxArgs: 1, call: func(args []interface{}) (interface{}, error) {
+		return toNumber(args[0])
+	}},
+	"string": {minArgs: 1, maxArgs: 1, call: func(args []interface{}) (interface{}, error) {
+		return toString(args[0]), nil
+	}},
+	"lower": stringFunction(strings.ToLower),
+	"upper": stringFunction(strings.ToUpper),
+	"trim":  stringFunction(strings.TrimSpace),
+	"contains": {minArgs: 2, maxArgs: 2, call: func(args []interface{}) (interface{}, error) {
+		s, sub, err := twoStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Contains(s, sub), nil
+	}},
+	"starts_with": {minArgs: 2, maxArgs: 2, call: func(args []interface{}) (interface{}, error) {
+		s, prefix, err := twoStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(s, prefix), nil
+	}},
+	"ends_with": {minArgs: 2, maxArgs: 2, call: func(args []interface{}) (interface{}, error) {
+		s, suffix, err := twoStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(s, suffix), nil
+	}},
+	"coalesce": {minArgs: 1, maxArgs: -1, call: func(args []interface{}) (interface{}, error) {
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+func numberFunction(fn func(float64) float64) function {
+	return function{minArgs: 1, maxArgs: 1, call: func(args []interface{}) (interface{}, error) {
+		x, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("expects a number, got %s", typeName(args[0]))
+		}
+		return fn(x), nil
+	}}
+}
+
+func stringFunction(fn func(string) string) function {
+	return function{minArgs: 1, maxArgs: 1, call: func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expects a string, got %s", typeName(args[0]))
+		}
+		return fn(s), nil
+	}}
+}
+
+func minMax(pick func(a, b float64) float64) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		var result float64
+		for i, arg := range args {
+			x, ok := arg.(float64)
+			if !ok {
+				return nil, fmt.Errorf("expects numbers, got %s", typeName(arg))
+			}
+			if i == 0 {
+				result = x
+			} else {
+				result = pick(result, x)
+			}
+		}
+		return result, nil
+	}
+}
+
+// round rounds to the nearest integer, or to a number of decimals
+func round(args []interface{}) (interface{}, error) {
+	x, ok := args[0].(float64)
+	if !ok {
+		return nil, fmt.Errorf("expects a number, got %s", typeName(args[0]))
+	}
+
+	if len(args) == 1 {
+		return math.Round(x), nil
+	}
+
+	decimals, ok := args[1].(float64)
+	if !ok || decimals < 0 || decimals > 15 {
+		return nil, fmt.Errorf("expects 0 to 15 decimals")
+	}
+
+	scale := math.Pow(10, math.Floor(decimals))
+	return math.Round(x*scale) / scale, nil
+}
+
+func twoStrings(args []interface{}) (string, string, error) {
+	a, aok := args[0].(string)
+	b, bok := args[1].(string)
+	if !aok || !bok {
+		return "", "", fmt.Errorf("expects strings, got %s and %s", typeName(args[0]), typeName(args[1]))
+	}
+	return a, b, nil
+}
+
+// toNumber converts numbers, numeric strings and booleans to a number
+func toNumber(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a number", typeName(value))
+	}
+}
@@ -0,0 +1,147 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenQuestion
+	tokenColon
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!"}
+
+// tokenize splits an expression into tokens
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.' || source[i] == 'e' || source[i] == 'E' ||
+				(source[i] == '-' || source[i] == '+') && (source[i-1] == 'e' || source[i-1] == 'E')) {
+				i++
+			}
+
+			value, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:i], start+1)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: value, pos: start})
+
+		case c == '"' || c == '\'':
+			start := i
+			value, length, err := readString(source[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%s at position %d", err, start+1)
+			}
+			i += length
+			tokens = append(tokens, token{kind: tokenString, text: source[start:i], value: value, pos: start})
+
+		case isNameStart(source[i]):
+			start := i
+			for i < len(source) && (isNameStart(source[i]) || source[i] == '.' || source[i] >= '0' && source[i] <= '9') {
+				i++
+			}
+
+			name := source[start:i]
+			if strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+				return nil, fmt.Errorf("invalid name %q at position %d", name, start+1)
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: name, pos: start})
+
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '?':
+			tokens = append(tokens, token{kind: tokenQuestion, text: "?", pos: i})
+			i++
+		case c == ':':
+			tokens = append(tokens, token{kind: tokenColon, text: ":", pos: i})
+			i++
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// readString reads a quoted string and returns its value and length in the source
+func readString(source string) (string, int, error) {
+	quote := source[0]
+
+	var value strings.Builder
+	for i := 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(source) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			switch source[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(source[i])
+			}
+		default:
+			value.WriteByte(source[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,203 @@
+package expr
+
+import (
+	"fmt"
+)
+
+// binaryPrecedence of the binary operators, higher binds tighter
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// conditionalPrecedence is below every binary operator
+const conditionalPrecedence = 0
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return unexpected(t, text)
+	}
+	return nil
+}
+
+// parseExpression parses operators with at least the given precedence
+func (p *parser) parseExpression(minPrecedence int) (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", MaxDepth)
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+
+		if t.kind == tokenQuestion && minPrecedence <= conditionalPrecedence {
+			p.next()
+
+			then, err := p.parseExpression(conditionalPrecedence)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect(tokenColon, "':'"); err != nil {
+				return nil, err
+			}
+
+			otherwise, err := p.parseExpression(conditionalPrecedence)
+			if err != nil {
+				return nil, err
+			}
+
+			left = conditionalNode{condition: left, then: then, otherwise: otherwise}
+			continue
+		}
+
+		precedence, ok := binaryPrecedence[t.text]
+		if t.kind != tokenOperator || !ok || precedence < minPrecedence {
+			return left, nil
+		}
+
+		p.next()
+
+		right, err := p.parseExpression(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "!" || t.text == "-") {
+		p.next()
+
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > MaxDepth {
+			return nil, fmt.Errorf("expression is nested deeper than %d levels", MaxDepth)
+		}
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return unaryNode{op: t.text, operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return literalNode{value: t.value}, nil
+
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null", "nil":
+			return literalNode{value: nil}, nil
+		}
+
+		if p.peek().kind != tokenLParen {
+			return variableNode{name: t.text}, nil
+		}
+
+		return p.parseCall(t)
+
+	case tokenLParen:
+		inner, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+
+		return inner, nil
+
+	default:
+		return nil, unexpected(t, "a value")
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos+1)
+	}
+
+	p.next() // (
+
+	var args []node
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("function %s at position %d %s", name.text, name.pos+1, fn.arity())
+	}
+
+	return callNode{name: name.text, fn: fn, args: args}, nil
+}
+
+func unexpected(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression, expected %s", expected)
+	}
+
+	return fmt.Errorf("unexpected %q at position %d, expected %s", t.text, t.pos+1, expected)
+}
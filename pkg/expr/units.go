@@ -0,0 +1,85 @@
+package expr
+
+import "fmt"
+
+// unit converts a value to the base unit of its quantity as value*scale+offset
+type unit struct {
+	quantity string
+	scale    float64
+	offset   float64
+}
+
+// units known to convert(). The base units are degC, J, W, Pa, m3 and m3/s.
+var units = map[string]unit{
+	"degC": {quantity: "temperature", scale: 1},
+	"°C":   {quantity: "temperature", scale: 1},
+	"C":    {quantity: "temperature", scale: 1},
+	"degF": {quantity: "temperature", scale: 5.0 / 9, offset: -32 * 5.0 / 9},
+	"°F":   {quantity: "temperature", scale: 5.0 / 9, offset: -32 * 5.0 / 9},
+	"F":    {quantity: "temperature", scale: 5.0 / 9, offset: -32 * 5.0 / 9},
+	"K":    {quantity: "temperature", scale: 1, offset: -273.15},
+
+	"J":   {quantity: "energy", scale: 1},
+	"kJ":  {quantity: "energy", scale: 1e3},
+	"MJ":  {quantity: "energy", scale: 1e6},
+	"Wh":  {quantity: "energy", scale: 3600},
+	"kWh": {quantity: "energy", scale: 3.6e6},
+	"MWh": {quantity: "energy", scale: 3.6e9},
+	"BTU": {quantity: "energy", scale: 1055.05585},
+
+	"W":     {quantity: "power", scale: 1},
+	"kW":    {quantity: "power", scale: 1e3},
+	"MW":    {quantity: "power", scale: 1e6},
+	"BTU/h": {quantity: "power", scale: 0.29307107},
+	"hp":    {quantity: "power", scale: 745.69987},
+
+	"Pa":    {quantity: "pressure", scale: 1},
+	"kPa":   {quantity: "pressure", scale: 1e3},
+	"mbar":  {quantity: "pressure", scale: 100},
+	"bar":   {quantity: "pressure", scale: 1e5},
+	"psi":   {quantity: "pressure", scale: 6894.75729},
+	"inH2O": {quantity: "pressure", scale: 249.08891},
+
+	"m3":  {quantity: "volume", scale: 1},
+	"L":   {quantity: "volume", scale: 1e-3},
+	"gal": {quantity: "volume", scale: 3.785411784e-3},
+	"ft3": {quantity: "volume", scale: 0.028316846592},
+
+	"m3/s":  {quantity: "flow", scale: 1},
+	"m3/h":  {quantity: "flow", scale: 1.0 / 3600},
+	"L/s":   {quantity: "flow", scale: 1e-3},
+	"L/min": {quantity: "flow", scale: 1e-3 / 60},
+	"gpm":   {quantity: "flow", scale: 3.785411784e-3 / 60},
+	"cfm":   {quantity: "flow", scale: 0.028316846592 / 60},
+}
+
+// convert converts a number between units of the same quantity, for example
+// convert(x, "degF", "degC") or convert(x, "Wh", "kWh")
+func convert(args []interface{}) (interface{}, error) {
+	value, ok := args[0].(float64)
+	if !ok {
+		return nil, fmt.Errorf("expects a number, got %s", typeName(args[0]))
+	}
+
+	fromName, toName, err := twoStrings(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	from, ok := units[fromName]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", fromName)
+	}
+
+	to, ok := units[toName]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", toName)
+	}
+
+	if from.quantity != to.quantity {
+		return nil, fmt.Errorf("cannot convert %s (%s) to %s (%s)", fromName, from.quantity, toName, to.quantity)
+	}
+
+	base := value*from.scale + from.offset
+	return (base - to.offset) / to.scale, nil
+}
@@ -0,0 +1,90 @@
+// Package field reads the values at dotted paths of message payloads, like
+// data.temperature, and reports the problems at dotted paths of a stage's
+// configuration.
+package field
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Error is a problem with the value at a dotted path of the configuration
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Errorf returns an Error at a path with a formatted message
+func Errorf(path, format string, args ...interface{}) *Error {
+	return &Error{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// FromPayload takes the value at a dotted path of a JSON payload. Without a
+// path the whole payload is the value; a payload that is not JSON is a string.
+func FromPayload(payload []byte, path string) (interface{}, bool) {
+	var decoded interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		if path != "" {
+			return nil, false
+		}
+		return strings.TrimSpace(string(payload)), true
+	}
+
+	if path == "" {
+		return decoded, true
+	}
+
+	return Lookup(decoded, path)
+}
+
+// Lookup returns the value at a dotted path of a decoded JSON value
+func Lookup(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		value, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// Number converts numbers and numeric strings. NaN and infinite values are
+// not numbers, so they never reach a threshold or an aggregate.
+func Number(value interface{}) (float64, bool) {
+	var number float64
+	switch v := value.(type) {
+	case float64:
+		number = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		number = parsed
+	default:
+		return 0, false
+	}
+
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+
+	return number, true
+}
@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+)
+
+// Config is the configuration of the filter stage
+type Config struct {
+	// DedupWindow drops a payload identical to one passed on the same topic
+	// within the window. Zero disables deduplication.
+	DedupWindow time.Duration
+	// Deadbands are tried in order and the first that matches a topic applies
+	Deadbands []Deadband
+	// RateLimits are tried in order and the first that matches a topic applies
+	RateLimits []RateLimit
+}
+
+// Deadband passes a point only when it changed enough since the last value
+// passed on its topic. When both Absolute and Percent are set, a change must
+// exceed both.
+type Deadband struct {
+	// Match is a topic filter that may contain the + and # wildcards
+	Match string
+	// Field is the dotted path of the value in a JSON payload. When empty the
+	// whole payload is the value.
+	Field string
+	// Absolute is the smallest change that passes
+	Absolute float64
+	// Percent is the smallest change that passes, in percent of the last value
+	Percent float64
+}
+
+// RateLimit passes at most Messages messages per topic in each Interval
+type RateLimit struct {
+	Match    string
+	Messages int
+	Interval time.Duration
+}
+
+// Validate checks a configuration and returns every problem found
+func (c Config) Validate() []*field.Error {
+	var errs []*field.Error
+
+	if c.DedupWindow < 0 {
+		errs = append(errs, field.Errorf("dedup_window", "must not be negative, got %s", c.DedupWindow))
+	}
+
+	for i, deadband := range c.Deadbands {
+		prefix := fmt.Sprintf("deadbands.%d", i)
+
+		if err := checkFilter(deadband.Match); err != nil {
+			errs = append(errs, field.Errorf(prefix+".match", "%s", err))
+		}
+
+		if deadband.Field != "" && !validPath(deadband.Field) {
+			errs = append(errs, field.Errorf(prefix+".field", "must be a dotted field name such as data.temperature, got %q", deadband.Field))
+		}
+
+		switch {
+		case deadband.Absolute < 0:
+			errs = append(errs, field.Errorf(prefix+".absolute", "must not be negative, got %g", deadband.Absolute))
+		case deadband.Percent < 0:
+			errs = append(errs, field.Errorf(prefix+".percent", "must not be negative, got %g", deadband.Percent))
+		case deadband.Absolute == 0 && deadband.Percent == 0:
+			errs = append(errs, field.Errorf(prefix, "a deadband needs absolute, percent or both"))
+		}
+	}
+
+	for i, limit := range c.RateLimits {
+		prefix := fmt.Sprintf("rate_limits.%d", i)
+
+		if err := checkFilter(limit.Match); err != nil {
+			errs = append(errs, field.Errorf(prefix+".match", "%s", err))
+		}
+
+		if limit.Messages < 1 {
+			errs = append(errs, field.Errorf(prefix+".messages", "must be at least 1, got %d", limit.Messages))
+		}
+
+		if limit.Interval <= 0 {
+			errs = append(errs, field.Errorf(prefix+".interval", "must be positive, got %s", limit.Interval))
+		}
+	}
+
+	return errs
+}
+
+// checkFilter checks a topic filter
+func checkFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("must not be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level of %q", filter)
+		}
+
+		if level != "+" && level != "#" && strings.ContainsAny(level, "+#") {
+			return fmt.Errorf("wildcards must be a whole level in %q", filter)
+		}
+	}
+
+	return nil
+}
+
+func validPath(path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,226 @@
+// Package filter drops the messages that carry nothing new: payloads repeated
+// on a topic within a window, values that changed less than a deadband, and
+// messages over the rate limit of their topic.
+package filter
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+// forgetInterval is how often the state of quiet topics is released
+const forgetInterval = time.Minute
+
+// Counts are the number of messages dropped for each reason
+type Counts struct {
+	Duplicates  int64
+	Deadband    int64
+	RateLimited int64
+}
+
+// Filter is the filter stage of the pipeline. It is safe for concurrent use.
+type Filter struct {
+	mu          sync.Mutex
+	dedupWindow time.Duration
+	deadbands   []Deadband
+	rateLimits  []RateLimit
+
+	// passed maps each topic to the hashes of the payloads passed within the
+	// dedup window and when they passed
+	passed map[string]map[uint64]time.Time
+	// last is the last value passed on each topic with a deadband
+	last map[string]float64
+	// windows are the current rate limit windows by topic
+	windows  map[string]*window
+	forgotAt time.Time
+	counts   Counts
+}
+
+// window counts the messages passed on a topic since start
+type window struct {
+	start    time.Time
+	interval time.Duration
+	count    int
+}
+
+// New creates the filter stage for a valid configuration
+func New(config Config) (*Filter, error) {
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	return &Filter{
+		dedupWindow: config.DedupWindow,
+		deadbands:   config.Deadbands,
+		rateLimits:  config.RateLimits,
+		passed:      make(map[string]map[uint64]time.Time),
+		last:        make(map[string]float64),
+		windows:     make(map[string]*window),
+	}, nil
+}
+
+// Name returns the name of the stage
+func (f *Filter) Name() string {
+	return "filter"
+}
+
+// Process passes a message on unless it is a duplicate, within the deadband
+// or over the rate limit of its topic. Only the messages passed on count
+// towards these checks.
+func (f *Filter) Process(message pipeline.Message) ([]pipeline.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	at := message.Time
+	f.forget(at)
+
+	hash := payloadHash(message.Payload)
+	if f.dedupWindow > 0 {
+		if passedAt, ok := f.passed[message.Topic][hash]; ok && at.Sub(passedAt) < f.dedupWindow {
+			f.counts.Duplicates++
+			return nil, nil
+		}
+	}
+
+	value, hasValue := f.deadbandValue(message)
+	if hasValue {
+		if last, ok := f.last[message.Topic]; ok && !f.deadbandFor(message.Topic).exceeded(last, value) {
+			f.counts.Deadband++
+			return nil, nil
+		}
+	}
+
+	if limit, ok := f.rateLimitFor(message.Topic); ok {
+		current := f.windows[message.Topic]
+		if current == nil || at.Sub(current.start) >= limit.Interval {
+			current = &window{start: at, interval: limit.Interval}
+			f.windows[message.Topic] = current
+		}
+
+		if current.count >= limit.Messages {
+			f.counts.RateLimited++
+			return nil, nil
+		}
+		current.count++
+	}
+
+	if f.dedupWindow > 0 {
+		if f.passed[message.Topic] == nil {
+			f.passed[message.Topic] = make(map[uint64]time.Time)
+		}
+		f.passed[message.Topic][hash] = at
+	}
+
+	if hasValue {
+		f.last[message.Topic] = value
+	}
+
+	return []pipeline.Message{message}, nil
+}
+
+// TakeCounts returns the counts since the last call and resets them
+func (f *Filter) TakeCounts() Counts {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := f.counts
+	f.counts = Counts{}
+	return counts
+}
+
+// deadbandFor returns the first deadband matching a topic, or nil
+func (f *Filter) deadbandFor(topic string) *Deadband {
+	for i := range f.deadbands {
+		if mqttclient.TopicMatches(f.deadbands[i].Match, topic) {
+			return &f.deadbands[i]
+		}
+	}
+
+	return nil
+}
+
+// deadbandValue returns the numeric value of a message on a topic with a
+// deadband. Messages without a numeric value are not subject to it.
+func (f *Filter) deadbandValue(message pipeline.Message) (float64, bool) {
+	deadband := f.deadbandFor(message.Topic)
+	if deadband == nil {
+		return 0, false
+	}
+
+	return numericValue(message.Payload, deadband.Field)
+}
+
+func (f *Filter) rateLimitFor(topic string) (RateLimit, bool) {
+	for _, limit := range f.rateLimits {
+		if mqttclient.TopicMatches(limit.Match, topic) {
+			return limit, true
+		}
+	}
+
+	return RateLimit{}, false
+}
+
+// exceeded reports whether a value moved out of the deadband around the last one
+func (d *Deadband) exceeded(last, value float64) bool {
+	change := math.Abs(value - last)
+
+	if d.Absolute > 0 && change <= d.Absolute {
+		return false
+	}
+
+	if d.Percent > 0 && change <= math.Abs(last)*d.Percent/100 {
+		return false
+	}
+
+	return true
+}
+
+// forget releases the payloads and windows that can no longer drop a
+// message, at most once per forgetInterval
+func (f *Filter) forget(now time.Time) {
+	if now.Sub(f.forgotAt) < forgetInterval {
+		return
+	}
+	f.forgotAt = now
+
+	for topic, hashes := range f.passed {
+		for hash, passedAt := range hashes {
+			if now.Sub(passedAt) >= f.dedupWindow {
+				delete(hashes, hash)
+			}
+		}
+
+		if len(hashes) == 0 {
+			delete(f.passed, topic)
+		}
+	}
+
+	for topic, current := range f.windows {
+		if now.Sub(current.start) >= current.interval {
+			delete(f.windows, topic)
+		}
+	}
+}
+
+// numericValue returns the number at a dotted path of a JSON payload, or the
+// payload itself when the path is empty
+func numericValue(payload []byte, path string) (float64, bool) {
+	value, ok := field.FromPayload(payload, path)
+	if !ok {
+		return 0, false
+	}
+
+	return field.Number(value)
+}
+
+func payloadHash(payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(payload)
+	return h.Sum64()
+}
@@ -0,0 +1,276 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultHTTPBatchSize     = 100
+	defaultHTTPFlushInterval = 5 * time.Second
+	// maxHTTPBufferedEntries bounds memory use while the endpoint is unreachable
+	maxHTTPBufferedEntries = 10000
+)
+
+// httpEntry is a single encoded log entry waiting to be pushed
+type httpEntry struct {
+	timestamp time.Time
+	level     zapcore.Level
+	line      string
+}
+
+// httpPusher batches log entries and pushes them to an HTTP endpoint, either
+// in the Loki push format or as newline-delimited JSON
+type httpPusher struct {
+	mu            sync.Mutex
+	url           string
+	format        string
+	labels        map[string]string
+	batchSize     int
+	flushInterval time.Duration
+	entries       []httpEntry
+	client        *http.Client
+	flushChan     chan struct{}
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+func newHTTPPusher(output OutputConfig) *httpPusher {
+	format := strings.ToLower(output.Format)
+	if format == "" {
+		format = FormatLoki
+	}
+
+	batchSize := output.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultHTTPBatchSize
+	}
+
+	flushInterval := output.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultHTTPFlushInterval
+	}
+
+	pusher := &httpPusher{
+		url:           output.URL,
+		format:        format,
+		labels:        output.Labels,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		flushChan:     make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	pusher.wg.Add(1)
+	go pusher.run()
+
+	return pusher
+}
+
+func (p *httpPusher) add(entry httpEntry) {
+	p.mu.Lock()
+	if len(p.entries) >= maxHTTPBufferedEntries {
+		// Drop the oldest entry rather than growing without bounds
+		p.entries = p.entries[1:]
+	}
+	p.entries = append(p.entries, entry)
+	full := len(p.entries) >= p.batchSize
+	p.mu.Unlock()
+
+	if full {
+		select {
+		case p.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *httpPusher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			p.flush()
+			return
+		case <-ticker.C:
+			p.flush()
+		case <-p.flushChan:
+			p.flush()
+		}
+	}
+}
+
+// flush pushes the buffered entries. Entries are kept for the next attempt
+// when the push fails.
+func (p *httpPusher) flush() {
+	p.mu.Lock()
+	entries := p.entries
+	p.entries = nil
+	p.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := p.push(entries); err != nil {
+		// The logger cannot log its own failures, so report them on stderr
+		log.Printf("failed to push %d log entries to %s: %v", len(entries), p.url, err)
+
+		p.mu.Lock()
+		p.entries = append(entries, p.entries...)
+		if len(p.entries) > maxHTTPBufferedEntries {
+			p.entries = p.entries[len(p.entries)-maxHTTPBufferedEntries:]
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *httpPusher) push(entries []httpEntry) error {
+	var body []byte
+	var contentType string
+
+	switch p.format {
+	case FormatLoki:
+		data, err := json.Marshal(p.lokiPayload(entries))
+		if err != nil {
+			return err
+		}
+		body, contentType = data, "application/json"
+	default:
+		var buf bytes.Buffer
+		for _, entry := range entries {
+			buf.WriteString(entry.line)
+			buf.WriteByte('\n')
+		}
+		body, contentType = buf.Bytes(), "application/x-ndjson"
+	}
+
+	resp, err := p.client.Post(p.url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// lokiPayload groups the entries into one stream per level
+func (p *httpPusher) lokiPayload(entries []httpEntry) lokiPushRequest {
+	streams := map[zapcore.Level]*lokiStream{}
+
+	for _, entry := range entries {
+		stream, ok := streams[entry.level]
+		if !ok {
+			labels := map[string]string{"level": entry.level.String()}
+			for key, value := range p.labels {
+				labels[key] = value
+			}
+			stream = &lokiStream{Stream: labels}
+			streams[entry.level] = stream
+		}
+
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.timestamp.UnixNano(), 10), entry.line})
+	}
+
+	request := lokiPushRequest{}
+	for _, stream := range streams {
+		request.Streams = append(request.Streams, *stream)
+	}
+
+	sort.Slice(request.Streams, func(i, j int) bool {
+		return request.Streams[i].Stream["level"] < request.Streams[j].Stream["level"]
+	})
+
+	return request
+}
+
+// Close flushes the remaining entries and stops the pusher
+func (p *httpPusher) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+
+	p.wg.Wait()
+
+	return nil
+}
+
+// httpCore encodes entries and hands them to an httpPusher
+type httpCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	pusher  *httpPusher
+}
+
+func newHTTPCore(encoder zapcore.Encoder, pusher *httpPusher, level zapcore.LevelEnabler) zapcore.Core {
+	return &httpCore{LevelEnabler: level, encoder: encoder, pusher: pusher}
+}
+
+func (c *httpCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	return &httpCore{LevelEnabler: c.LevelEnabler, encoder: encoder, pusher: c.pusher}
+}
+
+func (c *httpCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c *httpCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	c.pusher.add(httpEntry{
+		timestamp: entry.Time,
+		level:     entry.Level,
+		line:      string(trimNewline(buf.Bytes())),
+	})
+
+	return nil
+}
+
+// Sync pushes the buffered entries
+func (c *httpCore) Sync() error {
+	c.pusher.flush()
+	return nil
+}
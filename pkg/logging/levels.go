@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	loggerLevelsMu sync.Mutex
+	loggerLevels   = map[string]*loggerLevel{}
+
+	// outputLevel is the lowest level enabled by any logger. The output cores
+	// use it so a logger with a lower override than the global level still
+	// reaches the outputs.
+	outputLevel = zap.NewAtomicLevel()
+)
+
+// loggerLevel is the level of a named logger. It follows the global level
+// unless it has an override.
+type loggerLevel struct {
+	level      zap.AtomicLevel
+	overridden atomic.Bool
+}
+
+func (l *loggerLevel) Enabled(level zapcore.Level) bool {
+	if l.overridden.Load() {
+		return l.level.Enabled(level)
+	}
+
+	return logLevel.Enabled(level)
+}
+
+// levelFilterCore only passes entries enabled by its level to the wrapped core
+type levelFilterCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func newLevelFilterCore(core zapcore.Core, level zapcore.LevelEnabler) zapcore.Core {
+	return &levelFilterCore{Core: core, level: level}
+}
+
+func (c *levelFilterCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level) && c.Core.Enabled(level)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelFilterCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelFilterCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checked
+	}
+
+	return c.Core.Check(entry, checked)
+}
+
+// getLoggerLevel returns the level of a named logger, creating it if needed
+func getLoggerLevel(name string) *loggerLevel {
+	loggerLevelsMu.Lock()
+	defer loggerLevelsMu.Unlock()
+
+	level, ok := loggerLevels[name]
+	if !ok {
+		level = &loggerLevel{level: zap.NewAtomicLevel()}
+		loggerLevels[name] = level
+	}
+
+	return level
+}
+
+// SetLoggerLevels replaces the per-logger level overrides at runtime. Loggers
+// that are not in the map follow the global level again.
+func SetLoggerLevels(levels map[string]string) error {
+	parsed := make(map[string]zapcore.Level, len(levels))
+	for name, level := range levels {
+		parsedLevel, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid log level for logger %s: %s", name, level)
+		}
+		parsed[name] = parsedLevel
+	}
+
+	for name := range parsed {
+		getLoggerLevel(name)
+	}
+
+	loggerLevelsMu.Lock()
+	for name, level := range loggerLevels {
+		if parsedLevel, ok := parsed[name]; ok {
+			level.level.SetLevel(parsedLevel)
+			level.overridden.Store(true)
+		} else {
+			level.overridden.Store(false)
+		}
+	}
+	loggerLevelsMu.Unlock()
+
+	updateOutputLevel()
+
+	return nil
+}
+
+// updateOutputLevel lowers the output level to the lowest enabled logger level
+func updateOutputLevel() {
+	lowest := logLevel.Level()
+
+	loggerLevelsMu.Lock()
+	for _, level := range loggerLevels {
+		if level.overridden.Load() && level.level.Level() < lowest {
+			lowest = level.level.Level()
+		}
+	}
+	loggerLevelsMu.Unlock()
+
+	outputLevel.SetLevel(lowest)
+}
+
+// setGlobalLevel sets the global level and keeps the output level in sync
+func setGlobalLevel(level zapcore.Level) {
+	logLevel.SetLevel(level)
+	updateOutputLevel()
+}
@@ -2,21 +2,22 @@ package logging
 
 import (
 	"fmt"
+	"io"
 	"log"
-	"os"
-	"runtime/debug"
 	"strings"
+	"time"
 
-	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 var (
 	logger        *zap.Logger
+	baseLogger    *zap.Logger
 	logLevel      = zap.NewAtomicLevel()
 	loggingLogger *zap.Logger
 	loggerConfig  *LoggingConfig
+	rootCore      *reloadableCore
 )
 
 type LoggingConfig struct {
@@ -28,10 +29,25 @@ type LoggingConfig struct {
 	Compress   bool   `mapstructure:"compress"`
 	DebugMode  bool   `mapstructure:"debug_mode"`
 	AddTime    bool   `mapstructure:"add_time"`
+	ForceLevel bool   `mapstructure:"force_level"`
+	// Loggers overrides the level of named loggers, for example "mqtt": "debug"
+	Loggers  map[string]string `mapstructure:"loggers"`
+	Sampling SamplingConfig    `mapstructure:"sampling"`
+	// Outputs lists where the logs are written. DefaultOutputs is used when empty.
+	Outputs []OutputConfig `mapstructure:"outputs"`
+}
+
+// SamplingConfig limits repeated log entries. Within each Tick, the first
+// Initial entries with the same level and message are logged, and after that
+// only every Thereafter-th entry. Sampling is disabled when Initial is 0.
+type SamplingConfig struct {
+	Initial    int           `mapstructure:"initial"`
+	Thereafter int           `mapstructure:"thereafter"`
+	Tick       time.Duration `mapstructure:"tick"`
 }
 
 // NewLoggingConfig creates a new logging configuration.
-func NewLoggingConfig(level, filePath string, maxSize, maxBackups, maxAge int, compress, debugMode, addTime bool) *LoggingConfig {
+func NewLoggingConfig(level, filePath string, maxSize, maxBackups, maxAge int, compress, debugMode, addTime, forceLevel bool) *LoggingConfig {
 	return &LoggingConfig{
 		Level:      level,
 		FilePath:   filePath,
@@ -41,30 +57,90 @@ func NewLoggingConfig(level, filePath string, maxSize, maxBackups, maxAge int, c
 		Compress:   compress,
 		DebugMode:  debugMode,
 		AddTime:    addTime,
+		ForceLevel: forceLevel,
 	}
 }
 
+// GetLogger returns a named logger. Its level follows the global level unless
+// the name has an override in LoggingConfig.Loggers.
 func GetLogger(name string) *zap.Logger {
 	if logger == nil {
 		log.Fatal("logger is not initialized")
 	}
 
-	return logger.Named(name)
+	level := getLoggerLevel(name)
+
+	return baseLogger.Named(name).WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newLevelFilterCore(core, level)
+	}))
 }
 
 // NewLogger initializes a zap.Logger instance if it has not been initialized
 // already and returns the same instance for subsequent calls.
 func NewLogger(cfg *LoggingConfig) *zap.Logger {
-	stdout := zapcore.AddSync(os.Stdout)
+	setGlobalLevel(levelFromConfig(cfg))
+	if err := SetLoggerLevels(cfg.Loggers); err != nil {
+		log.Println(err)
+	}
+
+	core, closers, err := buildCore(cfg)
+	if err != nil {
+		// Fall back to the default outputs rather than running without logs
+		log.Println(fmt.Errorf("invalid log outputs, using the defaults: %w", err))
+
+		defaults := *cfg
+		defaults.Outputs = nil
+		core, closers, _ = buildCore(&defaults)
+	}
+
+	rootCore = newReloadableCore(core)
+	outputClosers = closers
+
+	// The outputs are enabled for the lowest level of any logger, so every
+	// logger filters on its own level
+	baseLogger = zap.New(rootCore)
+	logger = baseLogger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newLevelFilterCore(core, logLevel)
+	}))
+
+	loggerConfig = cfg
+	loggingLogger = logger.Named("main")
+
+	return logger
+}
+
+// Reload rebuilds the logger outputs from a new configuration at runtime. Log
+// files are swapped without losing log lines, and loggers returned by
+// GetLogger before the reload write to the new outputs.
+func Reload(cfg *LoggingConfig) error {
+	if rootCore == nil {
+		return fmt.Errorf("logger is not initialized")
+	}
+
+	if err := SetLoggerLevels(cfg.Loggers); err != nil {
+		return err
+	}
+
+	core, closers, err := buildCore(cfg)
+	if err != nil {
+		return err
+	}
 
-	file := zapcore.AddSync(&lumberjack.Logger{
-		Filename:   cfg.FilePath,
-		MaxSize:    cfg.MaxSize,
-		MaxBackups: cfg.MaxBackups,
-		MaxAge:     cfg.MaxAge,
-		Compress:   cfg.Compress,
-	})
+	rootCore.root.store(core)
+	setGlobalLevel(levelFromConfig(cfg))
 
+	// Release the outputs that were replaced, after the new core is in place
+	closeOutputs(outputClosers)
+	outputClosers = closers
+
+	loggerConfig = cfg
+
+	return nil
+}
+
+// levelFromConfig returns the log level to use. Debug mode forces the debug
+// level unless the configured level is forced.
+func levelFromConfig(cfg *LoggingConfig) zapcore.Level {
 	level := zap.InfoLevel
 
 	if cfg.DebugMode {
@@ -72,7 +148,7 @@ func NewLogger(cfg *LoggingConfig) *zap.Logger {
 	}
 
 	cfgLevel := cfg.Level
-	if cfgLevel != "" && !cfg.DebugMode {
+	if cfgLevel != "" && (!cfg.DebugMode || cfg.ForceLevel) {
 		levelFromEnv, err := zapcore.ParseLevel(cfgLevel)
 		if err != nil {
 			log.Println(
@@ -83,55 +159,35 @@ func NewLogger(cfg *LoggingConfig) *zap.Logger {
 		level = levelFromEnv
 	}
 
-	logLevel.SetLevel(level)
-
-	productionCfg := zap.NewProductionEncoderConfig()
-
-	developmentCfg := zap.NewDevelopmentEncoderConfig()
-	developmentCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	return level
+}
 
-	if cfg.AddTime {
-		productionCfg.TimeKey = "timestamp"
-		productionCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	} else {
-		productionCfg.TimeKey = ""  // Remove timestamp
-		developmentCfg.TimeKey = "" // Remove timestamp
+// buildCore builds the cores of all outputs and applies sampling
+func buildCore(cfg *LoggingConfig) (zapcore.Core, []io.Closer, error) {
+	core, closers, err := buildOutputs(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	fileEncoder := zapcore.NewJSONEncoder(productionCfg)
-	consoleEncoder := zapcore.NewConsoleEncoder(developmentCfg)
-
-	var gitRevision string
-
-	buildInfo, ok := debug.ReadBuildInfo()
-	if ok {
-		for _, v := range buildInfo.Settings {
-			if v.Key == "vcs.revision" {
-				gitRevision = v.Value
-				break
-			}
+	if cfg.Sampling.Initial > 0 {
+		tick := cfg.Sampling.Tick
+		if tick <= 0 {
+			tick = time.Second
 		}
+
+		core = zapcore.NewSamplerWithOptions(core, tick, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
 	}
 
-	// log to multiple destinations (console and file)
-	// extra fields are added to the JSON output alone
-	core := zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, stdout, logLevel),
-		zapcore.NewCore(fileEncoder, file, logLevel).
-			With(
-				[]zapcore.Field{
-					zap.String("git_revision", gitRevision),
-					zap.String("go_version", buildInfo.GoVersion),
-				},
-			),
-	)
-
-	logger = zap.New(core)
+	return core, closers, nil
+}
 
-	loggerConfig = cfg
-	loggingLogger = logger.Named("main")
+// Sync flushes the buffered entries of every output
+func Sync() error {
+	if logger == nil {
+		return nil
+	}
 
-	return logger
+	return logger.Sync()
 }
 
 func IsValidLogLevel(level string) bool {
@@ -146,10 +202,15 @@ func IsValidLogLevel(level string) bool {
 // SetLogLevel dynamically updates the log level at runtime
 func SetLogLevel(level string) error {
 	if loggerConfig.DebugMode {
-		loggingLogger.Warn("Debug mode is enabled. Log level cannot be changed at runtime.")
+		loggingLogger.Warn("Debug mode is enabled. Log level cannot be changed at runtime. Use ForceLogLevel to override it.")
 		return nil
 	}
 
+	return ForceLogLevel(level)
+}
+
+// ForceLogLevel updates the log level at runtime, even when debug mode is enabled
+func ForceLogLevel(level string) error {
 	parsedLevel, err := zapcore.ParseLevel(level)
 	if err != nil {
 		return fmt.Errorf("invalid log level: %s", level)
@@ -157,6 +218,6 @@ func SetLogLevel(level string) error {
 
 	// oldLevel := logLevel.Level()
 	// loggingLogger.Debug("Changing log level", zap.String("old_level", oldLevel.String()), zap.String("new_level", parsedLevel.String()))
-	logLevel.SetLevel(parsedLevel)
+	setGlobalLevel(parsedLevel)
 	return nil
 }
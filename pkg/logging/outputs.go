@@ -0,0 +1,293 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natefinch/lumberjack"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Output types
+const (
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+	OutputHTTP   = "http"
+)
+
+// Output formats
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+	FormatLoki    = "loki"
+)
+
+// OutputConfig is the configuration of a single log output
+type OutputConfig struct {
+	Type   string `mapstructure:"type"`
+	Format string `mapstructure:"format"`
+	// Level is the lowest level written to this output. When empty the output
+	// writes everything the loggers let through.
+	Level string `mapstructure:"level"`
+	// Path is the log file of a file output. It defaults to LoggingConfig.FilePath.
+	Path string `mapstructure:"path"`
+	// Network and Address locate the syslog server, for example "udp" and
+	// "localhost:514", or "unix" and "/dev/log"
+	Network string `mapstructure:"network"`
+	Address string `mapstructure:"address"`
+	Tag     string `mapstructure:"tag"`
+	// URL, Labels, BatchSize and FlushInterval configure an HTTP push output
+	URL           string            `mapstructure:"url"`
+	Labels        map[string]string `mapstructure:"labels"`
+	BatchSize     int               `mapstructure:"batch_size"`
+	FlushInterval time.Duration     `mapstructure:"flush_interval"`
+}
+
+// DefaultOutputs are used when no outputs are configured: coloured console
+// output on stdout and JSON in the log file
+var DefaultOutputs = []OutputConfig{
+	{Type: OutputStdout, Format: FormatConsole},
+	{Type: OutputFile, Format: FormatJSON},
+}
+
+var (
+	outputsMu sync.Mutex
+	// fileWriters holds one writer per log file so a reload keeps the file open
+	fileWriters = map[string]*reloadableWriter{}
+	// outputClosers are closed when the outputs they belong to are replaced
+	outputClosers []io.Closer
+)
+
+// outputs returns the configured outputs or the defaults
+func (cfg *LoggingConfig) outputs() []OutputConfig {
+	if len(cfg.Outputs) == 0 {
+		return DefaultOutputs
+	}
+
+	return cfg.Outputs
+}
+
+// FilePaths returns the paths of every file output
+func (cfg *LoggingConfig) FilePaths() []string {
+	var paths []string
+	for _, output := range cfg.outputs() {
+		if output.Type == OutputFile {
+			paths = append(paths, cfg.outputFilePath(output))
+		}
+	}
+
+	return paths
+}
+
+func (cfg *LoggingConfig) outputFilePath(output OutputConfig) string {
+	if output.Path != "" {
+		return output.Path
+	}
+
+	return cfg.FilePath
+}
+
+// buildOutputs builds the cores of all outputs. The returned closers release
+// the resources of the outputs once they are no longer in use. The shared file
+// writers are only changed once every output has been built, so a failed
+// build leaves the current outputs as they are.
+func buildOutputs(cfg *LoggingConfig) (zapcore.Core, []io.Closer, error) {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+
+	var cores []zapcore.Core
+	var closers []io.Closer
+	files := map[string]*fileOutput{}
+
+	fail := func(err error) (zapcore.Core, []io.Closer, error) {
+		for _, file := range files {
+			file.rotation.Close()
+		}
+		closeOutputs(closers)
+		return nil, nil, err
+	}
+
+	for i, output := range cfg.outputs() {
+		level, err := outputLevelEnabler(output)
+		if err != nil {
+			return fail(fmt.Errorf("output %d: %w", i, err))
+		}
+
+		encoder, err := newOutputEncoder(cfg, output)
+		if err != nil {
+			return fail(fmt.Errorf("output %d: %w", i, err))
+		}
+
+		switch output.Type {
+		case OutputStdout:
+			cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), level))
+		case OutputFile:
+			path := cfg.outputFilePath(output)
+			file, ok := files[path]
+			if !ok {
+				file = fileOutputFor(cfg, path)
+				files[path] = file
+			}
+			cores = append(cores, zapcore.NewCore(encoder, file.writer, level))
+		case OutputSyslog:
+			writer := newSyslogWriter(output.Network, output.Address, output.Tag)
+			closers = append(closers, writer)
+			cores = append(cores, newSyslogCore(encoder, writer, level))
+		case OutputHTTP:
+			pusher := newHTTPPusher(output)
+			closers = append(closers, pusher)
+			cores = append(cores, newHTTPCore(encoder, pusher, level))
+		default:
+			return fail(fmt.Errorf("output %d: unknown output type %q", i, output.Type))
+		}
+	}
+
+	for path, file := range files {
+		file.apply(path)
+	}
+
+	// Close the files that are no longer written to
+	for path, writer := range fileWriters {
+		if files[path] == nil {
+			closers = append(closers, writer)
+			delete(fileWriters, path)
+		}
+	}
+
+	return zapcore.NewTee(cores...), closers, nil
+}
+
+// fileOutput is the writer of a log file while the outputs are built, with the
+// lumberjack logger it gets once the build succeeded
+type fileOutput struct {
+	writer   *reloadableWriter
+	rotation *lumberjack.Logger
+	// added is set for a writer that is not in fileWriters yet
+	added bool
+}
+
+// fileOutputFor returns the writer of a log file. A file that is already open
+// keeps its writer, which is given a new lumberjack logger when the rotation
+// settings changed. The caller must hold outputsMu.
+func fileOutputFor(cfg *LoggingConfig, path string) *fileOutput {
+	rotation := newLumberjackLogger(cfg, path)
+
+	writer, ok := fileWriters[path]
+	if !ok {
+		return &fileOutput{writer: newReloadableWriter(rotation), rotation: rotation, added: true}
+	}
+
+	return &fileOutput{writer: writer, rotation: rotation}
+}
+
+// apply adds a new writer to fileWriters, or swaps the lumberjack logger of an
+// existing one when the rotation settings changed. The caller must hold outputsMu.
+func (f *fileOutput) apply(path string) {
+	if f.added {
+		fileWriters[path] = f.writer
+		return
+	}
+
+	if f.writer.rotationChanged(f.rotation) {
+		f.writer.swap(f.rotation)
+	}
+}
+
+func newLumberjackLogger(cfg *LoggingConfig, path string) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		Compress:   cfg.Compress,
+	}
+}
+
+// outputLevelEnabler returns the level of an output. Outputs without a level
+// follow the lowest level of any logger.
+func outputLevelEnabler(output OutputConfig) (zapcore.LevelEnabler, error) {
+	if output.Level == "" {
+		return outputLevel, nil
+	}
+
+	level, err := zapcore.ParseLevel(output.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %s", output.Level)
+	}
+
+	return zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return l >= level && outputLevel.Enabled(l)
+	}), nil
+}
+
+// newOutputEncoder returns the encoder of an output. JSON encoders include the
+// git revision and Go version.
+func newOutputEncoder(cfg *LoggingConfig, output OutputConfig) (zapcore.Encoder, error) {
+	format := strings.ToLower(output.Format)
+	if format == "" {
+		format = FormatJSON
+		if output.Type == OutputStdout {
+			format = FormatConsole
+		}
+	}
+
+	switch format {
+	case FormatConsole:
+		developmentCfg := zap.NewDevelopmentEncoderConfig()
+		if output.Type == OutputStdout {
+			developmentCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+		if !cfg.AddTime {
+			developmentCfg.TimeKey = "" // Remove timestamp
+		}
+
+		return zapcore.NewConsoleEncoder(developmentCfg), nil
+	case FormatJSON, FormatLoki:
+		productionCfg := zap.NewProductionEncoderConfig()
+		if cfg.AddTime {
+			productionCfg.TimeKey = "timestamp"
+			productionCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		} else {
+			productionCfg.TimeKey = "" // Remove timestamp
+		}
+
+		encoder := zapcore.NewJSONEncoder(productionCfg)
+		gitRevision, goVersion := buildVersion()
+		encoder.AddString("git_revision", gitRevision)
+		encoder.AddString("go_version", goVersion)
+
+		return encoder, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", output.Format)
+	}
+}
+
+func buildVersion() (gitRevision, goVersion string) {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+
+	for _, v := range buildInfo.Settings {
+		if v.Key == "vcs.revision" {
+			gitRevision = v.Value
+			break
+		}
+	}
+
+	return gitRevision, buildInfo.GoVersion
+}
+
+// closeOutputs closes the resources of replaced outputs
+func closeOutputs(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
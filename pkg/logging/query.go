@@ -0,0 +1,271 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// backupTimeFormat is the timestamp lumberjack adds to rotated log files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// LogEntry is a single entry of a JSON log file
+type LogEntry struct {
+	Time   time.Time
+	Level  zapcore.Level
+	Logger string
+	Caller string
+	Msg    string
+	Fields map[string]interface{}
+	Raw    string
+}
+
+// LogFilter selects log entries. Zero values match everything.
+type LogFilter struct {
+	MinLevel *zapcore.Level
+	Logger   string
+	Since    time.Time
+	Until    time.Time
+	// Fields must all match, compared as strings, for example "topic": "bms/x"
+	Fields map[string]string
+}
+
+// Match reports whether an entry passes the filter
+func (f LogFilter) Match(entry LogEntry) bool {
+	if f.MinLevel != nil && entry.Level < *f.MinLevel {
+		return false
+	}
+
+	if f.Logger != "" && entry.Logger != f.Logger && !strings.HasPrefix(entry.Logger, f.Logger+".") {
+		return false
+	}
+
+	if !f.Since.IsZero() && (entry.Time.IsZero() || entry.Time.Before(f.Since)) {
+		return false
+	}
+
+	if !f.Until.IsZero() && (entry.Time.IsZero() || entry.Time.After(f.Until)) {
+		return false
+	}
+
+	for key, value := range f.Fields {
+		fieldValue, ok := entry.Fields[key]
+		if !ok || fmt.Sprint(fieldValue) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseLogEntry parses a single line of a JSON log file
+func ParseLogEntry(line string) (LogEntry, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return LogEntry{}, err
+	}
+
+	entry := LogEntry{Fields: fields, Raw: line}
+
+	if level, ok := fields["level"].(string); ok {
+		if parsed, err := zapcore.ParseLevel(level); err == nil {
+			entry.Level = parsed
+		}
+	}
+
+	for _, key := range []string{"timestamp", "ts"} {
+		switch value := fields[key].(type) {
+		case string:
+			if parsed, err := time.Parse("2006-01-02T15:04:05.000Z0700", value); err == nil {
+				entry.Time = parsed
+			} else if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				entry.Time = parsed
+			}
+		case float64:
+			entry.Time = time.Unix(0, int64(value*float64(time.Second)))
+		}
+		delete(fields, key)
+	}
+
+	entry.Logger, _ = fields["logger"].(string)
+	entry.Caller, _ = fields["caller"].(string)
+	entry.Msg, _ = fields["msg"].(string)
+
+	for _, key := range []string{"level", "logger", "caller", "msg"} {
+		delete(fields, key)
+	}
+
+	return entry, nil
+}
+
+// LogFiles returns the rotated backups of a log file, oldest first, followed
+// by the log file itself. Compressed backups are included.
+func LogFiles(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type backup struct {
+		path      string
+		timestamp time.Time
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		stamp = strings.TrimPrefix(stamp, prefix)
+
+		timestamp, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, backup{path: filepath.Join(dir, name), timestamp: timestamp})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].timestamp.Before(backups[j].timestamp)
+	})
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+
+	return files, nil
+}
+
+// ReadLogFile calls fn for every entry of a log file that matches the filter.
+// Gzip compressed files are decompressed transparently and lines that are not
+// JSON are skipped.
+func ReadLogFile(path string, filter LogFilter, fn func(LogEntry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	return scanLogEntries(reader, filter, fn)
+}
+
+func scanLogEntries(reader io.Reader, filter LogFilter, fn func(LogEntry)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		entry, err := ParseLogEntry(scanner.Text())
+		if err != nil {
+			continue
+		}
+
+		if filter.Match(entry) {
+			fn(entry)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// FollowLogFile calls fn for every new matching entry appended to a log file
+// until the context is done. Rotation is detected when the file shrinks or is
+// replaced, after which the new file is read from the start.
+func FollowLogFile(ctx context.Context, path string, filter LogFilter, interval time.Duration, fn func(LogEntry)) error {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	var lastInfo os.FileInfo
+	var partial string
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file is being rotated or was not created yet
+			continue
+		}
+
+		if info.Size() < offset || (lastInfo != nil && !os.SameFile(lastInfo, info)) {
+			offset = 0
+			partial = ""
+		}
+		lastInfo = info
+
+		if info.Size() == offset {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return err
+		}
+
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+
+		// Keep an incomplete last line until the rest of it is written
+		text := partial + string(data)
+		lastNewline := strings.LastIndexByte(text, '\n')
+		if lastNewline < 0 {
+			partial = text
+			continue
+		}
+		partial = text[lastNewline+1:]
+
+		if err := scanLogEntries(strings.NewReader(text[:lastNewline+1]), filter, fn); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/natefinch/lumberjack"
+	"go.uber.org/zap/zapcore"
+)
+
+// reloadableWriter is a file writer whose underlying lumberjack logger can be
+// swapped at runtime. Writes are serialized with the swap, so no log line is
+// lost or written to a closed file.
+type reloadableWriter struct {
+	mu     sync.Mutex
+	logger *lumberjack.Logger
+}
+
+func newReloadableWriter(logger *lumberjack.Logger) *reloadableWriter {
+	return &reloadableWriter{logger: logger}
+}
+
+func (w *reloadableWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.logger.Write(p)
+}
+
+// Sync is a no-op since lumberjack writes straight to the file
+func (w *reloadableWriter) Sync() error {
+	return nil
+}
+
+// Close closes the log file
+func (w *reloadableWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.logger.Close()
+}
+
+// rotationChanged reports whether the settings of a new lumberjack logger differ
+func (w *reloadableWriter) rotationChanged(logger *lumberjack.Logger) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.logger.Filename != logger.Filename ||
+		w.logger.MaxSize != logger.MaxSize ||
+		w.logger.MaxBackups != logger.MaxBackups ||
+		w.logger.MaxAge != logger.MaxAge ||
+		w.logger.Compress != logger.Compress
+}
+
+// swap replaces the underlying lumberjack logger and closes the previous one
+func (w *reloadableWriter) swap(logger *lumberjack.Logger) error {
+	w.mu.Lock()
+	old := w.logger
+	w.logger = logger
+	w.mu.Unlock()
+
+	return old.Close()
+}
+
+// coreGeneration is one version of the logger core
+type coreGeneration struct {
+	id   uint64
+	core zapcore.Core
+}
+
+// reloadableCoreRoot holds the current core shared by every derived logger
+type reloadableCoreRoot struct {
+	current atomic.Pointer[coreGeneration]
+}
+
+func (r *reloadableCoreRoot) store(core zapcore.Core) {
+	var id uint64
+	if current := r.current.Load(); current != nil {
+		id = current.id + 1
+	}
+
+	r.current.Store(&coreGeneration{id: id, core: core})
+}
+
+// reloadableCore is a zapcore.Core that delegates to the current core of its
+// root, so loggers that were created before a reload write to the new outputs.
+type reloadableCore struct {
+	root    *reloadableCoreRoot
+	fields  []zapcore.Field
+	derived atomic.Pointer[coreGeneration]
+}
+
+func newReloadableCore(core zapcore.Core) *reloadableCore {
+	root := &reloadableCoreRoot{}
+	root.store(core)
+
+	return &reloadableCore{root: root}
+}
+
+// core returns the current core with the fields of this logger added. The
+// result is cached until the next reload.
+func (c *reloadableCore) core() zapcore.Core {
+	current := c.root.current.Load()
+	if len(c.fields) == 0 {
+		return current.core
+	}
+
+	if derived := c.derived.Load(); derived != nil && derived.id == current.id {
+		return derived.core
+	}
+
+	derived := &coreGeneration{id: current.id, core: current.core.With(c.fields)}
+	c.derived.Store(derived)
+
+	return derived.core
+}
+
+func (c *reloadableCore) Enabled(level zapcore.Level) bool {
+	return c.core().Enabled(level)
+}
+
+func (c *reloadableCore) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	combined = append(combined, c.fields...)
+	combined = append(combined, fields...)
+
+	return &reloadableCore{root: c.root, fields: combined}
+}
+
+func (c *reloadableCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(entry, checked)
+}
+
+func (c *reloadableCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(entry, fields)
+}
+
+func (c *reloadableCore) Sync() error {
+	return c.core().Sync()
+}
@@ -0,0 +1,207 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogFacility is the local0 facility
+const syslogFacility = 16
+
+// syslogWriter sends RFC 5424 messages to a syslog server over UDP, TCP or a
+// unix socket. It is implemented on top of net so it also works on Windows.
+type syslogWriter struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	tag      string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogWriter(network, address, tag string) *syslogWriter {
+	if network == "" {
+		network = "udp"
+	}
+
+	if address == "" {
+		address = "localhost:514"
+		if network == "unix" || network == "unixgram" {
+			address = "/dev/log"
+		}
+	}
+
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &syslogWriter{
+		network:  network,
+		address:  address,
+		tag:      tag,
+		hostname: hostname,
+	}
+}
+
+// connect opens the connection. A "unix" network tries a datagram socket first
+// since that is what most local syslog daemons listen on.
+func (w *syslogWriter) connect() error {
+	if w.network == "unix" {
+		conn, err := net.Dial("unixgram", w.address)
+		if err == nil {
+			w.conn = conn
+			return nil
+		}
+	}
+
+	conn, err := net.DialTimeout(w.network, w.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	return nil
+}
+
+// write sends a message, reconnecting once if the connection was lost
+func (w *syslogWriter) write(severity int, timestamp time.Time, message []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err = w.connect(); err != nil {
+				continue
+			}
+		}
+
+		if err = w.send(severity, timestamp, message); err == nil {
+			return nil
+		}
+
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return fmt.Errorf("failed to write to syslog %s://%s: %w", w.network, w.address, err)
+}
+
+func (w *syslogWriter) send(severity int, timestamp time.Time, message []byte) error {
+	frame := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		syslogFacility*8+severity,
+		timestamp.Format(time.RFC3339Nano),
+		nilValue(w.hostname),
+		nilValue(w.tag),
+		os.Getpid(),
+		trimNewline(message),
+	)
+
+	// Stream transports need octet counting to separate the messages
+	if w.network == "tcp" || w.network == "tcp4" || w.network == "tcp6" {
+		frame = fmt.Sprintf("%d %s", len(frame), frame)
+	}
+
+	_, err := w.conn.Write([]byte(frame))
+	return err
+}
+
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+func nilValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+func trimNewline(message []byte) []byte {
+	for len(message) > 0 && (message[len(message)-1] == '\n' || message[len(message)-1] == '\r') {
+		message = message[:len(message)-1]
+	}
+
+	return message
+}
+
+// syslogSeverity maps a zap level to a syslog severity
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return 2
+	case zapcore.FatalLevel:
+		return 0
+	}
+
+	return 5
+}
+
+// syslogCore writes encoded entries to syslog with the severity of their level
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  *syslogWriter
+}
+
+func newSyslogCore(encoder zapcore.Encoder, writer *syslogWriter, level zapcore.LevelEnabler) zapcore.Core {
+	return &syslogCore{LevelEnabler: level, encoder: encoder, writer: writer}
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	return &syslogCore{LevelEnabler: c.LevelEnabler, encoder: encoder, writer: c.writer}
+}
+
+func (c *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	return c.writer.write(syslogSeverity(entry.Level), entry.Time, buf.Bytes())
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
@@ -13,7 +13,10 @@ import (
 	"go.uber.org/zap"
 )
 
-var logger *zap.Logger
+var (
+	logger     *zap.Logger
+	loggerOnce sync.Once
+)
 
 // MQTTConfig is the configuration for the MQTT client
 type MQTTConfig struct {
@@ -27,6 +30,15 @@ type MQTTConfig struct {
 	ReconnectOnDisconnect bool
 	Username              string
 	Password              string
+	TLS                   TLSConfig
+}
+
+// ConnectionHandlers are called when the connection state changes after the
+// initial connect. Nil handlers are skipped.
+type ConnectionHandlers struct {
+	OnConnectionLost   func(err error)
+	OnReconnectAttempt func(attempt int, err error)
+	OnReconnected      func()
 }
 
 // MQTTClient is the interface for the MQTT client
@@ -36,10 +48,16 @@ type MQTTClient struct {
 	Config MQTTConfig
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	// handlerMu guards the handlers separately from mu, which is held while
+	// connecting and disconnecting
+	handlerMu sync.RWMutex
+	handlers  ConnectionHandlers
+	messages  func(topic string, payload []byte)
 }
 
 func NewMQTTClient(config MQTTConfig) *MQTTClient {
-	logger = logging.GetLogger("mqtt")
+	loggerOnce.Do(func() { logger = logging.GetLogger("mqtt") })
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Generate a new ClientID
@@ -59,6 +77,27 @@ func generateClientID(baseID string) string {
 	return fmt.Sprintf("%s-%s", baseID, uuidPart)
 }
 
+// ClientID returns the client ID sent to the broker, with its random suffix
+func (m *MQTTClient) ClientID() string {
+	return m.Config.ClientID
+}
+
+// SetConnectionHandlers sets the handlers that are called on connection state changes
+func (m *MQTTClient) SetConnectionHandlers(handlers ConnectionHandlers) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	m.handlers = handlers
+}
+
+// SetMessageHandler sets the function that is called for every received message
+func (m *MQTTClient) SetMessageHandler(handler func(topic string, payload []byte)) {
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+
+	m.messages = handler
+}
+
 func (m *MQTTClient) Connect() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -67,12 +106,25 @@ func (m *MQTTClient) Connect() error {
 	logger.Debug("MQTT client configuration", zap.String("client_id", m.Config.ClientID), zap.String("topic", m.Config.Topic), zap.Uint8("qos", m.Config.Qos), zap.Bool("clean_session", m.Config.CleanSession), zap.Int("keep_alive", m.Config.KeepAlive))
 
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", m.Config.Broker, m.Config.Port))
+	if m.Config.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(m.Config.TLS)
+		if err != nil {
+			return err
+		}
+
+		opts.AddBroker(fmt.Sprintf("ssl://%s:%d", m.Config.Broker, m.Config.Port))
+		opts.SetTLSConfig(tlsConfig)
+	} else {
+		opts.AddBroker(fmt.Sprintf("tcp://%s:%d", m.Config.Broker, m.Config.Port))
+	}
 	opts.SetClientID(m.Config.ClientID)
 	opts.SetCleanSession(m.Config.CleanSession)
 	opts.SetKeepAlive(time.Duration(m.Config.KeepAlive) * time.Second)
 	opts.SetUsername(m.Config.Username)
 	opts.SetPassword(m.Config.Password)
+	// onConnectionLost reconnects with a new client, so the lost one must not
+	// reconnect on its own as well
+	opts.SetAutoReconnect(false)
 
 	opts.OnConnect = m.onConnect
 	opts.OnConnectionLost = m.onConnectionLost
@@ -113,6 +165,26 @@ func (m *MQTTClient) Subscribe() error {
 	return token.Error()
 }
 
+// Publish sends a message and waits until it is delivered for the QoS
+func (m *MQTTClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	m.mu.Lock()
+	client := m.Client
+	m.mu.Unlock()
+
+	if client == nil || !client.IsConnected() {
+		return fmt.Errorf("client is not connected")
+	}
+
+	token := client.Publish(topic, qos, retained, payload)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	logger.Debug("Published message", zap.String("topic", topic))
+	return nil
+}
+
 func (m *MQTTClient) onConnect(client mqtt.Client) {
 	logger.Info("Connected to MQTT broker")
 }
@@ -120,7 +192,15 @@ func (m *MQTTClient) onConnect(client mqtt.Client) {
 func (m *MQTTClient) onConnectionLost(client mqtt.Client, err error) {
 	logger.Error("Connection lost. Attempting to reconnect...", zap.Error(err))
 
-	for {
+	m.handlerMu.RLock()
+	handlers := m.handlers
+	m.handlerMu.RUnlock()
+
+	if handlers.OnConnectionLost != nil {
+		handlers.OnConnectionLost(err)
+	}
+
+	for attempt := 1; ; attempt++ {
 		select {
 		case <-m.ctx.Done():
 			logger.Warn("Context canceled, stopping reconnection attempts")
@@ -128,8 +208,16 @@ func (m *MQTTClient) onConnectionLost(client mqtt.Client, err error) {
 		default:
 			if err := m.Connect(); err != nil {
 				logger.Warn("Reconnection failed. Retrying...", zap.Error(err))
+
+				if handlers.OnReconnectAttempt != nil {
+					handlers.OnReconnectAttempt(attempt, err)
+				}
 			} else {
 				logger.Info("Reconnected to MQTT broker")
+
+				if handlers.OnReconnected != nil {
+					handlers.OnReconnected()
+				}
 				return
 			}
 			time.Sleep(5 * time.Second) // Wait before retrying
@@ -142,4 +230,12 @@ func (m *MQTTClient) onMessage(client mqtt.Client, msg mqtt.Message) {
 
 	logger.Info("Received message", zap.Uint16("message_id", msg.MessageID()), zap.String("topic", topic))
 	logger.Debug("Message payload", zap.Uint16("message_id", msg.MessageID()), zap.String("payload", string(msg.Payload())))
+
+	m.handlerMu.RLock()
+	handler := m.messages
+	m.handlerMu.RUnlock()
+
+	if handler != nil {
+		handler(topic, msg.Payload())
+	}
 }
@@ -0,0 +1,174 @@
+package mqttclient_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/logging"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt/mqtttest"
+)
+
+func TestMain(m *testing.M) {
+	logging.NewLogger(&logging.LoggingConfig{
+		Level:   "error",
+		Outputs: []logging.OutputConfig{{Type: "stdout", Format: "console"}},
+	})
+
+	os.Exit(m.Run())
+}
+
+func newClient(broker *mqtttest.Broker, username, password string) *mqttclient.MQTTClient {
+	return mqttclient.NewMQTTClient(mqttclient.MQTTConfig{
+		Broker:                broker.Host(),
+		Port:                  broker.Port(),
+		ClientID:              "test",
+		Topic:                 "site/#",
+		Qos:                   1,
+		CleanSession:          true,
+		KeepAlive:             30,
+		ReconnectOnDisconnect: true,
+		Username:              username,
+		Password:              password,
+	})
+}
+
+// receive waits for a message from the client
+func receive(t *testing.T, messages <-chan received) received {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(mqtttest.WaitTimeout):
+		t.Fatal("timed out waiting for a message")
+		return received{}
+	}
+}
+
+type received struct {
+	topic   string
+	payload string
+}
+
+func TestConnectSubscribeAndReceive(t *testing.T) {
+	broker := mqtttest.New(t)
+	client := newClient(broker, "", "")
+	defer client.Disconnect()
+
+	messages := make(chan received, 10)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		messages <- received{topic: topic, payload: string(payload)}
+	})
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := client.Subscribe(); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	broker.WaitForSubscription(t, "site/#")
+
+	broker.Publish("other/ahu1", []byte("ignored"), false)
+	broker.Publish("site/ahu1/temp", []byte("21.5"), false)
+
+	got := receive(t, messages)
+	if got.topic != "site/ahu1/temp" || got.payload != "21.5" {
+		t.Errorf("received %s %q, want site/ahu1/temp \"21.5\"", got.topic, got.payload)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	broker := mqtttest.New(t)
+	client := newClient(broker, "", "")
+	defer client.Disconnect()
+
+	if err := client.Publish("bms/alarms/high", 1, true, []byte("{}")); err == nil {
+		t.Error("Publish() before Connect() succeeded, want an error")
+	}
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := client.Publish("bms/alarms/high", 1, true, []byte(`{"state":"raised"}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	got := broker.WaitForMessage(t, "bms/alarms/#")
+	if got.Topic != "bms/alarms/high" || string(got.Payload) != `{"state":"raised"}` || got.Qos != 1 || !got.Retain {
+		t.Errorf("published %+v, want bms/alarms/high at QoS 1 retained", got)
+	}
+}
+
+func TestConnectBadCredentials(t *testing.T) {
+	broker := mqtttest.New(t, mqtttest.WithCredentials("bms", "secret"))
+
+	client := newClient(broker, "bms", "wrong")
+	err := client.Connect()
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Fatalf("Connect() error = %v, want bad user name or password", err)
+	}
+
+	client = newClient(broker, "bms", "secret")
+	defer client.Disconnect()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() with the right credentials error = %v", err)
+	}
+}
+
+func TestReconnectAfterBrokerRestart(t *testing.T) {
+	broker := mqtttest.New(t)
+	client := newClient(broker, "", "")
+	defer client.Disconnect()
+
+	lost := make(chan error, 1)
+	reconnected := make(chan struct{}, 1)
+	client.SetConnectionHandlers(mqttclient.ConnectionHandlers{
+		OnConnectionLost: func(err error) { lost <- err },
+		OnReconnected:    func() { reconnected <- struct{}{} },
+	})
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	broker.WaitForClients(t, 1)
+
+	broker.Restart(t)
+
+	select {
+	case <-lost:
+	case <-time.After(mqtttest.WaitTimeout):
+		t.Fatal("timed out waiting for the connection to be lost")
+	}
+
+	select {
+	case <-reconnected:
+	case <-time.After(mqtttest.WaitTimeout):
+		t.Fatal("timed out waiting for the client to reconnect")
+	}
+	broker.WaitForClients(t, 1)
+
+	if err := client.Publish("site/ahu1/status", 0, false, []byte("back")); err != nil {
+		t.Fatalf("Publish() after reconnect error = %v", err)
+	}
+	broker.WaitForMessage(t, "site/ahu1/status")
+}
+
+func TestDisconnect(t *testing.T) {
+	broker := mqtttest.New(t)
+	client := newClient(broker, "", "")
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	broker.WaitForClients(t, 1)
+
+	client.Disconnect()
+	broker.WaitForClients(t, 0)
+
+	if err := client.Subscribe(); err == nil {
+		t.Error("Subscribe() after Disconnect() succeeded, want an error")
+	}
+}
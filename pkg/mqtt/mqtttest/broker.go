@@ -0,0 +1,723 @@
+// Package mqtttest runs an in-process MQTT 3.1.1 broker for tests, so the
+// client and the engine can be exercised offline instead of against a public
+// broker. It supports QoS 0 and 1, retained messages and username and
+// password authentication. QoS 2 publishes are accepted and delivered at QoS 1,
+// and every session is clean.
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// WaitTimeout is how long the Wait helpers wait before failing the test
+const WaitTimeout = 15 * time.Second
+
+// Control packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted              = 0
+	connackBadUsernameOrPassword = 4
+)
+
+// Message is a message published to the broker
+type Message struct {
+	Topic   string
+	Payload []byte
+	Qos     byte
+	Retain  bool
+}
+
+// Option configures a broker
+type Option func(b *Broker)
+
+// WithCredentials refuses the clients that do not connect with this username
+// and password
+func WithCredentials(username, password string) Option {
+	return func(b *Broker) {
+		b.auth = true
+		b.username = username
+		b.password = password
+	}
+}
+
+// Broker is an MQTT broker listening on a local port. It is safe for concurrent use.
+type Broker struct {
+	auth     bool
+	username string
+	password string
+
+	mu        sync.Mutex
+	addr      string
+	listener  net.Listener
+	conns     map[net.Conn]bool
+	sessions  map[*session]bool
+	retained  map[string]Message
+	published []Message
+	// changed is closed and replaced on every change, to wake the Wait helpers
+	changed chan struct{}
+	wg      sync.WaitGroup
+}
+
+// session is a connected client
+type session struct {
+	conn     net.Conn
+	clientID string
+	// subscriptions maps each topic filter to its granted QoS, guarded by
+	// the broker mutex
+	subscriptions map[string]byte
+
+	writeMu  sync.Mutex
+	packetID uint16
+}
+
+// New starts a broker on a free local port and stops it when the test ends
+func New(t testing.TB, options ...Option) *Broker {
+	t.Helper()
+
+	b := &Broker{
+		addr:     "127.0.0.1:0",
+		conns:    make(map[net.Conn]bool),
+		sessions: make(map[*session]bool),
+		retained: make(map[string]Message),
+		changed:  make(chan struct{}),
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	if err := b.Start(); err != nil {
+		t.Fatalf("failed to start the MQTT broker: %v", err)
+	}
+	t.Cleanup(b.Stop)
+
+	return b
+}
+
+// Host returns the host the broker listens on
+func (b *Broker) Host() string {
+	host, _, _ := net.SplitHostPort(b.address())
+	return host
+}
+
+// Port returns the port the broker listens on, which stays the same across restarts
+func (b *Broker) Port() int {
+	_, port, _ := net.SplitHostPort(b.address())
+	number, _ := strconv.Atoi(port)
+	return number
+}
+
+func (b *Broker) address() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.addr
+}
+
+// Start listens for clients. A stopped broker starts on the same port again.
+func (b *Broker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.listener != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", b.addr)
+	if err != nil {
+		return err
+	}
+
+	b.listener = listener
+	b.addr = listener.Addr().String()
+
+	b.wg.Add(1)
+	go b.accept(listener)
+
+	return nil
+}
+
+// Stop closes the listener and drops every client, like a broker going down.
+// The retained messages are kept.
+func (b *Broker) Stop() {
+	b.mu.Lock()
+	listener := b.listener
+	b.listener = nil
+	conns := make([]net.Conn, 0, len(b.conns))
+	for conn := range b.conns {
+		conns = append(conns, conn)
+	}
+	b.mu.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	b.wg.Wait()
+}
+
+// Restart stops the broker and starts it again on the same port
+func (b *Broker) Restart(t testing.TB) {
+	t.Helper()
+
+	b.Stop()
+	if err := b.Start(); err != nil {
+		t.Fatalf("failed to restart the MQTT broker: %v", err)
+	}
+}
+
+// Clients returns the number of connected clients
+func (b *Broker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.sessions)
+}
+
+// Published returns the messages the clients published, oldest first
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.published...)
+}
+
+// Publish sends a message to the subscribed clients, as if another client
+// had published it
+func (b *Broker) Publish(topic string, payload []byte, retain bool) {
+	b.route(Message{Topic: topic, Payload: payload, Qos: 1, Retain: retain}, false)
+}
+
+// WaitForClients waits until n clients are connected
+func (b *Broker) WaitForClients(t testing.TB, n int) {
+	t.Helper()
+
+	b.waitFor(t, fmt.Sprintf("%d connected clients", n), func() bool {
+		return len(b.sessions) == n
+	})
+}
+
+// WaitForSubscription waits until a connected client subscribed to a topic filter
+func (b *Broker) WaitForSubscription(t testing.TB, filter string) {
+	t.Helper()
+
+	b.waitFor(t, fmt.Sprintf("a subscription to %q", filter), func() bool {
+		for s := range b.sessions {
+			if _, ok := s.subscriptions[filter]; ok {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WaitForMessage waits until a client published a message on a topic
+// matching a filter, and returns the first such message
+func (b *Broker) WaitForMessage(t testing.TB, filter string) Message {
+	t.Helper()
+
+	var found Message
+	b.waitFor(t, fmt.Sprintf("a message on %q", filter), func() bool {
+		for _, message := range b.published {
+			if topicMatches(filter, message.Topic) {
+				found = message
+				return true
+			}
+		}
+		return false
+	})
+
+	return found
+}
+
+// waitFor waits until a condition, checked with the mutex held, is true
+func (b *Broker) waitFor(t testing.TB, what string, condition func() bool) {
+	t.Helper()
+
+	timeout := time.NewTimer(WaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		b.mu.Lock()
+		done := condition()
+		changed := b.changed
+		b.mu.Unlock()
+
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			t.Fatalf("timed out after %s waiting for %s", WaitTimeout, what)
+		}
+	}
+}
+
+// notify wakes the Wait helpers. The caller must hold the mutex.
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Broker) accept(listener net.Listener) {
+	defer b.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		// A connection accepted while stopping is dropped at once
+		b.mu.Lock()
+		if b.listener != listener {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.wg.Add(1)
+		b.mu.Unlock()
+
+		go b.serve(conn)
+	}
+}
+
+// serve handles the packets of a client until it disconnects or the broker stops
+func (b *Broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		conn.Close()
+
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	packetType, _, body, err := readPacket(reader)
+	if err != nil || packetType != packetConnect {
+		return
+	}
+
+	connect, err := parseConnect(body)
+	if err != nil {
+		return
+	}
+
+	s := &session{conn: conn, clientID: connect.clientID, subscriptions: make(map[string]byte)}
+
+	if b.auth && (connect.username != b.username || connect.password != b.password) {
+		s.write(packetConnack<<4, []byte{0, connackBadUsernameOrPassword})
+		return
+	}
+
+	// A client connecting again with the same ID takes over the session, as
+	// a real broker disconnects the existing client
+	b.mu.Lock()
+	for existing := range b.sessions {
+		if existing.clientID == s.clientID {
+			existing.conn.Close()
+		}
+	}
+	b.sessions[s] = true
+	b.notify()
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.notify()
+		b.mu.Unlock()
+	}()
+
+	if err := s.write(packetConnack<<4, []byte{0, connackAccepted}); err != nil {
+		return
+	}
+
+	for {
+		packetType, flags, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch packetType {
+		case packetPublish:
+			message, packetID, err := parsePublish(flags, body)
+			if err != nil {
+				return
+			}
+
+			switch message.Qos {
+			case 1:
+				s.write(packetPuback<<4, uint16Bytes(packetID))
+			case 2:
+				s.write(packetPubrec<<4, uint16Bytes(packetID))
+			}
+
+			b.route(message, true)
+		case packetPubrel:
+			s.write(packetPubcomp<<4, body)
+		case packetSubscribe:
+			if err := b.subscribe(s, body); err != nil {
+				return
+			}
+		case packetUnsubscribe:
+			if err := b.unsubscribe(s, body); err != nil {
+				return
+			}
+		case packetPingreq:
+			s.write(packetPingresp<<4, nil)
+		case packetPuback, packetPubrec, packetPubcomp:
+			// Deliveries are not retried, so their acknowledgements are not tracked
+		case packetDisconnect:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// route records a published message and delivers it to the subscribers
+func (b *Broker) route(message Message, fromClient bool) {
+	b.mu.Lock()
+
+	if fromClient {
+		b.published = append(b.published, message)
+	}
+
+	if message.Retain {
+		if len(message.Payload) == 0 {
+			delete(b.retained, message.Topic)
+		} else {
+			b.retained[message.Topic] = message
+		}
+	}
+
+	deliveries := map[*session]byte{}
+	for s := range b.sessions {
+		for filter, qos := range s.subscriptions {
+			if !topicMatches(filter, message.Topic) {
+				continue
+			}
+
+			if granted, ok := deliveries[s]; !ok || qos > granted {
+				deliveries[s] = qos
+			}
+		}
+	}
+
+	b.notify()
+	b.mu.Unlock()
+
+	for s, qos := range deliveries {
+		s.publish(message.Topic, message.Payload, min(qos, message.Qos), false)
+	}
+}
+
+// subscribe adds the subscriptions of a SUBSCRIBE packet, acknowledges them
+// and sends the matching retained messages
+func (b *Broker) subscribe(s *session, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed subscribe")
+	}
+
+	packetID := body[:2]
+	rest := body[2:]
+
+	var filters []string
+	granted := []byte{}
+	for len(rest) > 0 {
+		filter, next, err := readString(rest)
+		if err != nil || len(next) < 1 {
+			return errors.New("malformed subscribe")
+		}
+
+		filters = append(filters, filter)
+		granted = append(granted, min(next[0]&0x03, 1))
+		rest = next[1:]
+	}
+
+	b.mu.Lock()
+	for i, filter := range filters {
+		s.subscriptions[filter] = granted[i]
+	}
+
+	var retained []Message
+	for _, message := range b.retained {
+		for _, filter := range filters {
+			if topicMatches(filter, message.Topic) {
+				retained = append(retained, message)
+				break
+			}
+		}
+	}
+
+	b.notify()
+	b.mu.Unlock()
+
+	if err := s.write(packetSuback<<4, append(packetID, granted...)); err != nil {
+		return err
+	}
+
+	for _, message := range retained {
+		s.publish(message.Topic, message.Payload, min(message.Qos, 1), true)
+	}
+
+	return nil
+}
+
+// unsubscribe removes the subscriptions of an UNSUBSCRIBE packet
+func (b *Broker) unsubscribe(s *session, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed unsubscribe")
+	}
+
+	packetID := body[:2]
+	rest := body[2:]
+
+	b.mu.Lock()
+	for len(rest) > 0 {
+		filter, next, err := readString(rest)
+		if err != nil {
+			b.mu.Unlock()
+			return err
+		}
+
+		delete(s.subscriptions, filter)
+		rest = next
+	}
+	b.notify()
+	b.mu.Unlock()
+
+	return s.write(packetUnsuback<<4, packetID)
+}
+
+// publish sends a PUBLISH packet to the client
+func (s *session) publish(topic string, payload []byte, qos byte, retain bool) {
+	header := byte(packetPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendString(nil, topic)
+	if qos > 0 {
+		s.writeMu.Lock()
+		s.packetID++
+		if s.packetID == 0 {
+			s.packetID = 1
+		}
+		id := s.packetID
+		s.writeMu.Unlock()
+
+		body = append(body, uint16Bytes(id)...)
+	}
+	body = append(body, payload...)
+
+	s.write(header, body)
+}
+
+// write sends a packet with a fixed header byte and a body
+func (s *session) write(header byte, body []byte) error {
+	packet := append([]byte{header}, encodeLength(len(body))...)
+	packet = append(packet, body...)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+// connectPacket holds the fields of a CONNECT packet the broker uses
+type connectPacket struct {
+	clientID string
+	username string
+	password string
+}
+
+func parseConnect(body []byte) (connectPacket, error) {
+	var packet connectPacket
+
+	_, rest, err := readString(body) // protocol name, MQTT or MQIsdp
+	if err != nil || len(rest) < 4 {
+		return packet, errors.New("malformed connect")
+	}
+
+	flags := rest[1]
+	rest = rest[4:] // protocol level, flags and keep alive
+
+	if packet.clientID, rest, err = readString(rest); err != nil {
+		return packet, err
+	}
+
+	if flags&0x04 != 0 { // will topic and message
+		if _, rest, err = readString(rest); err != nil {
+			return packet, err
+		}
+		if _, rest, err = readString(rest); err != nil {
+			return packet, err
+		}
+	}
+
+	if flags&0x80 != 0 {
+		if packet.username, rest, err = readString(rest); err != nil {
+			return packet, err
+		}
+	}
+
+	if flags&0x40 != 0 {
+		if packet.password, _, err = readString(rest); err != nil {
+			return packet, err
+		}
+	}
+
+	return packet, nil
+}
+
+func parsePublish(flags byte, body []byte) (Message, uint16, error) {
+	message := Message{Qos: (flags >> 1) & 0x03, Retain: flags&0x01 != 0}
+
+	topic, rest, err := readString(body)
+	if err != nil {
+		return message, 0, err
+	}
+	message.Topic = topic
+
+	var packetID uint16
+	if message.Qos > 0 {
+		if len(rest) < 2 {
+			return message, 0, errors.New("malformed publish")
+		}
+		packetID = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+
+	message.Payload = append([]byte(nil), rest...)
+	return message, packetID, nil
+}
+
+// readPacket reads a control packet and returns its type, flags and body
+func readPacket(reader *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func encodeLength(length int) []byte {
+	var encoded []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return "", nil, errors.New("malformed string")
+	}
+
+	return string(data[2 : 2+length]), data[2+length:], nil
+}
+
+func appendString(data []byte, value string) []byte {
+	data = append(data, uint16Bytes(uint16(len(value)))...)
+	return append(data, value...)
+}
+
+func uint16Bytes(value uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, value)
+}
+
+// topicMatches reports whether a topic matches a filter with + and # wildcards
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
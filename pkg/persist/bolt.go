@@ -0,0 +1,278 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateBucket holds one entry per value, keyed by the full dotted key
+var stateBucket = []byte("state")
+
+// BoltPersister keeps the state in an embedded bbolt database. Every value is
+// stored under its own key, so a change only writes that value instead of the
+// whole state.
+type BoltPersister struct {
+	db       *bolt.DB
+	watchers watchers
+
+	mu      sync.Mutex
+	saveErr error
+}
+
+func NewBoltPersister(filePath string) (*BoltPersister, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// Fail instead of blocking forever when another process holds the database
+	db, err := bolt.Open(filePath, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database %s: %w", filePath, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltPersister{db: db}, nil
+}
+
+// Set allows setting a value using a nested key like "key1.key2.key3". Maps
+// are stored as one entry per nested value.
+func (p *BoltPersister) Set(key string, value interface{}) {
+	values := map[string]interface{}{}
+	flattenValue(key, value, values)
+
+	encoded := make(map[string][]byte, len(values))
+	for k, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			p.setErr(fmt.Errorf("failed to encode %s: %w", k, err))
+			return
+		}
+		encoded[k] = data
+	}
+
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+
+		// A value replaces the values nested below it and any parent that held a value
+		if err := deleteBoltPrefix(bucket, key); err != nil {
+			return err
+		}
+
+		keys := strings.Split(key, ".")
+		for i := 1; i < len(keys); i++ {
+			if err := bucket.Delete([]byte(strings.Join(keys[:i], "."))); err != nil {
+				return err
+			}
+		}
+
+		for k, data := range encoded {
+			if err := bucket.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	p.setErr(err)
+	if err == nil {
+		p.watchers.notify(Change{Key: key, Value: copyValue(value), Time: time.Now()})
+	}
+}
+
+// Get retrieves a value using a nested key like "key1.key2.key3".
+func (p *BoltPersister) Get(key string) interface{} {
+	var value interface{}
+
+	p.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(stateBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &value)
+	})
+
+	return value
+}
+
+// Delete removes a key and any nested keys below it
+func (p *BoltPersister) Delete(key string) {
+	deleted := false
+
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+
+		if bucket.Get([]byte(key)) != nil {
+			deleted = true
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+
+		if hasBoltPrefix(bucket, key) {
+			deleted = true
+		}
+
+		return deleteBoltPrefix(bucket, key)
+	})
+
+	p.setErr(err)
+	if err == nil && deleted {
+		p.watchers.notify(Change{Key: key, Deleted: true, Time: time.Now()})
+	}
+}
+
+// Keys returns the sorted keys of all values at or below a prefix
+func (p *BoltPersister) Keys(prefix string) []string {
+	var keys []string
+
+	p.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(stateBucket).Cursor()
+
+		if prefix == "" {
+			for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+				keys = append(keys, string(k))
+			}
+			return nil
+		}
+
+		if cursor.Bucket().Get([]byte(prefix)) != nil {
+			keys = append(keys, prefix)
+		}
+
+		nested := []byte(prefix + ".")
+		for k, _ := cursor.Seek(nested); k != nil && bytes.HasPrefix(k, nested); k, _ = cursor.Next() {
+			keys = append(keys, string(k))
+		}
+
+		return nil
+	})
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Watch returns a channel that receives the changes matching a prefix
+func (p *BoltPersister) Watch(prefix string) (<-chan Change, func()) {
+	return p.watchers.add(prefix)
+}
+
+// Close closes the database and returns the error of the last failed write, if any
+func (p *BoltPersister) Close() error {
+	p.watchers.closeAll()
+
+	if err := p.db.Close(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.saveErr
+}
+
+func (p *BoltPersister) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.saveErr = err
+}
+
+// flattenValue adds a value to values under its dotted key, with one entry
+// per nested value of a map
+func flattenValue(key string, value interface{}, values map[string]interface{}) {
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		values[key] = value
+		return
+	}
+
+	for k, v := range nested {
+		flattenValue(key+"."+k, v, values)
+	}
+}
+
+// snapshotBolt reads a bbolt state database into memory without modifying it
+func snapshotBolt(filePath string) (*MemoryPersister, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filePath, 0644, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("state database %s is locked by the running application", filePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database %s: %w", filePath, err)
+	}
+	defer db.Close()
+
+	p := NewMemoryPersister()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var value interface{}
+			if err := json.Unmarshal(v, &value); err != nil {
+				return fmt.Errorf("failed to decode %s: %w", k, err)
+			}
+
+			setNested(p.data, string(k), value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func hasBoltPrefix(bucket *bolt.Bucket, key string) bool {
+	nested := []byte(key + ".")
+	k, _ := bucket.Cursor().Seek(nested)
+
+	return k != nil && bytes.HasPrefix(k, nested)
+}
+
+// deleteBoltPrefix deletes the values nested below a key
+func deleteBoltPrefix(bucket *bolt.Bucket, key string) error {
+	nested := []byte(key + ".")
+
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(nested); k != nil && bytes.HasPrefix(k, nested); k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package persist
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyPrefix is the key below which the history of tracked keys is kept
+const historyPrefix = "history"
+
+// DefaultHistoryLimit is the number of transitions kept per key when no limit is set
+const DefaultHistoryLimit = 50
+
+// HistoryEntry is a value a tracked key held from a point in time
+type HistoryEntry struct {
+	Time  time.Time
+	Value interface{}
+}
+
+// HistoryPersister records the transitions of chosen keys, such as
+// mqtt.status, in a bounded changelog stored under "history.<key>" in the
+// wrapped persister. Only changes of the value are recorded.
+type HistoryPersister struct {
+	Persister
+
+	mu    sync.Mutex
+	keys  map[string]bool
+	limit int
+}
+
+// WithHistory wraps a persister to record the history of the given keys
+func WithHistory(p Persister, keys []string, limit int) *HistoryPersister {
+	h := &HistoryPersister{Persister: p}
+	h.SetTracked(keys, limit)
+
+	return h
+}
+
+// SetTracked changes the tracked keys and the number of transitions kept per key
+func (p *HistoryPersister) SetTracked(keys []string, limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	p.keys = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		p.keys[key] = true
+	}
+	p.limit = limit
+}
+
+// Set sets a value and records it in the history if the key is tracked and the value changed
+func (p *HistoryPersister) Set(key string, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Persister.Set(key, value)
+
+	for tracked := range p.keys {
+		if tracked == key {
+			p.record(tracked, value)
+		} else if strings.HasPrefix(tracked, key+".") {
+			// A parent was replaced, for example a whole state struct
+			if nested, ok := value.(map[string]interface{}); ok {
+				if trackedValue := getNested(nested, strings.TrimPrefix(tracked, key+".")); trackedValue != nil {
+					p.record(tracked, trackedValue)
+				}
+			}
+		}
+	}
+}
+
+// record appends a value to the history of a key if it differs from the last one
+func (p *HistoryPersister) record(key string, value interface{}) {
+	entries := History(p.Persister, key)
+	if len(entries) > 0 && sameValue(entries[len(entries)-1].Value, value) {
+		return
+	}
+
+	entries = append(entries, HistoryEntry{Time: time.Now(), Value: value})
+	if len(entries) > p.limit {
+		entries = entries[len(entries)-p.limit:]
+	}
+
+	stored := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		stored[i] = map[string]interface{}{
+			"time":  entry.Time.Format(time.RFC3339Nano),
+			"value": copyValue(entry.Value),
+		}
+	}
+
+	p.Persister.Set(historyPrefix+"."+key, stored)
+}
+
+// History returns the recorded transitions of a key, oldest first
+func History(p Persister, key string) []HistoryEntry {
+	stored, ok := p.Get(historyPrefix + "." + key).([]interface{})
+	if !ok {
+		return nil
+	}
+
+	entries := make([]HistoryEntry, 0, len(stored))
+	for _, item := range stored {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		timeValue, _ := fields["time"].(string)
+		timestamp, err := time.Parse(time.RFC3339Nano, timeValue)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, HistoryEntry{Time: timestamp, Value: fields["value"]})
+	}
+
+	return entries
+}
+
+// HistoryKeys returns the keys that have a recorded history
+func HistoryKeys(p Persister) []string {
+	var keys []string
+	for _, key := range p.Keys(historyPrefix) {
+		keys = append(keys, strings.TrimPrefix(key, historyPrefix+"."))
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// LoadFile reads a state file written by a FilePersister into a memory
+// persister, without locking, quarantining or writing the file
+func LoadFile(filePath string) (*MemoryPersister, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewMemoryPersister()
+	if err := json.Unmarshal(data, &p.data); err != nil {
+		return nil, err
+	}
+
+	if p.data == nil {
+		p.data = make(map[string]interface{})
+	}
+
+	return p, nil
+}
+
+// sameValue compares values the way they would be stored, so an int and the
+// float64 it decodes to from JSON are equal
+func sameValue(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,75 @@
+package persist
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// MemoryPersister keeps the state in memory only. It is used for tests and
+// when the state does not need to survive a restart.
+type MemoryPersister struct {
+	mu       sync.RWMutex
+	data     map[string]interface{}
+	watchers watchers
+}
+
+func NewMemoryPersister() *MemoryPersister {
+	return &MemoryPersister{
+		data: make(map[string]interface{}),
+	}
+}
+
+// Set allows setting a value using a nested key like "key1.key2.key3".
+func (p *MemoryPersister) Set(key string, value interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	setNested(p.data, key, value)
+	p.watchers.notify(Change{Key: key, Value: copyValue(value), Time: time.Now()})
+}
+
+// Get retrieves a value using a nested key like "key1.key2.key3".
+func (p *MemoryPersister) Get(key string) interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return getNested(p.data, key)
+}
+
+// Delete removes a key and any nested keys below it
+func (p *MemoryPersister) Delete(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if deleteNested(p.data, key) {
+		p.watchers.notify(Change{Key: key, Deleted: true, Time: time.Now()})
+	}
+}
+
+// Keys returns the sorted keys of all values at or below a prefix
+func (p *MemoryPersister) Keys(prefix string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return nestedKeys(p.data, prefix)
+}
+
+// Watch returns a channel that receives the changes matching a prefix
+func (p *MemoryPersister) Watch(prefix string) (<-chan Change, func()) {
+	return p.watchers.add(prefix)
+}
+
+// Close closes the channels of all watchers
+func (p *MemoryPersister) Close() error {
+	p.watchers.closeAll()
+	return nil
+}
+
+// marshal encodes the state as JSON
+func (p *MemoryPersister) marshal() ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return json.Marshal(p.data)
+}
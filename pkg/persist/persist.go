@@ -2,14 +2,33 @@ package persist
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 )
 
+// defaultFlushDelay is how long writes are collected before the file is saved,
+// so a burst of Set calls results in a single write
+const defaultFlushDelay = 100 * time.Millisecond
+
+// FilePersister keeps the state in memory and saves it as a single JSON file
 type FilePersister struct {
+	*MemoryPersister
 	filePath string
-	data     map[string]interface{}
+
+	// saveMu serialises writes to the file
+	saveMu      sync.Mutex
+	stateMu     sync.Mutex
+	dirty       bool
+	saveErr     error
+	flushDelay  time.Duration
+	flushChan   chan struct{}
+	done        chan struct{}
+	wg          sync.WaitGroup
+	closeOnce   sync.Once
+	quarantined string
 }
 
 func Test() {
@@ -20,8 +39,11 @@ func NewFilePersister(filePath string) (*FilePersister, error) {
 	// filePathDir := "./config/states"
 
 	persister := &FilePersister{
-		filePath: filePath,
-		data:     make(map[string]interface{}),
+		MemoryPersister: NewMemoryPersister(),
+		filePath:        filePath,
+		flushDelay:      defaultFlushDelay,
+		flushChan:       make(chan struct{}, 1),
+		done:            make(chan struct{}),
 	}
 
 	filePathDir := filepath.Dir(filePath)
@@ -35,11 +57,20 @@ func NewFilePersister(filePath string) (*FilePersister, error) {
 		return nil, err
 	}
 
+	persister.wg.Add(1)
+	go persister.run()
+
 	return persister, nil
 }
 
+// QuarantinedFile returns the path the state file was moved to when it could
+// not be decoded on load, or an empty string if it was loaded normally
+func (p *FilePersister) QuarantinedFile() string {
+	return p.quarantined
+}
+
 func (p *FilePersister) load() error {
-	file, err := os.Open(p.filePath)
+	data, err := os.ReadFile(p.filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File does not exist, start with empty data
@@ -47,60 +78,154 @@ func (p *FilePersister) load() error {
 		}
 		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(&p.data)
+	if err := json.Unmarshal(data, &p.data); err != nil || p.data == nil {
+		// Keep the corrupt file for inspection and start with empty data
+		quarantined := fmt.Sprintf("%s.corrupt-%s", p.filePath, time.Now().Format("20060102T150405"))
+		if err := os.Rename(p.filePath, quarantined); err != nil {
+			return fmt.Errorf("failed to quarantine corrupt state file %s: %w", p.filePath, err)
+		}
+
+		p.quarantined = quarantined
+		p.data = make(map[string]interface{})
+	}
+
+	return nil
+}
+
+// run saves the data in the background after a burst of changes
+func (p *FilePersister) run() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.flushChan:
+		}
+
+		timer := time.NewTimer(p.flushDelay)
+		select {
+		case <-p.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		p.Flush()
+	}
+}
+
+// Flush writes pending changes to the file and returns the error of the last
+// failed write, if any
+func (p *FilePersister) Flush() error {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	p.stateMu.Lock()
+	if !p.dirty {
+		err := p.saveErr
+		p.stateMu.Unlock()
+		return err
+	}
+	p.dirty = false
+	p.stateMu.Unlock()
+
+	data, err := p.marshal()
+	if err == nil {
+		err = p.save(append(data, '\n'))
+	}
+
+	p.stateMu.Lock()
+	p.saveErr = err
+	if err != nil {
+		// Retry on the next change or flush
+		p.dirty = true
+	}
+	p.stateMu.Unlock()
+
+	return err
 }
 
-func (p *FilePersister) save() error {
-	file, err := os.Create(p.filePath)
+// Close stops the background writer and saves any pending changes
+func (p *FilePersister) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+
+	err := p.Flush()
+	p.MemoryPersister.Close()
+
+	return err
+}
+
+// save replaces the file atomically: the data is written and synced to a
+// temporary file in the same directory, which is then renamed over the file
+func (p *FilePersister) save(data []byte) error {
+	dir := filepath.Dir(p.filePath)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(p.filePath)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := tmp.Name()
+
+	// CreateTemp uses 0600, keep the permissions the file had with os.Create
+	tmp.Chmod(0644)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(p.data)
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, p.filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Sync the directory so the rename survives a crash. This is not
+	// supported on every platform, so errors are ignored.
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+
+	return nil
 }
 
 // Set allows setting a value using a nested key like "key1.key2.key3".
 func (p *FilePersister) Set(key string, value interface{}) {
-	keys := strings.Split(key, ".")
-	lastKey := keys[len(keys)-1]
-	current := p.data
-
-	// Traverse or create intermediate maps
-	for _, k := range keys[:len(keys)-1] {
-		if _, ok := current[k]; !ok {
-			current[k] = make(map[string]interface{})
-		}
-		if nextMap, ok := current[k].(map[string]interface{}); ok {
-			current = nextMap
-		} else {
-			// Handle non-map conflicts
-			current[k] = make(map[string]interface{})
-			current = current[k].(map[string]interface{})
-		}
-	}
+	p.MemoryPersister.Set(key, value)
+	p.markDirty()
+}
 
-	// Set the final value (supports arrays, maps, etc.)
-	current[lastKey] = value
-	p.save()
+// Delete removes a key and any nested keys below it
+func (p *FilePersister) Delete(key string) {
+	p.MemoryPersister.Delete(key)
+	p.markDirty()
 }
 
-// Get retrieves a value using a nested key like "key1.key2.key3".
-func (p *FilePersister) Get(key string) interface{} {
-	keys := strings.Split(key, ".")
-	current := p.data
-
-	// Traverse nested maps
-	for _, k := range keys {
-		if nextMap, ok := current[k].(map[string]interface{}); ok {
-			current = nextMap
-		} else {
-			return current[k] // Return the value if it's not a map
-		}
+// markDirty schedules a save of the file
+func (p *FilePersister) markDirty() {
+	p.stateMu.Lock()
+	p.dirty = true
+	p.stateMu.Unlock()
+
+	select {
+	case p.flushChan <- struct{}{}:
+	default:
 	}
-	return nil // Key not found
 }
@@ -0,0 +1,69 @@
+package persist
+
+import (
+	"fmt"
+	"time"
+)
+
+// Persister backends
+const (
+	BackendFile   = "file"
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// Persister stores the application state under dotted keys like "mqtt.status"
+type Persister interface {
+	// Get returns the value of a key, or nil if the key is not set or holds nested keys
+	Get(key string) interface{}
+	// Set sets a value, replacing any nested keys below it
+	Set(key string, value interface{})
+	// Delete removes a key and any nested keys below it
+	Delete(key string)
+	// Keys returns the sorted keys of all values at or below a prefix. An
+	// empty prefix returns every key.
+	Keys(prefix string) []string
+	// Watch returns a channel that receives the changes at, below or above a
+	// prefix, and a function that stops the watch. Changes are dropped when
+	// the channel is full.
+	Watch(prefix string) (<-chan Change, func())
+	// Close saves any pending changes and releases the backend
+	Close() error
+}
+
+// Change is a single change to the state
+type Change struct {
+	Key     string
+	Value   interface{}
+	Deleted bool
+	Time    time.Time
+}
+
+// New creates a persister for a backend. The path is ignored by the memory backend.
+func New(backend, path string) (Persister, error) {
+	switch backend {
+	case BackendFile, "":
+		return NewFilePersister(path)
+	case BackendMemory:
+		return NewMemoryPersister(), nil
+	case BackendBolt:
+		return NewBoltPersister(path)
+	default:
+		return nil, fmt.Errorf("unknown persister backend %q, valid backends: '%s', '%s', '%s'", backend, BackendFile, BackendMemory, BackendBolt)
+	}
+}
+
+// Snapshot reads the saved state of a backend into memory without locking,
+// migrating or writing it, for example to inspect it from another process
+func Snapshot(backend, path string) (*MemoryPersister, error) {
+	switch backend {
+	case BackendFile, "":
+		return LoadFile(path)
+	case BackendBolt:
+		return snapshotBolt(path)
+	case BackendMemory:
+		return nil, fmt.Errorf("the %s backend does not save the state", BackendMemory)
+	default:
+		return nil, fmt.Errorf("unknown persister backend %q, valid backends: '%s', '%s', '%s'", backend, BackendFile, BackendMemory, BackendBolt)
+	}
+}
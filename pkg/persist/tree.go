@@ -0,0 +1,139 @@
+package persist
+
+import (
+	"sort"
+	"strings"
+)
+
+// setNested sets a value in a tree of maps using a nested key like "key1.key2.key3"
+func setNested(data map[string]interface{}, key string, value interface{}) {
+	keys := strings.Split(key, ".")
+	lastKey := keys[len(keys)-1]
+	current := data
+
+	// Traverse or create intermediate maps
+	for _, k := range keys[:len(keys)-1] {
+		if _, ok := current[k]; !ok {
+			current[k] = make(map[string]interface{})
+		}
+		if nextMap, ok := current[k].(map[string]interface{}); ok {
+			current = nextMap
+		} else {
+			// Handle non-map conflicts
+			current[k] = make(map[string]interface{})
+			current = current[k].(map[string]interface{})
+		}
+	}
+
+	// Set the final value (supports arrays, maps, etc.)
+	current[lastKey] = copyValue(value)
+}
+
+// getNested retrieves a value from a tree of maps using a nested key
+func getNested(data map[string]interface{}, key string) interface{} {
+	keys := strings.Split(key, ".")
+	current := data
+
+	// Traverse nested maps
+	for _, k := range keys {
+		if nextMap, ok := current[k].(map[string]interface{}); ok {
+			current = nextMap
+		} else {
+			return copyValue(current[k]) // Return the value if it's not a map
+		}
+	}
+	return nil // Key not found
+}
+
+// deleteNested removes a key from a tree of maps and reports whether it existed
+func deleteNested(data map[string]interface{}, key string) bool {
+	keys := strings.Split(key, ".")
+	current := data
+
+	for _, k := range keys[:len(keys)-1] {
+		nextMap, ok := current[k].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = nextMap
+	}
+
+	lastKey := keys[len(keys)-1]
+	if _, ok := current[lastKey]; !ok {
+		return false
+	}
+
+	delete(current, lastKey)
+	return true
+}
+
+// nestedKeys returns the sorted keys of the values at or below a prefix
+func nestedKeys(data map[string]interface{}, prefix string) []string {
+	var keys []string
+
+	var walk func(node map[string]interface{}, path string)
+	walk = func(node map[string]interface{}, path string) {
+		for k, value := range node {
+			key := k
+			if path != "" {
+				key = path + "." + k
+			}
+
+			if nextMap, ok := value.(map[string]interface{}); ok {
+				walk(nextMap, key)
+			} else if keyHasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	walk(data, "")
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Subtree returns the values at or below a prefix as a tree of maps, relative
+// to the prefix. It works with every backend, including those that store each
+// value under its own key.
+func Subtree(p Persister, prefix string) map[string]interface{} {
+	tree := make(map[string]interface{})
+
+	for _, key := range p.Keys(prefix) {
+		relative := strings.TrimPrefix(strings.TrimPrefix(key, prefix), ".")
+		if relative == "" {
+			continue
+		}
+
+		setNested(tree, relative, p.Get(key))
+	}
+
+	return tree
+}
+
+// keyHasPrefix reports whether a key is the prefix itself or nested below it
+func keyHasPrefix(key, prefix string) bool {
+	return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".")
+}
+
+// copyValue deep copies maps and slices so callers never share them with the
+// persister's data
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), v...)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,90 @@
+package persist
+
+import (
+	"strings"
+	"sync"
+)
+
+// watchBufferSize is the number of changes a watcher can fall behind before
+// changes are dropped
+const watchBufferSize = 64
+
+type watcher struct {
+	prefix string
+	ch     chan Change
+}
+
+// watchers fans changes out to the watchers of matching prefixes
+type watchers struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]*watcher
+	closed bool
+}
+
+func (w *watchers) add(prefix string) (<-chan Change, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan Change, watchBufferSize)
+	if w.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if w.subs == nil {
+		w.subs = make(map[int]*watcher)
+	}
+
+	id := w.nextID
+	w.nextID++
+	w.subs[id] = &watcher{prefix: prefix, ch: ch}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			if sub, ok := w.subs[id]; ok {
+				delete(w.subs, id)
+				close(sub.ch)
+			}
+		})
+	}
+}
+
+func (w *watchers) notify(change Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, sub := range w.subs {
+		if !watchMatches(sub.prefix, change.Key) {
+			continue
+		}
+
+		select {
+		case sub.ch <- change:
+		default:
+		}
+	}
+}
+
+// closeAll closes the channels of all watchers
+func (w *watchers) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, sub := range w.subs {
+		delete(w.subs, id)
+		close(sub.ch)
+	}
+
+	w.closed = true
+}
+
+// watchMatches reports whether a change to a key affects a watched prefix:
+// the key is at or below the prefix, or the key is a parent that was replaced
+func watchMatches(prefix, key string) bool {
+	return keyHasPrefix(key, prefix) || strings.HasPrefix(prefix, key+".")
+}
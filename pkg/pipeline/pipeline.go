@@ -0,0 +1,97 @@
+// Package pipeline passes the received messages through a chain of stages
+// that may change, drop or hold them before they reach the sinks.
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// Message is a message passing through the pipeline
+type Message struct {
+	Topic   string
+	Payload []byte
+	// Time is when the message was received
+	Time time.Time
+}
+
+// Stage processes a message and returns the messages to pass on to the next
+// stage. Returning no messages drops the message.
+type Stage interface {
+	Name() string
+	Process(message Message) ([]Message, error)
+}
+
+// ErrorHandler is called when a stage fails. The message is dropped.
+type ErrorHandler func(stage string, message Message, err error)
+
+// Counts are the number of messages that entered the pipeline, that left it
+// and that were dropped by a stage
+type Counts struct {
+	Received int64
+	Passed   int64
+	Dropped  int64
+}
+
+// Pipeline runs the stages in order. It is safe for concurrent use.
+type Pipeline struct {
+	mu      sync.Mutex
+	stages  []Stage
+	onError ErrorHandler
+	counts  Counts
+}
+
+// New creates a pipeline of stages
+func New(onError ErrorHandler, stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages, onError: onError}
+}
+
+// Process passes a message through every stage and returns the messages that
+// come out of the last one
+func (p *Pipeline) Process(message Message) []Message {
+	messages := []Message{message}
+	dropped := int64(0)
+
+	for _, stage := range p.stages {
+		var next []Message
+
+		for _, current := range messages {
+			out, err := stage.Process(current)
+			if err != nil {
+				if p.onError != nil {
+					p.onError(stage.Name(), current, err)
+				}
+				dropped++
+				continue
+			}
+
+			if len(out) == 0 {
+				dropped++
+			}
+			next = append(next, out...)
+		}
+
+		messages = next
+		if len(messages) == 0 {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.counts.Received++
+	p.counts.Passed += int64(len(messages))
+	p.counts.Dropped += dropped
+	p.mu.Unlock()
+
+	return messages
+}
+
+// TakeCounts returns the counts since the last call and resets them
+func (p *Pipeline) TakeCounts() Counts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := p.counts
+	p.counts = Counts{}
+	return counts
+}
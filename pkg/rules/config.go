@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/expr"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+)
+
+// Rule types
+const (
+	TypeThreshold  = "threshold"
+	TypeRate       = "rate"
+	TypeStale      = "stale"
+	TypeExpression = "expression"
+)
+
+// Severities, from least to most severe
+var Severities = []string{"info", "warning", "minor", "major", "critical"}
+
+// Config is the configuration of the rules engine
+type Config struct {
+	Points []Point
+	Rules  []Rule
+}
+
+// Point is a named value taken from the messages on a topic
+type Point struct {
+	Name string
+	// Topic is a topic filter that may contain the + and # wildcards
+	Topic string
+	// Field is the dotted path of the value in a JSON payload, for example
+	// data.temperature. When empty the whole payload is the value.
+	Field string
+}
+
+// Rule raises an alarm while its condition holds
+type Rule struct {
+	Name     string
+	Severity string
+	Type     string
+	// Point is the point a threshold, rate or stale rule watches
+	Point string
+	// Above and Below are the limits of a threshold or rate rule. A rate is
+	// the change per minute.
+	Above *float64
+	Below *float64
+	// Hysteresis is how far a value must return inside the limits before
+	// the alarm clears
+	Hysteresis float64
+	// Timeout is how long a point may go without a message before a stale
+	// rule raises its alarm
+	Timeout time.Duration
+	// Expression is a boolean expression over point names for an expression rule
+	Expression string
+	// MinDuration is how long the condition must hold, or be gone, before
+	// the alarm is raised or cleared
+	MinDuration time.Duration
+	Message     string
+}
+
+// Validate checks a configuration and returns every problem found
+func (c Config) Validate() []*field.Error {
+	var errs []*field.Error
+
+	points := map[string]bool{}
+	for i, point := range c.Points {
+		switch {
+		case point.Name == "":
+			errs = append(errs, field.Errorf(fmt.Sprintf("points.%d.name", i), "must not be empty"))
+		case points[point.Name]:
+			errs = append(errs, field.Errorf(fmt.Sprintf("points.%d.name", i), "duplicate point %q", point.Name))
+		}
+		points[point.Name] = true
+
+		if point.Topic == "" {
+			errs = append(errs, field.Errorf(fmt.Sprintf("points.%d.topic", i), "must not be empty"))
+		}
+	}
+
+	names := map[string]bool{}
+	for i, rule := range c.Rules {
+		prefix := fmt.Sprintf("rules.%d", i)
+
+		switch {
+		case rule.Name == "":
+			errs = append(errs, field.Errorf(prefix+".name", "must not be empty"))
+		case names[rule.Name]:
+			errs = append(errs, field.Errorf(prefix+".name", "duplicate rule %q", rule.Name))
+		}
+		names[rule.Name] = true
+
+		if !validSeverity(rule.Severity) {
+			errs = append(errs, field.Errorf(prefix+".severity", "invalid severity %q, valid severities: 'info', 'warning', 'minor', 'major', 'critical'", rule.Severity))
+		}
+
+		if rule.Hysteresis < 0 {
+			errs = append(errs, field.Errorf(prefix+".hysteresis", "must not be negative, got %g", rule.Hysteresis))
+		}
+
+		if rule.MinDuration < 0 {
+			errs = append(errs, field.Errorf(prefix+".min_duration", "must not be negative, got %s", rule.MinDuration))
+		}
+
+		switch rule.Type {
+		case TypeThreshold, TypeRate, TypeStale:
+			if !points[rule.Point] {
+				errs = append(errs, field.Errorf(prefix+".point", "unknown point %q", rule.Point))
+			}
+		case TypeExpression:
+		default:
+			errs = append(errs, field.Errorf(prefix+".type", "invalid rule type %q, valid types: 'threshold', 'rate', 'stale', 'expression'", rule.Type))
+			continue
+		}
+
+		switch rule.Type {
+		case TypeThreshold, TypeRate:
+			if rule.Above == nil && rule.Below == nil {
+				errs = append(errs, field.Errorf(prefix, "a %s rule needs above, below or both", rule.Type))
+			}
+		case TypeStale:
+			if rule.Timeout <= 0 {
+				errs = append(errs, field.Errorf(prefix+".timeout", "must be positive for a stale rule"))
+			}
+		case TypeExpression:
+			expression, err := expr.Parse(rule.Expression)
+			if err != nil {
+				errs = append(errs, field.Errorf(prefix+".expression", "%s", err))
+				continue
+			}
+
+			for _, name := range expression.Variables() {
+				if !points[name] {
+					errs = append(errs, field.Errorf(prefix+".expression", "unknown point %q", name))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func validSeverity(severity string) bool {
+	for _, valid := range Severities {
+		if severity == valid {
+			return true
+		}
+	}
+
+	return false
+}
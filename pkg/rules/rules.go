@@ -0,0 +1,424 @@
+// Package rules raises and clears alarms from the values in MQTT messages.
+// Rules compare a point with thresholds, limit its rate of change, watch for
+// points that stop updating, or evaluate a boolean expression across points.
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/expr"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+)
+
+// Alarm states
+const (
+	StateRaised  = "raised"
+	StateCleared = "cleared"
+)
+
+// Alarm is raised by a rule while its condition holds
+type Alarm struct {
+	Rule      string     `json:"rule"`
+	Severity  string     `json:"severity"`
+	State     string     `json:"state"`
+	Point     string     `json:"point,omitempty"`
+	Value     *float64   `json:"value,omitempty"`
+	Message   string     `json:"message"`
+	RaisedAt  time.Time  `json:"raised_at"`
+	ClearedAt *time.Time `json:"cleared_at,omitempty"`
+}
+
+// pointState is the latest value of a point
+type pointState struct {
+	value     interface{}
+	updatedAt time.Time
+	// rate is the change per minute between the last two numeric values
+	rate *float64
+}
+
+// ruleState tracks the condition of a rule between evaluations
+type ruleState struct {
+	rule       Rule
+	expression *expr.Expression
+	active     bool
+	alarm      Alarm
+	// pending is set while the condition differs from active but has not
+	// yet held for the minimum duration
+	pending bool
+	since   time.Time
+}
+
+// Engine evaluates the rules against the received messages. It is safe for
+// concurrent use.
+type Engine struct {
+	mu sync.Mutex
+	// notifyMu is taken before mu is released, so the changes are passed to
+	// onChange in the order they were made
+	notifyMu sync.Mutex
+	points   []Point
+	rules    []*ruleState
+	values   map[string]*pointState
+	started  time.Time
+	onChange func(alarm Alarm)
+}
+
+// New creates an engine for a valid configuration. onChange is called, without
+// holding the engine's lock, whenever an alarm is raised or cleared. The calls
+// are made one at a time in the order of the changes.
+func New(config Config, onChange func(alarm Alarm)) (*Engine, error) {
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	e := &Engine{
+		points:   config.Points,
+		values:   make(map[string]*pointState),
+		started:  time.Now(),
+		onChange: onChange,
+	}
+
+	for _, rule := range config.Rules {
+		state := &ruleState{rule: rule}
+		if rule.Type == TypeExpression {
+			state.expression = expr.MustParse(rule.Expression)
+		}
+		e.rules = append(e.rules, state)
+	}
+
+	return e, nil
+}
+
+// Restore marks the raised alarms of existing rules as active, so an alarm
+// that was raised before a restart is cleared instead of raised again
+func (e *Engine) Restore(alarms []Alarm) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, alarm := range alarms {
+		if alarm.State != StateRaised {
+			continue
+		}
+
+		for _, state := range e.rules {
+			if state.rule.Name == alarm.Rule {
+				state.active = true
+				state.alarm = alarm
+			}
+		}
+	}
+}
+
+// TakeOver carries the state of the engine a configuration change replaces
+// over to this one: the values and rate history of points with the same
+// topic and field, the start time stale rules are measured from, and the
+// state and pending raise or clear of unchanged rules. Call it before the
+// engine is used.
+func (e *Engine) TakeOver(previous *Engine) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.started = previous.started
+
+	for _, point := range e.points {
+		for _, old := range previous.points {
+			if old == point && previous.values[point.Name] != nil {
+				value := *previous.values[point.Name]
+				e.values[point.Name] = &value
+			}
+		}
+	}
+
+	for _, state := range e.rules {
+		for _, old := range previous.rules {
+			if old.rule.Name == state.rule.Name && reflect.DeepEqual(old.rule, state.rule) {
+				state.active = old.active
+				state.alarm = old.alarm
+				state.pending = old.pending
+				state.since = old.since
+			}
+		}
+	}
+}
+
+// Active returns the raised alarms, sorted by rule name
+func (e *Engine) Active() []Alarm {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.activeAlarms()
+}
+
+func (e *Engine) activeAlarms() []Alarm {
+	alarms := []Alarm{}
+	for _, state := range e.rules {
+		if state.active {
+			alarms = append(alarms, state.alarm)
+		}
+	}
+
+	sort.Slice(alarms, func(i, j int) bool { return alarms[i].Rule < alarms[j].Rule })
+	return alarms
+}
+
+// HandleMessage updates the points taken from a message and evaluates the
+// rules that use them
+func (e *Engine) HandleMessage(topic string, payload []byte, at time.Time) {
+	e.mu.Lock()
+
+	updated := map[string]bool{}
+	for _, point := range e.points {
+		if !mqttclient.TopicMatches(point.Topic, topic) {
+			continue
+		}
+
+		value, ok := field.FromPayload(payload, point.Field)
+		if !ok {
+			continue
+		}
+
+		e.updatePoint(point.Name, value, at)
+		updated[point.Name] = true
+	}
+
+	var changes []Alarm
+	if len(updated) > 0 {
+		for _, state := range e.rules {
+			if usesPoint(state, updated) {
+				changes = e.evaluate(state, at, changes)
+			}
+		}
+	}
+
+	e.unlockAndNotify(changes)
+}
+
+// Tick evaluates the stale rules and completes the raises and clears that
+// have waited for their minimum duration. Call it periodically.
+func (e *Engine) Tick(now time.Time) {
+	e.mu.Lock()
+
+	var changes []Alarm
+	for _, state := range e.rules {
+		if state.rule.Type == TypeStale || state.pending {
+			changes = e.evaluate(state, now, changes)
+		}
+	}
+
+	e.unlockAndNotify(changes)
+}
+
+// unlockAndNotify releases the lock and passes the changes to onChange
+func (e *Engine) unlockAndNotify(changes []Alarm) {
+	e.notifyMu.Lock()
+	defer e.notifyMu.Unlock()
+
+	e.mu.Unlock()
+
+	if e.onChange == nil {
+		return
+	}
+
+	for _, alarm := range changes {
+		e.onChange(alarm)
+	}
+}
+
+func (e *Engine) updatePoint(name string, value interface{}, at time.Time) {
+	previous := e.values[name]
+	current := &pointState{value: value, updatedAt: at}
+
+	if previous != nil {
+		last, lastOK := field.Number(previous.value)
+		next, nextOK := field.Number(value)
+		minutes := at.Sub(previous.updatedAt).Minutes()
+
+		if lastOK && nextOK && minutes > 0 {
+			rate := (next - last) / minutes
+			current.rate = &rate
+		}
+	}
+
+	e.values[name] = current
+}
+
+// evaluate checks the condition of a rule and appends the alarm to changes
+// when it is raised or cleared
+func (e *Engine) evaluate(state *ruleState, at time.Time, changes []Alarm) []Alarm {
+	condition, value, ok := e.condition(state, at)
+	if !ok {
+		return changes
+	}
+
+	if condition == state.active {
+		state.pending = false
+		return changes
+	}
+
+	if !state.pending {
+		state.pending = true
+		state.since = at
+	}
+
+	if at.Sub(state.since) < state.rule.MinDuration {
+		return changes
+	}
+
+	state.pending = false
+	state.active = condition
+
+	if condition {
+		state.alarm = Alarm{
+			Rule:     state.rule.Name,
+			Severity: state.rule.Severity,
+			State:    StateRaised,
+			Point:    state.rule.Point,
+			Value:    value,
+			Message:  message(state.rule, value),
+			RaisedAt: at,
+		}
+		return append(changes, state.alarm)
+	}
+
+	cleared := state.alarm
+	cleared.State = StateCleared
+	cleared.Value = value
+	cleared.ClearedAt = &at
+	return append(changes, cleared)
+}
+
+// condition reports whether the condition of a rule holds, with the value it
+// was decided on. It is not ok when the rule cannot be evaluated yet.
+func (e *Engine) condition(state *ruleState, at time.Time) (bool, *float64, bool) {
+	rule := state.rule
+
+	switch rule.Type {
+	case TypeThreshold:
+		point := e.values[rule.Point]
+		if point == nil {
+			return false, nil, false
+		}
+
+		value, ok := field.Number(point.value)
+		if !ok {
+			return false, nil, false
+		}
+
+		return outside(rule, value, state.active), &value, true
+
+	case TypeRate:
+		point := e.values[rule.Point]
+		if point == nil || point.rate == nil {
+			return false, nil, false
+		}
+
+		rate := *point.rate
+		return outside(rule, rate, state.active), &rate, true
+
+	case TypeStale:
+		last := e.started
+		if point := e.values[rule.Point]; point != nil {
+			last = point.updatedAt
+		}
+
+		age := at.Sub(last)
+		seconds := age.Seconds()
+		return age > rule.Timeout, &seconds, true
+
+	case TypeExpression:
+		result, err := state.expression.EvalBool(func(name string) (interface{}, bool) {
+			point := e.values[name]
+			if point == nil {
+				return nil, false
+			}
+			return point.value, true
+		})
+		if err != nil {
+			return false, nil, false
+		}
+
+		return result, nil, true
+	}
+
+	return false, nil, false
+}
+
+// outside reports whether a value is beyond the limits of a rule. An active
+// alarm only clears once the value is back inside the limits by the hysteresis.
+func outside(rule Rule, value float64, active bool) bool {
+	margin := 0.0
+	if active {
+		margin = rule.Hysteresis
+	}
+
+	if rule.Above != nil && value > *rule.Above-margin {
+		return true
+	}
+
+	if rule.Below != nil && value < *rule.Below+margin {
+		return true
+	}
+
+	return false
+}
+
+func usesPoint(state *ruleState, points map[string]bool) bool {
+	if state.expression == nil {
+		return points[state.rule.Point]
+	}
+
+	for _, name := range state.expression.Variables() {
+		if points[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// message returns the configured message of a rule or describes its condition
+func message(rule Rule, value *float64) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+
+	switch rule.Type {
+	case TypeThreshold:
+		return fmt.Sprintf("%s is %s, %s", rule.Point, formatNumber(value), limits(rule))
+	case TypeRate:
+		return fmt.Sprintf("%s changes by %s per minute, %s", rule.Point, formatNumber(value), limits(rule))
+	case TypeStale:
+		return fmt.Sprintf("no update of %s in %s", rule.Point, rule.Timeout)
+	default:
+		return fmt.Sprintf("%s is true", rule.Expression)
+	}
+}
+
+func limits(rule Rule) string {
+	var parts []string
+	if rule.Above != nil {
+		parts = append(parts, "limit above "+formatNumber(rule.Above))
+	}
+	if rule.Below != nil {
+		parts = append(parts, "limit below "+formatNumber(rule.Below))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatNumber(value *float64) string {
+	if value == nil {
+		return "-"
+	}
+
+	return strconv.FormatFloat(*value, 'g', 6, 64)
+}
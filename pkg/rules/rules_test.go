@@ -0,0 +1,346 @@
+package rules_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/rules"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+var testPoints = []rules.Point{
+	{Name: "supply_temp", Topic: "site/+/ahu1", Field: "temp"},
+	{Name: "fan_running", Topic: "site/+/ahu1/fan"},
+}
+
+// step is a message on a point, or a tick when payload is empty, at an offset
+// from the start of a test
+type step struct {
+	at      time.Duration
+	topic   string
+	payload string
+	// want are the states of the alarms changed by the step
+	want []string
+}
+
+// recorder collects the alarms passed to onChange
+type recorder struct {
+	alarms []rules.Alarm
+}
+
+func (r *recorder) onChange(alarm rules.Alarm) {
+	r.alarms = append(r.alarms, alarm)
+}
+
+func (r *recorder) take() []string {
+	var states []string
+	for _, alarm := range r.alarms {
+		states = append(states, alarm.State)
+	}
+	r.alarms = nil
+	return states
+}
+
+func newEngine(t *testing.T, rule rules.Rule, onChange func(rules.Alarm)) *rules.Engine {
+	t.Helper()
+
+	e, err := rules.New(rules.Config{Points: testPoints, Rules: []rules.Rule{rule}}, onChange)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return e
+}
+
+func runSteps(t *testing.T, e *rules.Engine, r *recorder, start time.Time, steps []step) {
+	t.Helper()
+
+	for i, s := range steps {
+		at := start.Add(s.at)
+		if s.payload == "" {
+			e.Tick(at)
+		} else {
+			e.HandleMessage(s.topic, []byte(s.payload), at)
+		}
+
+		if got := r.take(); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("step %d at %s: changes = %v, want %v", i, s.at, got, s.want)
+		}
+	}
+}
+
+func temp(at time.Duration, value string, want ...string) step {
+	return step{at: at, topic: "site/plant1/ahu1", payload: `{"temp": ` + value + `}`, want: want}
+}
+
+func tick(at time.Duration, want ...string) step {
+	return step{at: at, want: want}
+}
+
+func TestRaiseAndClear(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  rules.Rule
+		steps []step
+	}{
+		{
+			name: "threshold",
+			rule: rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30)},
+			steps: []step{
+				temp(0, "29"),
+				temp(time.Second, "31", rules.StateRaised),
+				temp(2*time.Second, "32"),
+				temp(3*time.Second, "29.5", rules.StateCleared),
+			},
+		},
+		{
+			name: "threshold with hysteresis",
+			rule: rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30), Hysteresis: 1},
+			steps: []step{
+				temp(0, "30.5", rules.StateRaised),
+				temp(time.Second, "29.5"),
+				temp(2*time.Second, "29.1"),
+				temp(3*time.Second, "29", rules.StateCleared),
+				temp(4*time.Second, "29.5"),
+				temp(5*time.Second, "30.1", rules.StateRaised),
+			},
+		},
+		{
+			name: "below with hysteresis",
+			rule: rules.Rule{Name: "low", Severity: "minor", Type: rules.TypeThreshold, Point: "supply_temp", Below: float(10), Hysteresis: 2},
+			steps: []step{
+				temp(0, "9", rules.StateRaised),
+				temp(time.Second, "11"),
+				temp(2*time.Second, "12.5", rules.StateCleared),
+			},
+		},
+		{
+			name: "min duration",
+			rule: rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30), MinDuration: time.Minute},
+			steps: []step{
+				temp(0, "31"),
+				tick(30 * time.Second),
+				temp(50*time.Second, "31"),
+				tick(time.Minute, rules.StateRaised),
+				temp(70*time.Second, "29"),
+				// The condition came back before the clear was due
+				temp(80*time.Second, "31"),
+				tick(140 * time.Second),
+				temp(150*time.Second, "29"),
+				tick(200 * time.Second),
+				tick(210*time.Second, rules.StateCleared),
+			},
+		},
+		{
+			name: "min duration restarts when the condition is gone",
+			rule: rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30), MinDuration: time.Minute},
+			steps: []step{
+				temp(0, "31"),
+				temp(30*time.Second, "29"),
+				temp(40*time.Second, "31"),
+				tick(time.Minute),
+				tick(100*time.Second, rules.StateRaised),
+			},
+		},
+		{
+			name: "rate",
+			rule: rules.Rule{Name: "rising", Severity: "warning", Type: rules.TypeRate, Point: "supply_temp", Above: float(2)},
+			steps: []step{
+				temp(0, "20"),
+				temp(time.Minute, "21"),
+				temp(2*time.Minute, "24", rules.StateRaised),
+				temp(3*time.Minute, "25", rules.StateCleared),
+			},
+		},
+		{
+			name: "expression",
+			rule: rules.Rule{Name: "fan_off_while_hot", Severity: "critical", Type: rules.TypeExpression, Expression: "!fan_running && supply_temp > 28"},
+			steps: []step{
+				temp(0, "29"),
+				{at: time.Second, topic: "site/plant1/ahu1/fan", payload: "false", want: []string{rules.StateRaised}},
+				{at: 2 * time.Second, topic: "site/plant1/ahu1/fan", payload: "true", want: []string{rules.StateCleared}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			e := newEngine(t, tt.rule, r.onChange)
+
+			runSteps(t, e, r, time.Now(), tt.steps)
+		})
+	}
+}
+
+func TestStaleFromStart(t *testing.T) {
+	rule := rules.Rule{Name: "silent", Severity: "minor", Type: rules.TypeStale, Point: "supply_temp", Timeout: 5 * time.Minute}
+
+	r := &recorder{}
+	start := time.Now()
+	e := newEngine(t, rule, r.onChange)
+
+	// A point that never updates is stale once the timeout passed since the start
+	runSteps(t, e, r, start, []step{
+		tick(4 * time.Minute),
+		tick(6*time.Minute, rules.StateRaised),
+		temp(7*time.Minute, "20", rules.StateCleared),
+		tick(11 * time.Minute),
+		tick(13*time.Minute, rules.StateRaised),
+	})
+
+	// The age is measured from the last message once there is one
+	alarm := e.Active()[0]
+	if alarm.Value == nil || *alarm.Value != 6*60 {
+		t.Errorf("Value = %v, want %d seconds", alarm.Value, 6*60)
+	}
+}
+
+func TestTakeOver(t *testing.T) {
+	high := rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30), MinDuration: time.Minute}
+	silent := rules.Rule{Name: "silent", Severity: "minor", Type: rules.TypeStale, Point: "fan_running", Timeout: time.Second}
+
+	r := &recorder{}
+	start := time.Now()
+	previous, err := rules.New(rules.Config{Points: testPoints, Rules: []rules.Rule{high, silent}}, r.onChange)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	runSteps(t, previous, r, start, []step{
+		temp(0, "31"),
+		temp(70*time.Second, "31", rules.StateRaised),
+		// The clear is pending when the configuration changes
+		temp(80*time.Second, "29"),
+	})
+
+	// The stale rule is changed, the threshold rule is not. The new engine is
+	// created later, so its own start time is after the previous one.
+	changed := silent
+	changed.Timeout = time.Hour
+	time.Sleep(50 * time.Millisecond)
+
+	next, err := rules.New(rules.Config{Points: testPoints, Rules: []rules.Rule{high, changed}}, r.onChange)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	next.TakeOver(previous)
+
+	active := next.Active()
+	if len(active) != 1 || active[0].Rule != "high" {
+		t.Fatalf("Active() = %+v, want the high alarm", active)
+	}
+
+	// The pending clear completes a minute after it started, and the stale
+	// rule is measured from the start of the previous engine
+	runSteps(t, next, r, start, []step{
+		tick(139 * time.Second),
+		tick(140*time.Second, rules.StateCleared),
+		tick(time.Hour+25*time.Millisecond, rules.StateRaised),
+	})
+}
+
+func TestTakeOverDropsChangedRules(t *testing.T) {
+	high := rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30)}
+
+	r := &recorder{}
+	start := time.Now()
+	previous := newEngine(t, high, r.onChange)
+	runSteps(t, previous, r, start, []step{temp(0, "31", rules.StateRaised)})
+
+	changed := high
+	changed.Above = float(35)
+
+	next := newEngine(t, changed, r.onChange)
+	next.TakeOver(previous)
+
+	if active := next.Active(); len(active) != 0 {
+		t.Errorf("Active() = %+v, want none", active)
+	}
+
+	runSteps(t, next, r, start, []step{temp(time.Second, "36", rules.StateRaised)})
+}
+
+func TestRestore(t *testing.T) {
+	high := rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30)}
+
+	r := &recorder{}
+	e := newEngine(t, high, r.onChange)
+	e.Restore([]rules.Alarm{
+		{Rule: "high", State: rules.StateRaised},
+		{Rule: "removed", State: rules.StateRaised},
+	})
+
+	// The restored alarm is not raised again, only cleared
+	runSteps(t, e, r, time.Now(), []step{
+		temp(0, "31"),
+		temp(time.Second, "29", rules.StateCleared),
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  rules.Rule
+		paths []string
+	}{
+		{
+			name: "valid",
+			rule: rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30)},
+		},
+		{
+			name:  "no limits",
+			rule:  rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp"},
+			paths: []string{"rules.0"},
+		},
+		{
+			name:  "unknown point and severity",
+			rule:  rules.Rule{Name: "high", Severity: "bad", Type: rules.TypeRate, Point: "nope", Below: float(0)},
+			paths: []string{"rules.0.severity", "rules.0.point"},
+		},
+		{
+			name:  "negative hysteresis and min duration",
+			rule:  rules.Rule{Name: "high", Severity: "major", Type: rules.TypeThreshold, Point: "supply_temp", Above: float(30), Hysteresis: -1, MinDuration: -time.Second},
+			paths: []string{"rules.0.hysteresis", "rules.0.min_duration"},
+		},
+		{
+			name:  "stale without timeout",
+			rule:  rules.Rule{Name: "silent", Severity: "minor", Type: rules.TypeStale, Point: "supply_temp"},
+			paths: []string{"rules.0.timeout"},
+		},
+		{
+			name:  "expression with an unknown point",
+			rule:  rules.Rule{Name: "expr", Severity: "minor", Type: rules.TypeExpression, Expression: "supply_temp > other"},
+			paths: []string{"rules.0.expression"},
+		},
+		{
+			name:  "invalid expression",
+			rule:  rules.Rule{Name: "expr", Severity: "minor", Type: rules.TypeExpression, Expression: "supply_temp >"},
+			paths: []string{"rules.0.expression"},
+		},
+		{
+			name:  "unknown type",
+			rule:  rules.Rule{Name: "x", Severity: "minor", Type: "other"},
+			paths: []string{"rules.0.type"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := rules.Config{Points: testPoints, Rules: []rules.Rule{tt.rule}}.Validate()
+
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Path)
+			}
+
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("Validate() paths = %v, want %v (%v)", paths, tt.paths, errs)
+			}
+		})
+	}
+}
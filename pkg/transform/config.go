@@ -0,0 +1,186 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/expr"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+)
+
+// Config is the configuration of the transform stage
+type Config struct {
+	// Passthrough passes messages that no transform matches on unchanged.
+	// Otherwise they are dropped.
+	Passthrough bool
+	// Metadata is added to the output of every transform
+	Metadata map[string]interface{}
+	// Transforms are tried in order and the first that matches is applied
+	Transforms []Transform
+}
+
+// Transform rewrites the messages on the topics it matches
+type Transform struct {
+	Name string
+	// Match is a topic filter. Each + and # wildcard captures the levels it
+	// matches, numbered from 1.
+	Match string
+	// Topic is the output topic. {1}, {2} are replaced by the captures and
+	// {name} by a metadata value. The topic is unchanged when empty.
+	Topic string
+	// Filter is a boolean expression; messages for which it is false are dropped
+	Filter string
+	// Fields are set in the output payload, in order
+	Fields []Field
+	// Keep starts the output from the fields of the input payload. Otherwise
+	// the output only has the declared fields and the metadata.
+	Keep bool
+	// Remove lists the dotted input fields left out of a kept payload
+	Remove []string
+	// Metadata is added to the output, over the global metadata
+	Metadata map[string]interface{}
+}
+
+// Field sets a dotted output field to the result of an expression. The
+// expression can read the input fields by their dotted path, the whole
+// payload as payload, the topic as topic, the captures as topic.1, topic.2
+// and the metadata as meta.name.
+type Field struct {
+	Name       string
+	Expression string
+}
+
+// Validate checks a configuration and returns every problem found
+func (c Config) Validate() []*field.Error {
+	var errs []*field.Error
+
+	names := map[string]bool{}
+	for i, transform := range c.Transforms {
+		prefix := fmt.Sprintf("transforms.%d", i)
+
+		switch {
+		case transform.Name == "":
+			errs = append(errs, field.Errorf(prefix+".name", "must not be empty"))
+		case names[transform.Name]:
+			errs = append(errs, field.Errorf(prefix+".name", "duplicate transform %q", transform.Name))
+		}
+		names[transform.Name] = true
+
+		wildcards, err := countWildcards(transform.Match)
+		if err != nil {
+			errs = append(errs, field.Errorf(prefix+".match", "%s", err))
+		}
+
+		metadata := mergeMetadata(c.Metadata, transform.Metadata)
+
+		if _, err := parseTemplate(transform.Topic, wildcards, metadata); err != nil {
+			errs = append(errs, field.Errorf(prefix+".topic", "%s", err))
+		}
+
+		if transform.Filter != "" {
+			if err := checkExpression(transform.Filter, wildcards, metadata); err != nil {
+				errs = append(errs, field.Errorf(prefix+".filter", "%s", err))
+			}
+		}
+
+		if len(transform.Fields) == 0 && !transform.Keep && transform.Topic == "" {
+			errs = append(errs, field.Errorf(prefix, "a transform needs fields, keep or a topic"))
+		}
+
+		for j, computed := range transform.Fields {
+			if !validPath(computed.Name) {
+				errs = append(errs, field.Errorf(fmt.Sprintf("%s.fields.%d.name", prefix, j), "must be a dotted field name such as data.temperature, got %q", computed.Name))
+			}
+
+			if err := checkExpression(computed.Expression, wildcards, metadata); err != nil {
+				errs = append(errs, field.Errorf(fmt.Sprintf("%s.fields.%d.expression", prefix, j), "%s", err))
+			}
+		}
+
+		for j, path := range transform.Remove {
+			if !validPath(path) {
+				errs = append(errs, field.Errorf(fmt.Sprintf("%s.remove.%d", prefix, j), "must be a dotted field name, got %q", path))
+			}
+		}
+	}
+
+	return errs
+}
+
+// countWildcards checks a topic filter and returns the number of wildcards
+func countWildcards(filter string) (int, error) {
+	if filter == "" {
+		return 0, fmt.Errorf("must not be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	count := 0
+
+	for i, level := range levels {
+		switch {
+		case level == "+":
+			count++
+		case level == "#":
+			if i != len(levels)-1 {
+				return 0, fmt.Errorf("# must be the last level of %q", filter)
+			}
+			count++
+		case strings.ContainsAny(level, "+#"):
+			return 0, fmt.Errorf("wildcards must be a whole level in %q", filter)
+		}
+	}
+
+	return count, nil
+}
+
+// checkExpression parses an expression and checks the captures and metadata it reads
+func checkExpression(source string, captures int, metadata map[string]interface{}) error {
+	expression, err := expr.Parse(source)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expression.Variables() {
+		switch {
+		case strings.HasPrefix(name, "topic."):
+			index, err := strconv.Atoi(strings.TrimPrefix(name, "topic."))
+			if err != nil || index < 1 || index > captures {
+				return fmt.Errorf("%s is not a capture of the match, which has %d", name, captures)
+			}
+		case strings.HasPrefix(name, "meta."):
+			if _, ok := metadata[strings.TrimPrefix(name, "meta.")]; !ok {
+				return fmt.Errorf("unknown metadata %q", strings.TrimPrefix(name, "meta."))
+			}
+		}
+	}
+
+	return nil
+}
+
+func validPath(path string) bool {
+	if path == "" {
+		return false
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mergeMetadata returns the global metadata overridden by the transform's own
+func mergeMetadata(global, own map[string]interface{}) map[string]interface{} {
+	metadata := make(map[string]interface{}, len(global)+len(own))
+	for key, value := range global {
+		metadata[key] = value
+	}
+	for key, value := range own {
+		metadata[key] = value
+	}
+
+	return metadata
+}
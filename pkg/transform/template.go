@@ -0,0 +1,83 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// templatePart is a literal, a capture (from 1) or a metadata name of a topic template
+type templatePart struct {
+	literal string
+	capture int
+	meta    string
+}
+
+// parseTemplate parses an output topic with {1} style captures and {name}
+// metadata placeholders
+func parseTemplate(template string, captures int, metadata map[string]interface{}) ([]templatePart, error) {
+	var parts []templatePart
+
+	rest := template
+	for rest != "" {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected } in %q", template)
+		}
+
+		if start > 0 {
+			parts = append(parts, templatePart{literal: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("missing } in %q", template)
+		}
+
+		name := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+
+		if index, err := strconv.Atoi(name); err == nil {
+			if index < 1 || index > captures {
+				return nil, fmt.Errorf("{%d} is not a capture of the match, which has %d", index, captures)
+			}
+			parts = append(parts, templatePart{capture: index})
+			continue
+		}
+
+		if _, ok := metadata[name]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s}, use a capture such as {1} or a metadata name", name)
+		}
+		parts = append(parts, templatePart{meta: name})
+	}
+
+	for _, part := range parts {
+		if strings.ContainsAny(part.literal, "+#") {
+			return nil, fmt.Errorf("output topic %q must not contain wildcards", template)
+		}
+	}
+
+	return parts, nil
+}
+
+func renderTemplate(parts []templatePart, captures []string, metadata map[string]interface{}) string {
+	var topic strings.Builder
+
+	for _, part := range parts {
+		switch {
+		case part.capture > 0:
+			topic.WriteString(captures[part.capture-1])
+		case part.meta != "":
+			topic.WriteString(fmt.Sprint(metadata[part.meta]))
+		default:
+			topic.WriteString(part.literal)
+		}
+	}
+
+	return topic.String()
+}
@@ -0,0 +1,240 @@
+// Package transform normalises messages from different vendors. A transform
+// rewrites the topic with the levels captured by its wildcards, computes
+// payload fields with expressions, for example to rename, scale or convert
+// units, and enriches the payload with static metadata.
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/expr"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/field"
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+	"github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/pipeline"
+)
+
+// Transformer is the transform stage of the pipeline
+type Transformer struct {
+	passthrough bool
+	transforms  []*compiled
+}
+
+type compiled struct {
+	name     string
+	match    string
+	topic    []templatePart
+	filter   *expr.Expression
+	fields   []compiledField
+	keep     bool
+	remove   []string
+	metadata map[string]interface{}
+}
+
+type compiledField struct {
+	name       string
+	expression *expr.Expression
+}
+
+// New creates the transform stage for a valid configuration
+func New(config Config) (*Transformer, error) {
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	t := &Transformer{passthrough: config.Passthrough}
+
+	for _, transform := range config.Transforms {
+		wildcards, _ := countWildcards(transform.Match)
+		metadata := mergeMetadata(config.Metadata, transform.Metadata)
+		topic, _ := parseTemplate(transform.Topic, wildcards, metadata)
+
+		c := &compiled{
+			name:     transform.Name,
+			match:    transform.Match,
+			topic:    topic,
+			keep:     transform.Keep,
+			remove:   transform.Remove,
+			metadata: metadata,
+		}
+
+		if transform.Filter != "" {
+			c.filter = expr.MustParse(transform.Filter)
+		}
+
+		for _, field := range transform.Fields {
+			c.fields = append(c.fields, compiledField{name: field.Name, expression: expr.MustParse(field.Expression)})
+		}
+
+		t.transforms = append(t.transforms, c)
+	}
+
+	return t, nil
+}
+
+// Name returns the name of the stage
+func (t *Transformer) Name() string {
+	return "transform"
+}
+
+// Process applies the first matching transform to a message
+func (t *Transformer) Process(message pipeline.Message) ([]pipeline.Message, error) {
+	for _, transform := range t.transforms {
+		captures, ok := capture(transform.match, message.Topic)
+		if !ok {
+			continue
+		}
+
+		out, keep, err := transform.apply(message, captures)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", transform.name, err)
+		}
+
+		if !keep {
+			return nil, nil
+		}
+
+		return []pipeline.Message{out}, nil
+	}
+
+	if t.passthrough {
+		return []pipeline.Message{message}, nil
+	}
+
+	return nil, nil
+}
+
+func (c *compiled) apply(message pipeline.Message, captures []string) (pipeline.Message, bool, error) {
+	var payload interface{}
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		payload = strings.TrimSpace(string(message.Payload))
+	}
+
+	vars := func(name string) (interface{}, bool) {
+		switch {
+		case name == "payload":
+			return payload, true
+		case name == "topic":
+			return message.Topic, true
+		case strings.HasPrefix(name, "topic."):
+			index, err := strconv.Atoi(strings.TrimPrefix(name, "topic."))
+			if err != nil || index < 1 || index > len(captures) {
+				return nil, false
+			}
+			return captures[index-1], true
+		case strings.HasPrefix(name, "meta."):
+			value, ok := c.metadata[strings.TrimPrefix(name, "meta.")]
+			return value, ok
+		}
+
+		return field.Lookup(payload, name)
+	}
+
+	if c.filter != nil {
+		pass, err := c.filter.EvalBool(vars)
+		if err != nil {
+			return message, false, fmt.Errorf("filter: %w", err)
+		}
+		if !pass {
+			return message, false, nil
+		}
+	}
+
+	// The fields are evaluated before the output is built, so they all read
+	// the unchanged input
+	values := make([]interface{}, len(c.fields))
+	for i, field := range c.fields {
+		value, err := field.expression.Eval(vars)
+		if err != nil {
+			return message, false, fmt.Errorf("field %s: %w", field.name, err)
+		}
+		values[i] = value
+	}
+
+	output := map[string]interface{}{}
+	if object, ok := payload.(map[string]interface{}); ok && c.keep {
+		output = object
+		for _, path := range c.remove {
+			remove(output, path)
+		}
+	}
+
+	for key, value := range c.metadata {
+		set(output, key, value)
+	}
+
+	for i, field := range c.fields {
+		set(output, field.name, values[i])
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return message, false, err
+	}
+
+	out := message
+	out.Payload = data
+	if len(c.topic) > 0 {
+		out.Topic = renderTemplate(c.topic, captures, c.metadata)
+	}
+
+	return out, true, nil
+}
+
+// capture matches a topic against a filter and returns the levels matched by
+// each wildcard. A # captures all remaining levels joined with a slash.
+func capture(filter, topic string) ([]string, bool) {
+	if !mqttclient.TopicMatches(filter, topic) {
+		return nil, false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	var captures []string
+	for i, level := range filterLevels {
+		switch level {
+		case "+":
+			captures = append(captures, topicLevels[i])
+		case "#":
+			if i < len(topicLevels) {
+				captures = append(captures, strings.Join(topicLevels[i:], "/"))
+			} else {
+				captures = append(captures, "")
+			}
+		}
+	}
+
+	return captures, true
+}
+
+// set sets the value at a dotted path, creating the objects on the way
+func set(object map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			object[key] = child
+		}
+		object = child
+	}
+
+	object[keys[len(keys)-1]] = value
+}
+
+// remove deletes the value at a dotted path
+func remove(object map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := object[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		object = child
+	}
+
+	delete(object, keys[len(keys)-1])
+}
@@ -0,0 +1,96 @@
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern derives a device ID from a topic. It is a topic filter in which
+// segments written as {name} match one level and are captured; the ID is the
+// captured segments joined with a slash. For example site/{site}/{device}/#
+// gives plant1/ahu3 for the topic site/plant1/ahu3/temperature.
+type Pattern struct {
+	source   string
+	segments []string
+}
+
+// ParsePattern parses a device pattern
+func ParsePattern(pattern string) (*Pattern, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern must not be empty")
+	}
+
+	segments := strings.Split(pattern, "/")
+	captures := 0
+
+	for i, segment := range segments {
+		switch {
+		case segment == "#":
+			if i != len(segments)-1 {
+				return nil, fmt.Errorf("# must be the last segment of %q", pattern)
+			}
+		case isCapture(segment):
+			name := segment[1 : len(segment)-1]
+			if name == "" || strings.ContainsAny(name, "{}#+") {
+				return nil, fmt.Errorf("invalid capture %q in %q, use a name such as {device}", segment, pattern)
+			}
+			captures++
+		case strings.ContainsAny(segment, "{}#+") && segment != "+":
+			return nil, fmt.Errorf("invalid segment %q in %q, wildcards and captures must be a whole segment", segment, pattern)
+		}
+	}
+
+	if captures == 0 {
+		return nil, fmt.Errorf("pattern %q must capture at least one segment, such as {device}", pattern)
+	}
+
+	return &Pattern{source: pattern, segments: segments}, nil
+}
+
+// String returns the source of the pattern
+func (p *Pattern) String() string {
+	return p.source
+}
+
+// Match returns the device ID of a topic, or false when the topic does not
+// match the pattern
+func (p *Pattern) Match(topic string) (string, bool) {
+	levels := strings.Split(topic, "/")
+
+	// Topics starting with $ are not matched by wildcards in the first level
+	if strings.HasPrefix(topic, "$") && (p.segments[0] == "+" || p.segments[0] == "#" || isCapture(p.segments[0])) {
+		return "", false
+	}
+
+	var captured []string
+	for i, segment := range p.segments {
+		if segment == "#" {
+			return strings.Join(captured, "/"), true
+		}
+
+		if i >= len(levels) {
+			return "", false
+		}
+
+		switch {
+		case isCapture(segment):
+			if levels[i] == "" {
+				return "", false
+			}
+			captured = append(captured, levels[i])
+		case segment == "+":
+		case segment != levels[i]:
+			return "", false
+		}
+	}
+
+	if len(levels) != len(p.segments) {
+		return "", false
+	}
+
+	return strings.Join(captured, "/"), true
+}
+
+func isCapture(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && len(segment) >= 2
+}
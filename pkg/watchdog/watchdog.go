@@ -0,0 +1,259 @@
+// Package watchdog tracks when each device last sent a message and flags the
+// devices that are silent for longer than their expected interval, even while
+// the connection to the broker is healthy.
+package watchdog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mqttclient "github.com/JohandrevanDeventer/bms-mqtt-client-cli/pkg/mqtt"
+)
+
+// Device statuses
+const (
+	StatusOnline = "online"
+	StatusSilent = "silent"
+)
+
+// Event types
+const (
+	EventSilent    = "device_silent"
+	EventRecovered = "device_recovered"
+)
+
+// Config is the configuration of the watchdog
+type Config struct {
+	// Pattern derives the device ID from a topic, see Pattern
+	Pattern string
+	// Interval is how long a device may be silent before it is flagged
+	Interval time.Duration
+	// Overrides set the interval of the devices they match
+	Overrides []Override
+}
+
+// Override sets the expected interval of matching devices. Device is a device
+// ID or a filter over device IDs with the + and # wildcards.
+type Override struct {
+	Device   string
+	Interval time.Duration
+}
+
+// Validate checks a configuration
+func (c Config) Validate() error {
+	if _, err := ParsePattern(c.Pattern); err != nil {
+		return err
+	}
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	for _, override := range c.Overrides {
+		if override.Device == "" {
+			return fmt.Errorf("override device must not be empty")
+		}
+
+		if override.Interval <= 0 {
+			return fmt.Errorf("interval of %s must be positive", override.Device)
+		}
+	}
+
+	return nil
+}
+
+// Device is the last seen state of a device
+type Device struct {
+	ID       string
+	Topic    string
+	Status   string
+	LastSeen time.Time
+	Interval time.Duration
+	Messages int64
+	// SilentSince is when the device was flagged as silent
+	SilentSince *time.Time
+
+	// watchedFrom is when a restored device started to be watched, its
+	// silence is counted from then until it sends a message
+	watchedFrom time.Time
+}
+
+// Event is sent when a device goes silent or reports again
+type Event struct {
+	Type     string
+	Device   string
+	Time     time.Time
+	LastSeen time.Time
+	// Silence is how long the device was silent
+	Silence time.Duration
+}
+
+// Watchdog tracks the devices. It is safe for concurrent use.
+type Watchdog struct {
+	mu        sync.Mutex
+	pattern   *Pattern
+	interval  time.Duration
+	overrides []Override
+	devices   map[string]*Device
+	onEvent   func(event Event)
+}
+
+// New creates a watchdog for a valid configuration. onEvent is called, without
+// holding the watchdog's lock, when a device goes silent or recovers.
+func New(config Config, onEvent func(event Event)) (*Watchdog, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	pattern, _ := ParsePattern(config.Pattern)
+
+	return &Watchdog{
+		pattern:   pattern,
+		interval:  config.Interval,
+		overrides: config.Overrides,
+		devices:   make(map[string]*Device),
+		onEvent:   onEvent,
+	}, nil
+}
+
+// Restore adds previously seen devices. Devices whose topic no longer gives
+// the same ID with the current pattern are skipped, and the interval is taken
+// from the current configuration. Their silence is counted from no earlier
+// than since, normally the start of the application, so the downtime does not
+// flag every device on the first check.
+func (w *Watchdog) Restore(devices []Device, since time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, device := range devices {
+		device := device
+		if id, ok := w.pattern.Match(device.Topic); !ok || id != device.ID {
+			continue
+		}
+
+		device.Interval = w.intervalFor(device.ID)
+		if device.LastSeen.Before(since) {
+			device.watchedFrom = since
+		}
+		w.devices[device.ID] = &device
+	}
+}
+
+// Seen records a message on a topic. It returns the device ID, or false when
+// the topic does not match the pattern.
+func (w *Watchdog) Seen(topic string, at time.Time) (string, bool) {
+	id, ok := w.pattern.Match(topic)
+	if !ok {
+		return "", false
+	}
+
+	w.mu.Lock()
+
+	device := w.devices[id]
+	if device == nil {
+		device = &Device{ID: id, Interval: w.intervalFor(id)}
+		w.devices[id] = device
+	}
+
+	var event *Event
+	if device.Status == StatusSilent {
+		event = &Event{
+			Type:     EventRecovered,
+			Device:   id,
+			Time:     at,
+			LastSeen: device.LastSeen,
+			Silence:  at.Sub(device.LastSeen),
+		}
+	}
+
+	device.Topic = topic
+	device.Status = StatusOnline
+	device.LastSeen = at
+	device.Messages++
+	device.SilentSince = nil
+	device.watchedFrom = time.Time{}
+
+	w.mu.Unlock()
+
+	if event != nil {
+		w.emit([]Event{*event})
+	}
+
+	return id, true
+}
+
+// Check flags the devices that have been silent for longer than their
+// interval. Call it periodically.
+func (w *Watchdog) Check(now time.Time) {
+	w.mu.Lock()
+
+	var events []Event
+	for _, device := range w.devices {
+		if device.Status == StatusSilent || now.Sub(device.silentFrom()) <= device.Interval {
+			continue
+		}
+
+		device.Status = StatusSilent
+		device.SilentSince = &now
+
+		events = append(events, Event{
+			Type:     EventSilent,
+			Device:   device.ID,
+			Time:     now,
+			LastSeen: device.LastSeen,
+			Silence:  now.Sub(device.LastSeen),
+		})
+	}
+
+	w.mu.Unlock()
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Device < events[j].Device })
+	w.emit(events)
+}
+
+// Devices returns the tracked devices, sorted by ID
+func (w *Watchdog) Devices() []Device {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	devices := make([]Device, 0, len(w.devices))
+	for _, device := range w.devices {
+		devices = append(devices, *device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+func (w *Watchdog) emit(events []Event) {
+	if w.onEvent == nil {
+		return
+	}
+
+	for _, event := range events {
+		w.onEvent(event)
+	}
+}
+
+// silentFrom returns when the silence of a device started to count
+func (d *Device) silentFrom() time.Time {
+	if d.watchedFrom.After(d.LastSeen) {
+		return d.watchedFrom
+	}
+
+	return d.LastSeen
+}
+
+// intervalFor returns the interval of the last matching override, or the default
+func (w *Watchdog) intervalFor(id string) time.Duration {
+	interval := w.interval
+	for _, override := range w.overrides {
+		if override.Device == id || mqttclient.TopicMatches(override.Device, id) {
+			interval = override.Interval
+		}
+	}
+
+	return interval
+}